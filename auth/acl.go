@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Access is the access that an ACL rule grants.
+type Access byte
+
+// Access values.
+const (
+	Read      Access = 1 << iota // Subscribe
+	Write                        // Publish
+	ReadWrite = Read | Write
+)
+
+func parseAccess(s string) (Access, bool) {
+	switch s {
+	case "read":
+		return Read, true
+	case "write":
+		return Write, true
+	case "readwrite":
+		return ReadWrite, true
+	}
+	return 0, false
+}
+
+type aclRule struct {
+	access Access
+	filter string
+}
+
+// ACL is an Authorizer that authorizes clients based on access control rules.
+//
+// Each line of an ACL file contains one of the following:
+//
+//	user <username>
+//	topic [read|write|readwrite] <topic filter>
+//	pattern [read|write|readwrite] <topic filter>
+//
+// A topic line grants access to the user of the last user line, or to
+// anonymous clients if there was no user line yet. A pattern line grants access
+// to all clients, after replacing %c in the topic filter by the client
+// identifier and %u by the username. If the access is omitted, readwrite is
+// used. Empty lines and lines that start with # are ignored.
+type ACL struct {
+	anonymous []aclRule
+	users     map[string][]aclRule
+	patterns  []aclRule
+}
+
+// ParseACL parses an ACL file.
+func ParseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{users: make(map[string][]aclRule)}
+	var (
+		user    string
+		hasUser bool
+	)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch fields[0] {
+		case "user":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("auth: acl line %d: invalid user", line)
+			}
+			user, hasUser = fields[1], true
+		case "topic", "pattern":
+			rule := aclRule{access: ReadWrite}
+			switch len(fields) {
+			case 2:
+				rule.filter = fields[1]
+			case 3:
+				var ok bool
+				if rule.access, ok = parseAccess(fields[1]); !ok {
+					return nil, fmt.Errorf("auth: acl line %d: invalid access %q", line, fields[1])
+				}
+				rule.filter = fields[2]
+			default:
+				return nil, fmt.Errorf("auth: acl line %d: invalid %s", line, fields[0])
+			}
+			switch {
+			case fields[0] == "pattern":
+				acl.patterns = append(acl.patterns, rule)
+			case hasUser:
+				acl.users[user] = append(acl.users[user], rule)
+			default:
+				acl.anonymous = append(acl.anonymous, rule)
+			}
+		default:
+			return nil, fmt.Errorf("auth: acl line %d: unknown keyword %q", line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// LoadACLFile loads the ACL file with the given name.
+func LoadACLFile(name string) (*ACL, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseACL(file)
+}
+
+// AuthorizePublish implements Authorizer.
+func (a *ACL) AuthorizePublish(_ context.Context, client *ClientInfo, topicName []byte) error {
+	if a.authorize(client, Write, func(filter string) bool {
+		return mqtt.TopicFilter(filter).Match(topicName)
+	}) {
+		return nil
+	}
+	return ErrNotAuthorized
+}
+
+// AuthorizeSubscribe implements Authorizer. For shared subscriptions, the
+// topic filter without the $share/{ShareName}/ prefix is authorized.
+func (a *ACL) AuthorizeSubscribe(_ context.Context, client *ClientInfo, topicFilter mqtt.TopicFilter) error {
+	if topicFilter.IsShared() {
+		_, filter, err := topicFilter.SplitShared()
+		if err != nil {
+			return err
+		}
+		topicFilter = filter
+	}
+	if a.authorize(client, Read, func(filter string) bool {
+		return filterCovers(filter, string(topicFilter))
+	}) {
+		return nil
+	}
+	return ErrNotAuthorized
+}
+
+func (a *ACL) authorize(client *ClientInfo, access Access, match func(filter string) bool) bool {
+	rules := a.anonymous
+	if client.Username != "" {
+		rules = a.users[client.Username]
+	}
+	for _, rule := range rules {
+		if rule.access&access != 0 && match(rule.filter) {
+			return true
+		}
+	}
+	for _, rule := range a.patterns {
+		if rule.access&access == 0 {
+			continue
+		}
+		filter, ok := substitutePattern(rule.filter, client)
+		if ok && match(filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// substitutePattern replaces %c and %u in the pattern. It returns false if the
+// pattern needs a value that is empty or that contains topic separators or
+// wildcards.
+func substitutePattern(pattern string, client *ClientInfo) (string, bool) {
+	if !strings.Contains(pattern, "%") {
+		return pattern, true
+	}
+	for _, substitution := range []struct {
+		placeholder string
+		value       string
+	}{
+		{"%c", client.ClientIdentifier},
+		{"%u", client.Username},
+	} {
+		if !strings.Contains(pattern, substitution.placeholder) {
+			continue
+		}
+		if substitution.value == "" || strings.ContainsAny(substitution.value, "/+#") {
+			return "", false
+		}
+		pattern = strings.Replace(pattern, substitution.placeholder, substitution.value, -1)
+	}
+	return pattern, true
+}
+
+// filterCovers returns whether every topic name that matches the topic filter
+// also matches the rule. As with matching, rules that start with a wildcard do
+// not cover filters that start with a '$' character.
+func filterCovers(rule, filter string) bool {
+	if strings.HasPrefix(filter, "$") && (strings.HasPrefix(rule, "+") || strings.HasPrefix(rule, "#")) {
+		return false
+	}
+	ruleLevels, filterLevels := strings.Split(rule, "/"), strings.Split(filter, "/")
+	for i, ruleLevel := range ruleLevels {
+		if ruleLevel == "#" {
+			return true
+		}
+		if i >= len(filterLevels) {
+			return false
+		}
+		switch filterLevel := filterLevels[i]; {
+		case filterLevel == "#":
+			return false
+		case ruleLevel == "+":
+		case ruleLevel != filterLevel:
+			return false
+		}
+	}
+	return len(ruleLevels) == len(filterLevels)
+}
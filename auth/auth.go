@@ -0,0 +1,325 @@
+// Package auth implements authentication and authorization for MQTT servers,
+// including MQTT 5 enhanced authentication.
+//
+// Simple authentication and authorization is done with an Authenticator and an
+// Authorizer, such as the PasswordFile and ACL in this package.
+//
+// Enhanced authentication is started by a CONNECT packet that contains an
+// Authentication Method property. The Server and Client may then exchange any
+// number of AUTH packets with the Continue Authentication reason code, until
+// the Server accepts the Client by sending a CONNACK packet. Once connected,
+// the Client can re-authenticate by sending an AUTH packet with the
+// Re-authenticate reason code, after which the same exchange is performed and
+// finished by an AUTH packet with the Success reason code.
+//
+// The Server and Client types in this package drive this exchange for a single
+// connection, delegating the authentication data to a ServerMechanism or
+// ClientMechanism, such as the SCRAM-SHA-256 mechanism in this package.
+package auth // import "htdvisser.dev/mqtt/auth"
+
+import (
+	"errors"
+
+	"htdvisser.dev/mqtt"
+)
+
+// ServerMechanism is the server side of an authentication method.
+type ServerMechanism interface {
+	// Method returns the name of the authentication method.
+	Method() string
+	// NewExchange starts a new authentication exchange.
+	NewExchange() ServerExchange
+}
+
+// ServerExchange is the server side of a single authentication exchange.
+type ServerExchange interface {
+	// Next processes the authentication data received from the client. It
+	// returns the authentication data to send to the client, and whether the
+	// client is authenticated. If the client can not be authenticated, an
+	// error is returned.
+	Next(data []byte) (challenge []byte, done bool, err error)
+	// Identity returns the identity of the authenticated client.
+	Identity() string
+}
+
+// ClientMechanism is the client side of an authentication method.
+type ClientMechanism interface {
+	// Method returns the name of the authentication method.
+	Method() string
+	// NewExchange starts a new authentication exchange.
+	NewExchange() ClientExchange
+}
+
+// ClientExchange is the client side of a single authentication exchange.
+type ClientExchange interface {
+	// Start returns the initial authentication data.
+	Start() ([]byte, error)
+	// Next processes the authentication data received from the server. If
+	// final is true, the data was received with the result of the exchange, and
+	// no more data can be sent. Next returns the authentication data to send to
+	// the server.
+	Next(data []byte, final bool) (response []byte, err error)
+}
+
+var (
+	errNotInProgress  = mqtt.NewReasonCodeError(mqtt.ProtocolError, "auth: authentication is not in progress")
+	errInProgress     = mqtt.NewReasonCodeError(mqtt.ProtocolError, "auth: authentication is already in progress")
+	errMethodMismatch = mqtt.NewReasonCodeError(mqtt.ProtocolError, "auth: authentication method does not match")
+	errInvalidReason  = mqtt.NewReasonCodeError(mqtt.ProtocolError, "auth: invalid reason code in auth packet")
+	errUnknownMethod  = mqtt.NewReasonCodeError(mqtt.BadAuthenticationMethod, "auth: unknown authentication method")
+	errNoMethod       = errors.New("auth: no authentication method")
+)
+
+func getProperty(properties mqtt.Properties, identifier mqtt.PropertyIdentifier) ([]byte, bool) {
+	for _, property := range properties {
+		if property.Identifier == identifier {
+			return property.BytesValue, true
+		}
+	}
+	return nil, false
+}
+
+func authProperties(properties mqtt.Properties, method string, data []byte) mqtt.Properties {
+	properties = append(properties, mqtt.Property{Identifier: mqtt.AuthenticationMethod, BytesValue: []byte(method)})
+	if data != nil {
+		properties = append(properties, mqtt.Property{Identifier: mqtt.AuthenticationData, BytesValue: data})
+	}
+	return properties
+}
+
+// Server handles enhanced authentication on the server side of a single
+// connection.
+type Server struct {
+	mechanisms    map[string]ServerMechanism
+	method        string
+	exchange      ServerExchange
+	connected     bool
+	authenticated bool
+	identity      string
+}
+
+// NewServer returns a new Server that supports the given mechanisms.
+func NewServer(mechanisms ...ServerMechanism) *Server {
+	s := &Server{mechanisms: make(map[string]ServerMechanism, len(mechanisms))}
+	for _, mechanism := range mechanisms {
+		s.mechanisms[mechanism.Method()] = mechanism
+	}
+	return s
+}
+
+// Method returns the authentication method that is used by the connection.
+func (s *Server) Method() string { return s.method }
+
+// Authenticated returns whether the client is authenticated.
+func (s *Server) Authenticated() bool { return s.authenticated }
+
+// Identity returns the identity of the authenticated client.
+func (s *Server) Identity() string { return s.identity }
+
+// Connect starts the authentication of the given CONNECT packet, and returns
+// the packet that must be sent to the client. This is either an AUTH packet
+// that continues the authentication, or a CONNACK packet with the result. If
+// the CONNACK packet has an error reason code, the error is also returned, and
+// the server should close the connection after sending the CONNACK packet.
+func (s *Server) Connect(connect *mqtt.ConnectPacket) (mqtt.Packet, error) {
+	method, ok := getProperty(connect.Properties, mqtt.AuthenticationMethod)
+	if !ok {
+		connack := connect.Connack()
+		connack.ReasonCode = mqtt.BadAuthenticationMethod
+		return connack, errNoMethod
+	}
+	data, _ := getProperty(connect.Properties, mqtt.AuthenticationData)
+	return s.start(string(method), data)
+}
+
+// Auth handles an AUTH packet received from the client, and returns the packet
+// that must be sent to the client. During the initial authentication, this is
+// either an AUTH packet or a CONNACK packet. During re-authentication, this is
+// an AUTH packet or, if re-authentication fails, a DISCONNECT packet. If an
+// error is returned, the server should close the connection after sending the
+// packet.
+func (s *Server) Auth(auth *mqtt.AuthPacket) (mqtt.Packet, error) {
+	method, _ := getProperty(auth.Properties, mqtt.AuthenticationMethod)
+	data, _ := getProperty(auth.Properties, mqtt.AuthenticationData)
+	switch auth.ReasonCode {
+	case mqtt.ReAuthenticate:
+		if !s.connected {
+			return s.fail(errNotInProgress)
+		}
+		if s.exchange != nil {
+			return s.fail(errInProgress)
+		}
+		if string(method) != s.method {
+			return s.fail(errMethodMismatch)
+		}
+		return s.start(s.method, data)
+	case mqtt.ContinueAuthentication:
+		if s.exchange == nil {
+			return s.fail(errNotInProgress)
+		}
+		if string(method) != s.method {
+			return s.fail(errMethodMismatch)
+		}
+		return s.next(data)
+	default:
+		return s.fail(errInvalidReason)
+	}
+}
+
+func (s *Server) start(method string, data []byte) (mqtt.Packet, error) {
+	mechanism, ok := s.mechanisms[method]
+	if !ok {
+		return s.fail(errUnknownMethod)
+	}
+	s.method = method
+	s.exchange = mechanism.NewExchange()
+	return s.next(data)
+}
+
+func (s *Server) next(data []byte) (mqtt.Packet, error) {
+	challenge, done, err := s.exchange.Next(data)
+	if err != nil {
+		return s.fail(err)
+	}
+	if !done {
+		return &mqtt.AuthPacket{
+			AuthHeader: mqtt.AuthHeader{ReasonCode: mqtt.ContinueAuthentication},
+			Properties: authProperties(nil, s.method, challenge),
+		}, nil
+	}
+	s.authenticated, s.identity = true, s.exchange.Identity()
+	s.exchange = nil
+	if s.connected {
+		return &mqtt.AuthPacket{
+			AuthHeader: mqtt.AuthHeader{ReasonCode: mqtt.Success},
+			Properties: authProperties(nil, s.method, challenge),
+		}, nil
+	}
+	s.connected = true
+	return &mqtt.ConnackPacket{
+		Properties: authProperties(nil, s.method, challenge),
+	}, nil
+}
+
+func (s *Server) fail(err error) (mqtt.Packet, error) {
+	s.exchange = nil
+	s.authenticated = false
+	if s.connected {
+		return &mqtt.DisconnectPacket{
+			DisconnectHeader: mqtt.DisconnectHeader{ReasonCode: ErrorReasonCode(err)},
+		}, err
+	}
+	return &mqtt.ConnackPacket{
+		ConnackHeader: mqtt.ConnackHeader{ReasonCode: ErrorReasonCode(err)},
+	}, err
+}
+
+// Client handles enhanced authentication on the client side of a single
+// connection.
+type Client struct {
+	mechanism ClientMechanism
+	exchange  ClientExchange
+	connected bool
+}
+
+// NewClient returns a new Client that uses the given mechanism.
+func NewClient(mechanism ClientMechanism) *Client {
+	return &Client{mechanism: mechanism}
+}
+
+// Connect starts the authentication by adding the authentication properties to
+// the given CONNECT packet.
+func (c *Client) Connect(connect *mqtt.ConnectPacket) error {
+	data, err := c.start()
+	if err != nil {
+		return err
+	}
+	connect.Properties = authProperties(connect.Properties, c.mechanism.Method(), data)
+	return nil
+}
+
+// Reauthenticate starts re-authentication, and returns the AUTH packet that
+// must be sent to the server.
+func (c *Client) Reauthenticate() (*mqtt.AuthPacket, error) {
+	if !c.connected {
+		return nil, errNotInProgress
+	}
+	if c.exchange != nil {
+		return nil, errInProgress
+	}
+	data, err := c.start()
+	if err != nil {
+		return nil, err
+	}
+	return &mqtt.AuthPacket{
+		AuthHeader: mqtt.AuthHeader{ReasonCode: mqtt.ReAuthenticate},
+		Properties: authProperties(nil, c.mechanism.Method(), data),
+	}, nil
+}
+
+// Auth handles an AUTH packet received from the server. If the authentication
+// continues, it returns the AUTH packet that must be sent to the server. If
+// re-authentication succeeded, it returns nil.
+func (c *Client) Auth(auth *mqtt.AuthPacket) (*mqtt.AuthPacket, error) {
+	if c.exchange == nil {
+		return nil, errNotInProgress
+	}
+	if method, _ := getProperty(auth.Properties, mqtt.AuthenticationMethod); string(method) != c.mechanism.Method() {
+		return nil, errMethodMismatch
+	}
+	data, _ := getProperty(auth.Properties, mqtt.AuthenticationData)
+	switch auth.ReasonCode {
+	case mqtt.ContinueAuthentication:
+		response, err := c.exchange.Next(data, false)
+		if err != nil {
+			c.exchange = nil
+			return nil, err
+		}
+		return &mqtt.AuthPacket{
+			AuthHeader: mqtt.AuthHeader{ReasonCode: mqtt.ContinueAuthentication},
+			Properties: authProperties(nil, c.mechanism.Method(), response),
+		}, nil
+	case mqtt.Success:
+		if !c.connected {
+			return nil, errInvalidReason
+		}
+		return nil, c.finish(data)
+	default:
+		c.exchange = nil
+		return nil, errInvalidReason
+	}
+}
+
+// Connack handles the CONNACK packet received from the server, and returns an
+// error if the server did not accept the authentication, or if the client
+// could not verify the server.
+func (c *Client) Connack(connack *mqtt.ConnackPacket) error {
+	if c.exchange == nil {
+		return errNotInProgress
+	}
+	if connack.ReasonCode.IsError() {
+		c.exchange = nil
+		return mqtt.NewReasonCodeError(connack.ReasonCode, "auth: server rejected authentication")
+	}
+	data, _ := getProperty(connack.Properties, mqtt.AuthenticationData)
+	if err := c.finish(data); err != nil {
+		return err
+	}
+	c.connected = true
+	return nil
+}
+
+func (c *Client) start() ([]byte, error) {
+	c.exchange = c.mechanism.NewExchange()
+	data, err := c.exchange.Start()
+	if err != nil {
+		c.exchange = nil
+	}
+	return data, err
+}
+
+func (c *Client) finish(data []byte) error {
+	_, err := c.exchange.Next(data, true)
+	c.exchange = nil
+	return err
+}
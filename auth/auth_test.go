@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestPBKDF2(t *testing.T) {
+	assert := assert.New(t)
+
+	// Test vector from RFC 7914, section 11.
+	dk := pbkdf2(sha256.New, []byte("passwd"), []byte("salt"), 1, 64)
+	assert.Equal("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783", hex.EncodeToString(dk))
+}
+
+func TestSCRAMExample(t *testing.T) {
+	assert := assert.New(t)
+
+	// Example from RFC 7677, section 3.
+	client := &scramClientExchange{
+		client:          &SCRAMClient{Username: "user", Password: "pencil"},
+		nonce:           "rOprNGfwEbeRWgbNEkqO",
+		clientFirstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO",
+	}
+	clientFinal, err := client.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"), false)
+	assert.NoError(err)
+	assert.Equal("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", string(clientFinal))
+
+	_, err = client.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="), true)
+	assert.NoError(err)
+}
+
+type testConn struct {
+	*mqtt.PacketReader
+	*mqtt.PacketWriter
+}
+
+func newTestConns() (client, server testConn, close func()) {
+	clientConn, serverConn := net.Pipe()
+	client = testConn{mqtt.NewReader(clientConn), mqtt.NewWriter(clientConn)}
+	server = testConn{mqtt.NewReader(serverConn), mqtt.NewWriter(serverConn)}
+	for _, c := range []testConn{client, server} {
+		c.PacketReader.SetProtocol(5)
+		c.PacketWriter.SetProtocol(5)
+	}
+	return client, server, func() {
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
+func testSCRAMServer(t *testing.T) *SCRAMServer {
+	credentials, err := NewSCRAMCredentials("pencil", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SCRAMServer{
+		Lookup: func(username string) (SCRAMCredentials, error) {
+			if username != "user" {
+				return SCRAMCredentials{}, errors.New("unknown user")
+			}
+			return credentials, nil
+		},
+	}
+}
+
+// serve runs the server side of the connection until the client disconnects.
+func serve(conn testConn, server *Server) error {
+	packet, err := conn.ReadPacket()
+	if err != nil {
+		return err
+	}
+	reply, err := server.Connect(packet.(*mqtt.ConnectPacket))
+	for {
+		if werr := conn.WritePacket(reply); werr != nil {
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		if packet, err = conn.ReadPacket(); err != nil {
+			return err
+		}
+		switch packet := packet.(type) {
+		case *mqtt.AuthPacket:
+			reply, err = server.Auth(packet)
+		case *mqtt.DisconnectPacket:
+			return nil
+		default:
+			return errors.New("unexpected packet")
+		}
+	}
+}
+
+// connect runs the client side of the initial authentication.
+func connect(conn testConn, client *Client) error {
+	connect := &mqtt.ConnectPacket{
+		ConnectHeader: mqtt.ConnectHeader{ProtocolName: []byte("MQTT"), ProtocolVersion: 5},
+	}
+	if err := client.Connect(connect); err != nil {
+		return err
+	}
+	if err := conn.WritePacket(connect); err != nil {
+		return err
+	}
+	for {
+		packet, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		switch packet := packet.(type) {
+		case *mqtt.AuthPacket:
+			reply, err := client.Auth(packet)
+			if err != nil {
+				return err
+			}
+			if err = conn.WritePacket(reply); err != nil {
+				return err
+			}
+		case *mqtt.ConnackPacket:
+			return client.Connack(packet)
+		default:
+			return errors.New("unexpected packet")
+		}
+	}
+}
+
+// reauthenticate runs the client side of re-authentication.
+func reauthenticate(conn testConn, client *Client) error {
+	reply, err := client.Reauthenticate()
+	for reply != nil {
+		if err = conn.WritePacket(reply); err != nil {
+			return err
+		}
+		packet, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		auth, ok := packet.(*mqtt.AuthPacket)
+		if !ok {
+			return errors.New("unexpected packet")
+		}
+		if reply, err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func TestSCRAM(t *testing.T) {
+	assert := assert.New(t)
+
+	clientConn, serverConn, close := newTestConns()
+	defer close()
+
+	server := NewServer(testSCRAMServer(t))
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- serve(serverConn, server)
+		close()
+	}()
+
+	client := NewClient(&SCRAMClient{Username: "user", Password: "pencil"})
+	assert.NoError(connect(clientConn, client))
+	assert.True(server.Authenticated())
+	assert.Equal("user", server.Identity())
+	assert.Equal(SCRAMSHA256, server.Method())
+
+	assert.NoError(reauthenticate(clientConn, client))
+	assert.True(server.Authenticated())
+
+	assert.NoError(clientConn.WritePacket(&mqtt.DisconnectPacket{}))
+	assert.NoError(<-serverErr)
+}
+
+func TestSCRAMFailure(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		client     ClientMechanism
+		reasonCode mqtt.ReasonCode
+	}{
+		{name: "wrong password", client: &SCRAMClient{Username: "user", Password: "wrong"}, reasonCode: mqtt.NotAuthorized},
+		{name: "unknown user", client: &SCRAMClient{Username: "other", Password: "pencil"}, reasonCode: mqtt.NotAuthorized},
+		{name: "unknown method", client: &unknownMechanism{}, reasonCode: mqtt.BadAuthenticationMethod},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			clientConn, serverConn, close := newTestConns()
+			defer close()
+
+			server := NewServer(testSCRAMServer(t))
+			serverErr := make(chan error, 1)
+			go func() {
+				serverErr <- serve(serverConn, server)
+				close()
+			}()
+
+			err := connect(clientConn, NewClient(tt.client))
+			var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+			if assert.True(errors.As(err, &rcErr)) {
+				assert.Equal(tt.reasonCode, rcErr.ReasonCode())
+			}
+			assert.Error(<-serverErr)
+			assert.False(server.Authenticated())
+		})
+	}
+}
+
+func TestSCRAMUnknownUser(t *testing.T) {
+	assert := assert.New(t)
+
+	server := testSCRAMServer(t)
+	server.Secret = []byte("secret")
+
+	// The server answers with the same parameters for an unknown user in
+	// every exchange, and fails only after the client sent its proof.
+	var serverFirsts []string
+	for i := 0; i < 2; i++ {
+		client := (&SCRAMClient{Username: "other", Password: "pencil"}).NewExchange()
+		exchange := server.NewExchange()
+		clientFirst, err := client.Start()
+		assert.NoError(err)
+		serverFirst, done, err := exchange.Next(clientFirst)
+		assert.NoError(err)
+		assert.False(done)
+		attributes, err := parseSCRAMAttributes(string(serverFirst))
+		if assert.NoError(err) {
+			assert.Equal(strconv.Itoa(DefaultSCRAMIterations), attributes['i'])
+			serverFirsts = append(serverFirsts, attributes['s'])
+		}
+		clientFinal, err := client.Next(serverFirst, false)
+		assert.NoError(err)
+		_, _, err = exchange.Next(clientFinal)
+		assert.Equal(errSCRAMProof, err)
+	}
+	if assert.Len(serverFirsts, 2) {
+		assert.Equal(serverFirsts[0], serverFirsts[1])
+	}
+}
+
+func TestSCRAMClientIterations(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		client     *SCRAMClient
+		iterations int
+		err        error
+	}{
+		{name: "default", client: &SCRAMClient{}, iterations: DefaultSCRAMIterations},
+		{name: "too low", client: &SCRAMClient{}, iterations: 1024, err: errSCRAMIterations},
+		{name: "too high", client: &SCRAMClient{}, iterations: 2147483647, err: errSCRAMIterationsHigh},
+		{name: "custom max", client: &SCRAMClient{MaxIterations: 8192}, iterations: 8193, err: errSCRAMIterationsHigh},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			exchange := tt.client.NewExchange()
+			_, err := exchange.Start()
+			assert.NoError(err)
+			nonce := exchange.(*scramClientExchange).nonce
+			serverFirst := "r=" + nonce + "server,s=c2FsdA==,i=" + strconv.Itoa(tt.iterations)
+			_, err = exchange.Next([]byte(serverFirst), false)
+			assert.Equal(tt.err, err)
+		})
+	}
+}
+
+type unknownMechanism struct{}
+
+func (unknownMechanism) Method() string { return "UNKNOWN" }
+
+func (unknownMechanism) NewExchange() ClientExchange { return unknownMechanism{} }
+
+func (unknownMechanism) Start() ([]byte, error) { return nil, nil }
+
+func (unknownMechanism) Next(data []byte, final bool) ([]byte, error) { return nil, nil }
+
+func TestServerProtocolErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	server := NewServer(testSCRAMServer(t))
+
+	// Re-authentication before connecting.
+	reply, err := server.Auth(&mqtt.AuthPacket{
+		AuthHeader: mqtt.AuthHeader{ReasonCode: mqtt.ReAuthenticate},
+		Properties: authProperties(nil, SCRAMSHA256, nil),
+	})
+	assert.Equal(errNotInProgress, err)
+	assert.Equal(mqtt.ProtocolError, reply.(*mqtt.ConnackPacket).ReasonCode)
+
+	// CONNECT without authentication method.
+	reply, err = server.Connect(&mqtt.ConnectPacket{})
+	assert.Equal(errNoMethod, err)
+	assert.Equal(mqtt.BadAuthenticationMethod, reply.(*mqtt.ConnackPacket).ReasonCode)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Errors returned by Authenticators and Authorizers. Servers can use
+// ErrorReasonCode to get the reason code to send to the client.
+var (
+	ErrBadUsernameOrPassword = mqtt.NewReasonCodeError(mqtt.BadUsernameOrPassword, "auth: bad username or password")
+	ErrNotAuthorized         = mqtt.NewReasonCodeError(mqtt.NotAuthorized, "auth: not authorized")
+)
+
+// ErrorReasonCode returns the reason code for the given error. If the error
+// does not have a reason code, NotAuthorized is returned.
+func ErrorReasonCode(err error) mqtt.ReasonCode {
+	var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+	if errors.As(err, &rcErr) {
+		return rcErr.ReasonCode()
+	}
+	return mqtt.NotAuthorized
+}
+
+// ClientInfo contains information about a connected client.
+type ClientInfo struct {
+	ClientIdentifier string
+	Username         string
+	Password         []byte
+	RemoteAddr       net.Addr
+	PeerCertificate  *x509.Certificate
+}
+
+// NewClientInfo returns the ClientInfo for the CONNECT packet that was received
+// on the given connection. If the connection is a TLS connection with a client
+// certificate, PeerCertificate is set. This requires that the TLS handshake is
+// complete, which is the case after reading the CONNECT packet.
+func NewClientInfo(conn net.Conn, connect *mqtt.ConnectPacket) *ClientInfo {
+	info := &ClientInfo{
+		ClientIdentifier: string(connect.ClientIdentifier),
+		Username:         string(connect.Username()),
+		Password:         connect.Password(),
+		RemoteAddr:       conn.RemoteAddr(),
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			info.PeerCertificate = certs[0]
+		}
+	}
+	return info
+}
+
+// Authenticator authenticates clients that connect with a CONNECT packet.
+type Authenticator interface {
+	// Authenticate returns an error if the client can not be authenticated.
+	// This is typically ErrBadUsernameOrPassword or ErrNotAuthorized.
+	Authenticate(ctx context.Context, client *ClientInfo) error
+}
+
+// Authorizer authorizes authenticated clients to publish and subscribe.
+type Authorizer interface {
+	// AuthorizePublish returns an error if the client is not allowed to
+	// publish to the topic name. This is typically ErrNotAuthorized.
+	AuthorizePublish(ctx context.Context, client *ClientInfo, topicName []byte) error
+	// AuthorizeSubscribe returns an error if the client is not allowed to
+	// subscribe to the topic filter. This is typically ErrNotAuthorized.
+	AuthorizeSubscribe(ctx context.Context, client *ClientInfo, topicFilter mqtt.TopicFilter) error
+}
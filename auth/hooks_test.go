@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestPasswordFile(t *testing.T) {
+	assert := assert.New(t)
+
+	hash, err := HashPassword("secret")
+	assert.NoError(err)
+	ok, err := CheckPassword(hash, []byte("secret"))
+	assert.NoError(err)
+	assert.True(ok)
+
+	file, err := ParsePasswordFile(strings.NewReader(`
+# Users
+alice:` + hash + `
+bob:` + hashPassword("hunter2", []byte("salt"), 1) + `
+`))
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		username string
+		password string
+		err      error
+	}{
+		{"alice", "secret", nil},
+		{"bob", "hunter2", nil},
+		{"alice", "hunter2", ErrBadUsernameOrPassword},
+		{"carol", "secret", ErrBadUsernameOrPassword},
+		{"", "", ErrNotAuthorized},
+	} {
+		assert.Equal(tt.err, file.Authenticate(ctx, &ClientInfo{Username: tt.username, Password: []byte(tt.password)}), tt.username)
+	}
+
+	assert.Equal(ErrNotAuthorized, file.Authenticate(ctx, &ClientInfo{}))
+	file.AllowAnonymous = true
+	assert.NoError(file.Authenticate(ctx, &ClientInfo{}))
+
+	_, err = ParsePasswordFile(strings.NewReader("alice:secret"))
+	assert.Error(err)
+
+	assert.Equal(mqtt.BadUsernameOrPassword, ErrorReasonCode(ErrBadUsernameOrPassword))
+	assert.Equal(mqtt.NotAuthorized, ErrorReasonCode(ErrNotAuthorized))
+}
+
+func TestACL(t *testing.T) {
+	acl, err := ParseACL(strings.NewReader(`
+# Anonymous clients
+topic read public/#
+
+user alice
+topic readwrite alice/#
+topic write sensors/+/temperature
+
+user bob
+topic bob/inbox
+
+user carol
+topic read #
+
+pattern read devices/%c/#
+pattern write users/%u/status
+`))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	anonymous := &ClientInfo{ClientIdentifier: "anonymous"}
+	alice := &ClientInfo{ClientIdentifier: "device-1", Username: "alice"}
+	bob := &ClientInfo{ClientIdentifier: "device/2", Username: "bob"}
+	carol := &ClientInfo{ClientIdentifier: "device-3", Username: "carol"}
+
+	for _, tt := range []struct {
+		client    *ClientInfo
+		topic     string
+		publish   bool
+		subscribe bool
+	}{
+		{anonymous, "public/news", false, true},
+		{anonymous, "public/#", false, true},
+		{anonymous, "#", false, false},
+		{alice, "public/news", false, false},
+		{alice, "alice/foo", true, true},
+		{alice, "alice/#", false, true},
+		{alice, "alice", true, true},
+		{alice, "sensors/kitchen/temperature", true, false},
+		{alice, "sensors/kitchen/humidity", false, false},
+		{alice, "devices/device-1/config", false, true},
+		{alice, "devices/device-1/#", false, true},
+		{alice, "devices/+/config", false, false},
+		{alice, "users/alice/status", true, false},
+		{alice, "users/bob/status", false, false},
+		{bob, "bob/inbox", true, true},
+		{bob, "devices/device/2/config", false, false},
+		{anonymous, "users//status", false, false},
+		{alice, "$share/group/alice/#", false, true},
+		{alice, "$share/group/bob/#", false, false},
+		{carol, "#", false, true},
+		{carol, "foo/+", false, true},
+		{carol, "$SYS/#", false, false},
+		{carol, "$SYS/broker/uptime", false, false},
+	} {
+		t.Run(tt.client.Username+" "+tt.topic, func(t *testing.T) {
+			assert := assert.New(t)
+			ctx := context.Background()
+			if !strings.ContainsAny(tt.topic, "+#") {
+				assert.Equal(tt.publish, acl.AuthorizePublish(ctx, tt.client, []byte(tt.topic)) == nil, "publish")
+			}
+			assert.Equal(tt.subscribe, acl.AuthorizeSubscribe(ctx, tt.client, mqtt.TopicFilter(tt.topic)) == nil, "subscribe")
+		})
+	}
+
+	for _, invalid := range []string{
+		"topic",
+		"topic execute foo",
+		"user",
+		"deny foo",
+	} {
+		_, err := ParseACL(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const passwordHashPrefix = "$pbkdf2-sha256$"
+
+var errInvalidPasswordHash = errors.New("auth: invalid password hash")
+
+// HashPassword hashes the password with PBKDF2-SHA256, using a random salt
+// and DefaultSCRAMIterations iterations. The result has the format
+// $pbkdf2-sha256$<iterations>$<salt>$<hash>, where salt and hash are encoded
+// with unpadded base64.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hashPassword(password, salt, DefaultSCRAMIterations), nil
+}
+
+func hashPassword(password string, salt []byte, iterations int) string {
+	hash := pbkdf2(sha256.New, []byte(password), salt, iterations, sha256.Size)
+	return passwordHashPrefix + strconv.Itoa(iterations) +
+		"$" + base64.RawStdEncoding.EncodeToString(salt) +
+		"$" + base64.RawStdEncoding.EncodeToString(hash)
+}
+
+// CheckPassword returns whether the password matches the hash that was
+// returned by HashPassword.
+func CheckPassword(hash string, password []byte) (bool, error) {
+	iterations, salt, expected, err := parsePasswordHash(hash)
+	if err != nil {
+		return false, err
+	}
+	actual := pbkdf2(sha256.New, password, salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
+
+func parsePasswordHash(hash string) (iterations int, salt, expected []byte, err error) {
+	if !strings.HasPrefix(hash, passwordHashPrefix) {
+		return 0, nil, nil, errInvalidPasswordHash
+	}
+	parts := strings.Split(strings.TrimPrefix(hash, passwordHashPrefix), "$")
+	if len(parts) != 3 {
+		return 0, nil, nil, errInvalidPasswordHash
+	}
+	if iterations, err = strconv.Atoi(parts[0]); err != nil || iterations < 1 {
+		return 0, nil, nil, errInvalidPasswordHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil {
+		return 0, nil, nil, errInvalidPasswordHash
+	}
+	if expected, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil || len(expected) == 0 {
+		return 0, nil, nil, errInvalidPasswordHash
+	}
+	return iterations, salt, expected, nil
+}
+
+// PasswordFile is an Authenticator that authenticates clients by the username
+// and password in their CONNECT packet.
+//
+// Each line of a password file contains a username and a password hash
+// (see HashPassword), separated by a colon. Empty lines and lines that start
+// with # are ignored.
+type PasswordFile struct {
+	// AllowAnonymous allows clients that connect without username.
+	AllowAnonymous bool
+
+	hashes map[string]string
+}
+
+// ParsePasswordFile parses a password file.
+func ParsePasswordFile(r io.Reader) (*PasswordFile, error) {
+	f := &PasswordFile{hashes: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(text, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("auth: password file line %d: missing username", line)
+		}
+		username, hash := text[:i], text[i+1:]
+		if _, _, _, err := parsePasswordHash(hash); err != nil {
+			return nil, fmt.Errorf("auth: password file line %d: %w", line, err)
+		}
+		f.hashes[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadPasswordFile loads the password file with the given name.
+func LoadPasswordFile(name string) (*PasswordFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParsePasswordFile(file)
+}
+
+var (
+	dummyPasswordHashOnce sync.Once
+	dummyPasswordHash     string
+)
+
+// unknownUserHash returns a hash that is checked for unknown users, so that
+// authenticating them takes as long as authenticating known users.
+func unknownUserHash() string {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash = hashPassword("", []byte("unknown-user"), DefaultSCRAMIterations)
+	})
+	return dummyPasswordHash
+}
+
+// Authenticate implements Authenticator.
+func (f *PasswordFile) Authenticate(_ context.Context, client *ClientInfo) error {
+	if client.Username == "" {
+		if f.AllowAnonymous && client.Password == nil {
+			return nil
+		}
+		return ErrNotAuthorized
+	}
+	hash, known := f.hashes[client.Username]
+	if !known {
+		hash = unknownUserHash()
+	}
+	ok, err := CheckPassword(hash, client.Password)
+	if err != nil {
+		return err
+	}
+	if !known || !ok {
+		return ErrBadUsernameOrPassword
+	}
+	return nil
+}
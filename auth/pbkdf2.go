@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+// pbkdf2 implements PBKDF2 (RFC 8018), used by SCRAM as the Hi function.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
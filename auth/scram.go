@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// SCRAMSHA256 is the name of the SCRAM-SHA-256 authentication method.
+const SCRAMSHA256 = "SCRAM-SHA-256"
+
+// DefaultSCRAMIterations is the default iteration count for SCRAM credentials.
+const DefaultSCRAMIterations = 4096
+
+// DefaultSCRAMMaxIterations is the default maximum iteration count that a
+// SCRAMClient accepts from the server.
+const DefaultSCRAMMaxIterations = 1000000
+
+const scramNonceLen = 18
+
+var (
+	errSCRAMMalformed      = mqtt.NewReasonCodeError(mqtt.NotAuthorized, "auth: malformed scram message")
+	errSCRAMNonce          = mqtt.NewReasonCodeError(mqtt.NotAuthorized, "auth: invalid scram nonce")
+	errSCRAMChannel        = mqtt.NewReasonCodeError(mqtt.NotAuthorized, "auth: scram channel binding is not supported")
+	errSCRAMProof          = mqtt.NewReasonCodeError(mqtt.NotAuthorized, "auth: invalid scram client proof")
+	errSCRAMSignature      = errors.New("auth: invalid scram server signature")
+	errSCRAMIterations     = errors.New("auth: scram iteration count too low")
+	errSCRAMIterationsHigh = errors.New("auth: scram iteration count too high")
+	errSCRAMServerError    = errors.New("auth: scram server error")
+	errSCRAMUnexpected     = errors.New("auth: unexpected scram message")
+)
+
+const (
+	scramGS2Header      = "n,,"  // No channel binding, no authorization identity.
+	scramChannelBinding = "biws" // base64(scramGS2Header)
+)
+
+// SCRAMCredentials are the credentials that a server stores for a SCRAM user.
+// They do not contain the password itself.
+type SCRAMCredentials struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewSCRAMCredentials derives SCRAMCredentials from the given password. If salt
+// is nil, a random salt is generated. If iterations is 0,
+// DefaultSCRAMIterations is used.
+func NewSCRAMCredentials(password string, salt []byte, iterations int) (SCRAMCredentials, error) {
+	if salt == nil {
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return SCRAMCredentials{}, err
+		}
+	}
+	if iterations == 0 {
+		iterations = DefaultSCRAMIterations
+	}
+	saltedPassword := pbkdf2(sha256.New, []byte(password), salt, iterations, sha256.Size)
+	clientKey := scramHMAC(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	return SCRAMCredentials{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey[:],
+		ServerKey:  scramHMAC(saltedPassword, "Server Key"),
+	}, nil
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func scramNonce() (string, error) {
+	nonce := make([]byte, scramNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(nonce), nil
+}
+
+var (
+	scramNameEscaper   = strings.NewReplacer("=", "=3D", ",", "=2C")
+	scramNameUnescaper = strings.NewReplacer("=3D", "=", "=2C", ",")
+)
+
+// parseSCRAMAttributes parses a SCRAM message into its attributes.
+func parseSCRAMAttributes(message string) (map[byte]string, error) {
+	attributes := make(map[byte]string)
+	for _, attribute := range strings.Split(message, ",") {
+		if len(attribute) < 2 || attribute[1] != '=' {
+			return nil, errSCRAMMalformed
+		}
+		attributes[attribute[0]] = attribute[2:]
+	}
+	return attributes, nil
+}
+
+// SCRAMServer is the server side of the SCRAM-SHA-256 authentication method
+// (RFC 7677). Channel binding is not supported.
+//
+// If Lookup fails, the server still answers with credentials that are derived
+// from the username and Secret, and fails the exchange only after the client
+// sent its proof, so that clients can not tell whether a user exists (RFC 5802,
+// section 5.1).
+type SCRAMServer struct {
+	// Lookup returns the credentials of the given user.
+	Lookup func(username string) (SCRAMCredentials, error)
+	// Secret is used to derive the salts of unknown users. If nil, a random
+	// secret is used, which means that the salt of an unknown user changes when
+	// the server restarts.
+	Secret []byte
+
+	secretOnce sync.Once
+	secret     []byte
+}
+
+// Method implements ServerMechanism.
+func (*SCRAMServer) Method() string { return SCRAMSHA256 }
+
+// NewExchange implements ServerMechanism.
+func (s *SCRAMServer) NewExchange() ServerExchange { return &scramServerExchange{server: s} }
+
+// fakeCredentials returns credentials for an unknown user. They are the same
+// for every exchange with the same username, but no password matches them.
+func (s *SCRAMServer) fakeCredentials(username string) SCRAMCredentials {
+	s.secretOnce.Do(func() {
+		if s.secret = s.Secret; s.secret == nil {
+			s.secret = make([]byte, sha256.Size)
+			rand.Read(s.secret)
+		}
+	})
+	return SCRAMCredentials{
+		Salt:       scramHMAC(s.secret, "salt:"+username)[:16],
+		Iterations: DefaultSCRAMIterations,
+		StoredKey:  scramHMAC(s.secret, "stored key:"+username),
+		ServerKey:  scramHMAC(s.secret, "server key:"+username),
+	}
+}
+
+type scramServerExchange struct {
+	server *SCRAMServer
+
+	step            int
+	username        string
+	unknown         bool
+	credentials     SCRAMCredentials
+	nonce           string
+	clientFirstBare string
+	serverFirst     string
+}
+
+func (e *scramServerExchange) Next(data []byte) ([]byte, bool, error) {
+	e.step++
+	switch e.step {
+	case 1:
+		return e.handleClientFirst(string(data))
+	case 2:
+		return e.handleClientFinal(string(data))
+	default:
+		return nil, false, errSCRAMMalformed
+	}
+}
+
+func (e *scramServerExchange) Identity() string { return e.username }
+
+func (e *scramServerExchange) handleClientFirst(clientFirst string) ([]byte, bool, error) {
+	if !strings.HasPrefix(clientFirst, scramGS2Header) {
+		if strings.HasPrefix(clientFirst, "p=") {
+			return nil, false, errSCRAMChannel
+		}
+		return nil, false, errSCRAMMalformed
+	}
+	e.clientFirstBare = strings.TrimPrefix(clientFirst, scramGS2Header)
+	attributes, err := parseSCRAMAttributes(e.clientFirstBare)
+	if err != nil {
+		return nil, false, err
+	}
+	username, clientNonce := attributes['n'], attributes['r']
+	if username == "" || clientNonce == "" {
+		return nil, false, errSCRAMMalformed
+	}
+	e.username = scramNameUnescaper.Replace(username)
+	if e.server.Lookup != nil {
+		e.credentials, err = e.server.Lookup(e.username)
+	}
+	if e.server.Lookup == nil || err != nil {
+		e.unknown, e.credentials = true, e.server.fakeCredentials(e.username)
+	}
+	serverNonce, err := scramNonce()
+	if err != nil {
+		return nil, false, err
+	}
+	e.nonce = clientNonce + serverNonce
+	e.serverFirst = "r=" + e.nonce +
+		",s=" + base64.StdEncoding.EncodeToString(e.credentials.Salt) +
+		",i=" + strconv.Itoa(e.credentials.Iterations)
+	return []byte(e.serverFirst), false, nil
+}
+
+func (e *scramServerExchange) handleClientFinal(clientFinal string) ([]byte, bool, error) {
+	proofIndex := strings.LastIndex(clientFinal, ",p=")
+	if proofIndex < 0 {
+		return nil, false, errSCRAMMalformed
+	}
+	clientFinalWithoutProof := clientFinal[:proofIndex]
+	attributes, err := parseSCRAMAttributes(clientFinal)
+	if err != nil {
+		return nil, false, err
+	}
+	if attributes['c'] != scramChannelBinding {
+		return nil, false, errSCRAMChannel
+	}
+	if attributes['r'] != e.nonce {
+		return nil, false, errSCRAMNonce
+	}
+	proof, err := base64.StdEncoding.DecodeString(attributes['p'])
+	if err != nil || len(proof) != sha256.Size {
+		return nil, false, errSCRAMMalformed
+	}
+
+	authMessage := e.clientFirstBare + "," + e.serverFirst + "," + clientFinalWithoutProof
+	clientSignature := scramHMAC(e.credentials.StoredKey, authMessage)
+	clientKey := make([]byte, sha256.Size)
+	for i := range clientKey {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(storedKey[:], e.credentials.StoredKey) != 1 || e.unknown {
+		return nil, false, errSCRAMProof
+	}
+
+	serverSignature := scramHMAC(e.credentials.ServerKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature)), true, nil
+}
+
+// SCRAMClient is the client side of the SCRAM-SHA-256 authentication method
+// (RFC 7677). Channel binding is not supported.
+type SCRAMClient struct {
+	Username string
+	Password string
+
+	// MinIterations is the minimum iteration count that the client accepts
+	// from the server. If zero, DefaultSCRAMIterations is used.
+	MinIterations int
+	// MaxIterations is the maximum iteration count that the client accepts
+	// from the server. If zero, DefaultSCRAMMaxIterations is used.
+	MaxIterations int
+}
+
+// Method implements ClientMechanism.
+func (*SCRAMClient) Method() string { return SCRAMSHA256 }
+
+// NewExchange implements ClientMechanism.
+func (c *SCRAMClient) NewExchange() ClientExchange { return &scramClientExchange{client: c} }
+
+type scramClientExchange struct {
+	client *SCRAMClient
+
+	step            int
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func (e *scramClientExchange) Start() ([]byte, error) {
+	nonce, err := scramNonce()
+	if err != nil {
+		return nil, err
+	}
+	e.nonce = nonce
+	e.clientFirstBare = "n=" + scramNameEscaper.Replace(e.client.Username) + ",r=" + e.nonce
+	return []byte(scramGS2Header + e.clientFirstBare), nil
+}
+
+func (e *scramClientExchange) Next(data []byte, final bool) ([]byte, error) {
+	e.step++
+	switch {
+	case e.step == 1 && !final:
+		return e.handleServerFirst(string(data))
+	case e.step == 2 && final:
+		return nil, e.handleServerFinal(string(data))
+	default:
+		return nil, errSCRAMUnexpected
+	}
+}
+
+func (e *scramClientExchange) handleServerFirst(serverFirst string) ([]byte, error) {
+	attributes, err := parseSCRAMAttributes(serverFirst)
+	if err != nil {
+		return nil, err
+	}
+	nonce := attributes['r']
+	if !strings.HasPrefix(nonce, e.nonce) || len(nonce) == len(e.nonce) {
+		return nil, errSCRAMNonce
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes['s'])
+	if err != nil || len(salt) == 0 {
+		return nil, errSCRAMMalformed
+	}
+	iterations, err := strconv.Atoi(attributes['i'])
+	if err != nil {
+		return nil, errSCRAMMalformed
+	}
+	minIterations := e.client.MinIterations
+	if minIterations == 0 {
+		minIterations = DefaultSCRAMIterations
+	}
+	if iterations < minIterations {
+		return nil, errSCRAMIterations
+	}
+	maxIterations := e.client.MaxIterations
+	if maxIterations == 0 {
+		maxIterations = DefaultSCRAMMaxIterations
+	}
+	if iterations > maxIterations {
+		return nil, errSCRAMIterationsHigh
+	}
+
+	saltedPassword := pbkdf2(sha256.New, []byte(e.client.Password), salt, iterations, sha256.Size)
+	clientKey := scramHMAC(saltedPassword, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+
+	clientFinalWithoutProof := "c=" + scramChannelBinding + ",r=" + nonce
+	authMessage := e.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof
+	clientSignature := scramHMAC(storedKey[:], authMessage)
+	proof := make([]byte, sha256.Size)
+	for i := range proof {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	e.serverSignature = scramHMAC(scramHMAC(saltedPassword, "Server Key"), authMessage)
+
+	return []byte(clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+func (e *scramClientExchange) handleServerFinal(serverFinal string) error {
+	attributes, err := parseSCRAMAttributes(serverFinal)
+	if err != nil {
+		return err
+	}
+	if _, ok := attributes['e']; ok {
+		return errSCRAMServerError
+	}
+	signature, err := base64.StdEncoding.DecodeString(attributes['v'])
+	if err != nil || !bytes.Equal(signature, e.serverSignature) {
+		return errSCRAMSignature
+	}
+	return nil
+}
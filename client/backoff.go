@@ -0,0 +1,45 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delay between reconnection attempts. The delay grows
+// exponentially with the number of attempts, and is randomized with jitter, so
+// that many clients that lose their connection at the same time do not all
+// reconnect at the same time.
+type Backoff struct {
+	// Min is the delay before the first attempt.
+	Min time.Duration
+	// Max is the maximum delay.
+	Max time.Duration
+	// Factor is the factor by which the delay grows with each attempt.
+	Factor float64
+	// Jitter is the fraction of the delay that is randomized. A Jitter of 0.5
+	// gives delays between 50% and 100% of the computed delay.
+	Jitter float64
+}
+
+// DefaultBackoff is the Backoff that is used if no Backoff is configured.
+var DefaultBackoff = Backoff{
+	Min:    100 * time.Millisecond,
+	Max:    time.Minute,
+	Factor: 2,
+	Jitter: 0.5,
+}
+
+// Delay returns the delay before the given attempt, starting at 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Min)
+	for i := 0; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Factor
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay -= delay * b.Jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
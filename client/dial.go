@@ -0,0 +1,164 @@
+// Package client implements connection management for MQTT clients.
+//
+// The Dialer in this package connects to a server, and follows the server
+// redirections (UseAnotherServer and ServerMoved) of MQTT 5. The Reconnector
+// reconnects with backoff, and resumes the Session of the client. The Queue
+// holds outbound messages while the client is disconnected.
+package client // import "htdvisser.dev/mqtt/client"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"htdvisser.dev/mqtt"
+)
+
+// DefaultPort is the default port for MQTT servers.
+const DefaultPort = "1883"
+
+// DefaultMaxRedirects is the default maximum number of redirections that a
+// Dialer follows.
+const DefaultMaxRedirects = 5
+
+var (
+	errTooManyRedirects = errors.New("client: too many redirects")
+	errRedirectLoop     = errors.New("client: redirect loop")
+	errNotConnack       = mqtt.NewReasonCodeError(mqtt.ProtocolError, "client: first packet was not CONNACK")
+)
+
+// Conn is a connection to an MQTT server.
+type Conn struct {
+	net.Conn
+	Reader *mqtt.PacketReader
+	Writer *mqtt.PacketWriter
+
+	// Address is the address of the server.
+	Address string
+	// Connack is the CONNACK packet that the server sent.
+	Connack *mqtt.ConnackPacket
+}
+
+// Dialer connects to MQTT servers.
+type Dialer struct {
+	// DialContext dials the network connection. If nil, net.Dialer is used.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// ReaderOptions are the options for the packet reader of the connection.
+	// The reader has the client role (see mqtt.WithRole), unless overridden.
+	ReaderOptions []mqtt.ReaderOption
+	// DefaultPort is used for server references without port. If empty,
+	// DefaultPort is used.
+	DefaultPort string
+	// MaxRedirects is the maximum number of redirections to follow. If zero,
+	// DefaultMaxRedirects is used. If negative, redirections are not followed,
+	// and a redirection is returned as an error with the reason code of the
+	// CONNACK packet.
+	MaxRedirects int
+}
+
+// Connect connects to the server at the address, sends the CONNECT packet and
+// reads the CONNACK packet. If the server redirects the client, the Dialer
+// connects to the server that it was redirected to. If the server does not
+// accept the connection, an error with the reason code of the CONNACK packet
+// is returned.
+func (d *Dialer) Connect(ctx context.Context, address string, connect *mqtt.ConnectPacket) (*Conn, error) {
+	return d.connect(ctx, address, connect, make(map[string]bool))
+}
+
+// Redirect follows the redirection in the DISCONNECT packet that was received
+// on the connection, by connecting to the server that the client was redirected
+// to. It returns false if the DISCONNECT packet is not a redirection.
+func (d *Dialer) Redirect(ctx context.Context, conn *Conn, disconnect *mqtt.DisconnectPacket, connect *mqtt.ConnectPacket) (*Conn, bool, error) {
+	references, _, ok := mqtt.Redirection(disconnect)
+	if !ok {
+		return nil, false, nil
+	}
+	visited := map[string]bool{conn.Address: true}
+	address, err := d.nextAddress(references, visited)
+	if err != nil {
+		return nil, true, err
+	}
+	next, err := d.connect(ctx, address, connect, visited)
+	return next, true, err
+}
+
+func (d *Dialer) connect(ctx context.Context, address string, connect *mqtt.ConnectPacket, visited map[string]bool) (*Conn, error) {
+	maxRedirects := d.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+	for redirects := 0; ; redirects++ {
+		visited[address] = true
+		conn, err := d.dial(ctx, address, connect)
+		if err != nil {
+			return nil, err
+		}
+		references, _, ok := mqtt.Redirection(conn.Connack)
+		if !ok || maxRedirects < 0 {
+			if conn.Connack.ReasonCode.IsError() {
+				conn.Close()
+				return nil, mqtt.NewReasonCodeError(conn.Connack.ReasonCode, fmt.Sprintf("client: connect failed: %s", conn.Connack.ReasonCode))
+			}
+			return conn, nil
+		}
+		conn.Close()
+		if redirects >= maxRedirects {
+			return nil, errTooManyRedirects
+		}
+		if address, err = d.nextAddress(references, visited); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *Dialer) nextAddress(references []mqtt.ServerAddress, visited map[string]bool) (string, error) {
+	defaultPort := d.DefaultPort
+	if defaultPort == "" {
+		defaultPort = DefaultPort
+	}
+	for _, reference := range references {
+		if address := reference.Address(defaultPort); !visited[address] {
+			return address, nil
+		}
+	}
+	return "", errRedirectLoop
+}
+
+func (d *Dialer) dial(ctx context.Context, address string, connect *mqtt.ConnectPacket) (*Conn, error) {
+	dialContext := d.DialContext
+	if dialContext == nil {
+		var dialer net.Dialer
+		dialContext = dialer.DialContext
+	}
+	nc, err := dialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn := &Conn{
+		Conn:    nc,
+		Reader:  mqtt.NewReader(nc, append([]mqtt.ReaderOption{mqtt.WithRole(mqtt.RoleClient)}, d.ReaderOptions...)...),
+		Writer:  mqtt.NewWriter(nc),
+		Address: address,
+	}
+	if protocol := connect.ProtocolVersion; protocol != 0 {
+		conn.Reader.SetProtocol(protocol)
+		conn.Writer.SetProtocol(protocol)
+	}
+	if err = conn.Writer.WritePacketContext(ctx, connect); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	packet, err := conn.Reader.ReadPacketContext(ctx)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	connack, ok := packet.(*mqtt.ConnackPacket)
+	if !ok {
+		nc.Close()
+		return nil, errNotConnack
+	}
+	conn.Connack = connack
+	return conn, nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+type testServer struct {
+	net.Listener
+	handle   func(connect *mqtt.ConnectPacket) mqtt.Packet
+	received chan mqtt.Packet // receives the packets after CONNECT.
+}
+
+// newTestServer starts a server that replies to CONNECT packets with the
+// packet returned by handle.
+func newTestServer(t *testing.T, handle func(connect *mqtt.ConnectPacket) mqtt.Packet) *testServer {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{Listener: lis, handle: handle, received: make(chan mqtt.Packet, 16)}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			reader, writer := mqtt.NewReader(conn), mqtt.NewWriter(conn)
+			packet, err := reader.ReadPacket()
+			if err != nil {
+				return
+			}
+			connect := packet.(*mqtt.ConnectPacket)
+			writer.SetProtocol(connect.ProtocolVersion)
+			if err = writer.WritePacket(s.handle(connect)); err != nil {
+				return
+			}
+			for {
+				packet, err := reader.ReadPacket()
+				if err != nil {
+					return
+				}
+				select {
+				case s.received <- packet:
+				default:
+				}
+			}
+		}()
+	}
+}
+
+func (s *testServer) reference() mqtt.ServerAddress {
+	host, port, _ := net.SplitHostPort(s.Addr().String())
+	return mqtt.ServerAddress{Host: host, Port: port}
+}
+
+func accept(connect *mqtt.ConnectPacket) mqtt.Packet { return connect.Connack() }
+
+func redirectTo(servers ...*testServer) func(connect *mqtt.ConnectPacket) mqtt.Packet {
+	return func(connect *mqtt.ConnectPacket) mqtt.Packet {
+		references := make([]mqtt.ServerAddress, len(servers))
+		for i, server := range servers {
+			references[i] = server.reference()
+		}
+		return connect.Redirect(false, references...)
+	}
+}
+
+func testConnect() *mqtt.ConnectPacket {
+	return &mqtt.ConnectPacket{ConnectHeader: mqtt.ConnectHeader{ProtocolVersion: 5}}
+}
+
+func assertReasonCode(t *testing.T, err error, code mqtt.ReasonCode) {
+	t.Helper()
+	var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+	if assert.True(t, errors.As(err, &rcErr), "error %v has no reason code", err) {
+		assert.Equal(t, code, rcErr.ReasonCode())
+	}
+}
+
+func TestDialerConnect(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	target := newTestServer(t, accept)
+	defer target.Close()
+	moved := newTestServer(t, redirectTo(target))
+	defer moved.Close()
+
+	var d Dialer
+	conn, err := d.Connect(ctx, moved.Addr().String(), testConnect())
+	if assert.NoError(err) {
+		assert.Equal(target.Addr().String(), conn.Address)
+		assert.Equal(mqtt.Success, conn.Connack.ReasonCode)
+		conn.Close()
+	}
+
+	d.MaxRedirects = -1
+	_, err = d.Connect(ctx, moved.Addr().String(), testConnect())
+	assertReasonCode(t, err, mqtt.UseAnotherServer)
+
+	rejecting := newTestServer(t, func(connect *mqtt.ConnectPacket) mqtt.Packet {
+		connack := connect.Connack()
+		connack.ReasonCode = mqtt.NotAuthorized
+		return connack
+	})
+	defer rejecting.Close()
+	_, err = d.Connect(ctx, rejecting.Addr().String(), testConnect())
+	assertReasonCode(t, err, mqtt.NotAuthorized)
+}
+
+func TestDialerRedirectLoop(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var a, b *testServer
+	a = newTestServer(t, func(connect *mqtt.ConnectPacket) mqtt.Packet { return redirectTo(b)(connect) })
+	defer a.Close()
+	b = newTestServer(t, func(connect *mqtt.ConnectPacket) mqtt.Packet { return redirectTo(a)(connect) })
+	defer b.Close()
+
+	var d Dialer
+	_, err := d.Connect(ctx, a.Addr().String(), testConnect())
+	assert.Equal(errRedirectLoop, err)
+
+	// The first server that was not visited yet is used.
+	target := newTestServer(t, accept)
+	defer target.Close()
+	var c *testServer
+	c = newTestServer(t, func(connect *mqtt.ConnectPacket) mqtt.Packet { return redirectTo(c, target)(connect) })
+	defer c.Close()
+	d.MaxRedirects = 10
+	_, err = d.Connect(ctx, a.Addr().String(), testConnect())
+	assert.Equal(errRedirectLoop, err)
+	conn, err := d.Connect(ctx, c.Addr().String(), testConnect())
+	if assert.NoError(err) {
+		assert.Equal(target.Addr().String(), conn.Address)
+		conn.Close()
+	}
+}
+
+func TestDialerRedirect(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first := newTestServer(t, accept)
+	defer first.Close()
+	second := newTestServer(t, accept)
+	defer second.Close()
+
+	var d Dialer
+	conn, err := d.Connect(ctx, first.Addr().String(), testConnect())
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	next, ok, err := d.Redirect(ctx, conn, &mqtt.DisconnectPacket{}, testConnect())
+	assert.False(ok)
+	assert.NoError(err)
+	assert.Nil(next)
+
+	next, ok, err = d.Redirect(ctx, conn, mqtt.RedirectDisconnect(true, first.reference()), testConnect())
+	assert.True(ok)
+	assert.Equal(errRedirectLoop, err)
+
+	next, ok, err = d.Redirect(ctx, conn, mqtt.RedirectDisconnect(true, first.reference(), second.reference()), testConnect())
+	assert.True(ok)
+	if assert.NoError(err) {
+		assert.Equal(second.Addr().String(), next.Address)
+		next.Close()
+	}
+}
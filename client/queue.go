@@ -0,0 +1,340 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+	"htdvisser.dev/mqtt/store"
+)
+
+// DropPolicy determines which message is dropped when a Queue is full.
+type DropPolicy int
+
+const (
+	// DropOldest drops the oldest message in the queue.
+	DropOldest DropPolicy = iota
+	// DropNewest drops the new message.
+	DropNewest
+	// DropLowestQoS drops the oldest message with the lowest QoS. If the new
+	// message has a lower QoS than all messages in the queue, the new message
+	// is dropped.
+	DropLowestQoS
+)
+
+var errQueueFull = mqtt.NewReasonCodeError(mqtt.QuotaExceeded, "client: queue full")
+
+// QueueOption is an option for a Queue.
+type QueueOption interface {
+	apply(*Queue)
+}
+
+type queueOptionFunc func(*Queue)
+
+func (f queueOptionFunc) apply(q *Queue) {
+	f(q)
+}
+
+// WithDropPolicy returns a QueueOption that configures the DropPolicy of the
+// Queue. The default is DropOldest.
+func WithDropPolicy(policy DropPolicy) QueueOption {
+	return queueOptionFunc(func(q *Queue) {
+		q.policy = policy
+	})
+}
+
+// WithQueueClock returns a QueueOption that configures the Clock of the Queue.
+// The default is store.SystemClock.
+func WithQueueClock(clock store.Clock) QueueOption {
+	return queueOptionFunc(func(q *Queue) {
+		q.clock = clock
+	})
+}
+
+// WithQueueDir returns a QueueOption that persists the messages in the Queue as
+// encoded packets in files in the given directory. Messages that are in the
+// directory when the Queue is created are loaded into the Queue.
+func WithQueueDir(dir string) QueueOption {
+	return queueOptionFunc(func(q *Queue) {
+		q.dir = dir
+	})
+}
+
+type queuedMessage struct {
+	*store.Message
+	sequence uint64
+}
+
+// Queue is a bounded FIFO queue of outbound PUBLISH packets, for messages that
+// the application publishes while the client is disconnected. Expired messages
+// are dropped from the queue. A Queue is safe for concurrent use.
+type Queue struct {
+	limit  int
+	policy DropPolicy
+	clock  store.Clock
+	dir    string
+
+	mu       sync.Mutex
+	sequence uint64
+	messages []queuedMessage
+}
+
+// NewQueue returns a new Queue that holds at most limit messages. If limit is
+// zero, the size of the queue is not limited.
+func NewQueue(limit int, opts ...QueueOption) (*Queue, error) {
+	q := &Queue{limit: limit, clock: store.SystemClock}
+	for _, opt := range opts {
+		opt.apply(q)
+	}
+	if q.dir != "" {
+		if err := q.load(); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Push adds a copy of the PUBLISH packet to the queue. If the queue is full,
+// expired messages are dropped, and if the queue is still full, a message is
+// dropped according to the DropPolicy. If the new message is dropped, an error
+// is returned.
+func (q *Queue) Push(publish *mqtt.PublishPacket) error {
+	message := store.Stamp(q.clock, publish.Clone())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit > 0 && len(q.messages) >= q.limit {
+		q.dropExpired(message.ReceivedAt)
+	}
+	if q.limit > 0 && len(q.messages) >= q.limit {
+		i := q.victim(publish.QoS())
+		if i < 0 {
+			return errQueueFull
+		}
+		if err := q.remove(i); err != nil {
+			return err
+		}
+	}
+	q.sequence++
+	queued := queuedMessage{Message: message, sequence: q.sequence}
+	if q.dir != "" {
+		if err := q.persist(queued); err != nil {
+			return err
+		}
+	}
+	q.messages = append(q.messages, queued)
+	return nil
+}
+
+// victim returns the index of the message to drop for a new message with the
+// given QoS, or -1 if the new message should be dropped.
+func (q *Queue) victim(qos mqtt.QoS) int {
+	switch q.policy {
+	case DropOldest:
+		return 0
+	case DropLowestQoS:
+		victim := -1
+		for i, message := range q.messages {
+			if message.QoS() <= qos && (victim < 0 || message.QoS() < q.messages[victim].QoS()) {
+				victim = i
+			}
+		}
+		return victim
+	}
+	return -1
+}
+
+// Peek returns the first message that did not expire, prepared for forwarding
+// (see store.Message.Forward), without removing it from the queue. It returns
+// false if the queue is empty.
+func (q *Queue) Peek() (*mqtt.PublishPacket, bool) {
+	publish, _, ok := q.peek()
+	return publish, ok
+}
+
+func (q *Queue) peek() (*mqtt.PublishPacket, uint64, bool) {
+	now := q.clock.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.messages) > 0 {
+		if publish, ok := q.messages[0].Forward(now); ok {
+			return publish, q.messages[0].sequence, true
+		}
+		if err := q.remove(0); err != nil {
+			return nil, 0, false
+		}
+	}
+	return nil, 0, false
+}
+
+// Pop removes the first message that did not expire from the queue, and
+// returns it prepared for forwarding (see store.Message.Forward). It returns
+// false if the queue is empty.
+func (q *Queue) Pop() (*mqtt.PublishPacket, bool) {
+	now := q.clock.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.messages) > 0 {
+		publish, ok := q.messages[0].Forward(now)
+		if err := q.remove(0); err != nil {
+			return nil, false
+		}
+		if ok {
+			return publish, true
+		}
+	}
+	return nil, false
+}
+
+// Drain calls publish for the messages in the queue, in order, until the
+// queue is empty or publish returns an error. A message is only removed from
+// the queue if publish succeeds. The publish func is responsible for assigning
+// packet identifiers to messages with QoS 1 and QoS 2.
+func (q *Queue) Drain(publish func(*mqtt.PublishPacket) error) error {
+	for {
+		packet, sequence, ok := q.peek()
+		if !ok {
+			return nil
+		}
+		if err := publish(packet); err != nil {
+			return err
+		}
+		if err := q.removeSequence(sequence); err != nil {
+			return err
+		}
+	}
+}
+
+// Len returns the number of messages in the queue that did not expire.
+func (q *Queue) Len() int {
+	now := q.clock.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dropExpired(now)
+	return len(q.messages)
+}
+
+func (q *Queue) dropExpired(now time.Time) {
+	for i := 0; i < len(q.messages); {
+		if q.messages[i].Expired(now) {
+			if err := q.remove(i); err != nil {
+				return
+			}
+			continue
+		}
+		i++
+	}
+}
+
+func (q *Queue) remove(i int) error {
+	if q.dir != "" {
+		if err := os.Remove(q.filename(q.messages[i].sequence)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	copy(q.messages[i:], q.messages[i+1:])
+	q.messages[len(q.messages)-1] = queuedMessage{}
+	q.messages = q.messages[:len(q.messages)-1]
+	return nil
+}
+
+func (q *Queue) removeSequence(sequence uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, message := range q.messages {
+		if message.sequence == sequence {
+			return q.remove(i)
+		}
+	}
+	return nil
+}
+
+const queueFileExt = ".mqtt"
+
+func (q *Queue) filename(sequence uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", sequence, queueFileExt))
+}
+
+var errInvalidQueueFile = errors.New("client: invalid queue file")
+
+// persist writes the message to a file that starts with the time at which the
+// message was received in Unix nanoseconds, followed by the encoded packet.
+func (q *Queue) persist(message queuedMessage) error {
+	var buf bytes.Buffer
+	var receivedAt [8]byte
+	binary.BigEndian.PutUint64(receivedAt[:], uint64(message.ReceivedAt.UnixNano()))
+	buf.Write(receivedAt[:])
+	w := mqtt.NewWriter(&buf)
+	w.SetProtocol(5)
+	if err := w.WritePacket(message.PublishPacket); err != nil {
+		return err
+	}
+	filename := q.filename(message.sequence)
+	if err := ioutil.WriteFile(filename+".tmp", buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func (q *Queue) load() error {
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	var sequences []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != queueFileExt {
+			continue
+		}
+		sequence, err := strconv.ParseUint(name[:len(name)-len(queueFileExt)], 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	for _, sequence := range sequences {
+		message, err := q.read(sequence)
+		if err != nil {
+			return err
+		}
+		q.messages = append(q.messages, queuedMessage{Message: message, sequence: sequence})
+		q.sequence = sequence
+	}
+	return nil
+}
+
+func (q *Queue) read(sequence uint64) (*store.Message, error) {
+	b, err := ioutil.ReadFile(q.filename(sequence))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 8 {
+		return nil, errInvalidQueueFile
+	}
+	receivedAt := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	// Queued messages do not have packet identifiers yet.
+	r := mqtt.NewReader(bytes.NewReader(b[8:]), mqtt.WithoutValidation())
+	r.SetProtocol(5)
+	packet, err := r.ReadPacket()
+	if err != nil {
+		return nil, err
+	}
+	publish, ok := packet.(*mqtt.PublishPacket)
+	if !ok {
+		return nil, errInvalidQueueFile
+	}
+	return &store.Message{PublishPacket: publish, ReceivedAt: receivedAt}, nil
+}
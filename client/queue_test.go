@@ -0,0 +1,188 @@
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+	"htdvisser.dev/mqtt/store"
+)
+
+func queuedPayload(publish *mqtt.PublishPacket) string { return string(publish.PublishPayload) }
+
+func testQueuedPublish(payload string, qos mqtt.QoS, expiry uint64) *mqtt.PublishPacket {
+	publish := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte("topic")},
+		PublishPayload: []byte(payload),
+	}
+	publish.SetQoS(qos)
+	if expiry > 0 {
+		publish.Properties = mqtt.Properties{{Identifier: mqtt.MessageExpiryInterval, UintValue: expiry}}
+	}
+	return publish
+}
+
+func TestQueueDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy   DropPolicy
+		push     []*mqtt.PublishPacket
+		full     []bool
+		expected []string
+	}{
+		{
+			policy:   DropOldest,
+			push:     []*mqtt.PublishPacket{testQueuedPublish("a", 1, 0), testQueuedPublish("b", 0, 0), testQueuedPublish("c", 2, 0)},
+			full:     []bool{false, false, false},
+			expected: []string{"b", "c"},
+		},
+		{
+			policy:   DropNewest,
+			push:     []*mqtt.PublishPacket{testQueuedPublish("a", 1, 0), testQueuedPublish("b", 0, 0), testQueuedPublish("c", 2, 0)},
+			full:     []bool{false, false, true},
+			expected: []string{"a", "b"},
+		},
+		{
+			policy:   DropLowestQoS,
+			push:     []*mqtt.PublishPacket{testQueuedPublish("a", 1, 0), testQueuedPublish("b", 0, 0), testQueuedPublish("c", 2, 0)},
+			full:     []bool{false, false, false},
+			expected: []string{"a", "c"},
+		},
+		{
+			policy:   DropLowestQoS,
+			push:     []*mqtt.PublishPacket{testQueuedPublish("a", 2, 0), testQueuedPublish("b", 1, 0), testQueuedPublish("c", 0, 0)},
+			full:     []bool{false, false, true},
+			expected: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		assert := assert.New(t)
+		q, err := NewQueue(2, WithDropPolicy(tt.policy))
+		if !assert.NoError(err) {
+			continue
+		}
+		for i, publish := range tt.push {
+			err := q.Push(publish)
+			if tt.full[i] {
+				assert.Equal(errQueueFull, err)
+			} else {
+				assert.NoError(err)
+			}
+		}
+		var payloads []string
+		for {
+			publish, ok := q.Pop()
+			if !ok {
+				break
+			}
+			payloads = append(payloads, queuedPayload(publish))
+		}
+		assert.Equal(tt.expected, payloads)
+	}
+}
+
+func TestQueueExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := store.NewManualClock(time.Unix(0, 0))
+	q, err := NewQueue(2, WithQueueClock(clock), WithDropPolicy(DropNewest))
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+
+	assert.NoError(q.Push(testQueuedPublish("a", 1, 10)))
+	assert.NoError(q.Push(testQueuedPublish("b", 1, 60)))
+	clock.Advance(15 * time.Second)
+
+	// The expired message makes room for the new message.
+	assert.NoError(q.Push(testQueuedPublish("c", 1, 0)))
+	assert.Equal(2, q.Len())
+
+	publish, ok := q.Pop()
+	if assert.True(ok) {
+		assert.Equal("b", queuedPayload(publish))
+		assert.Equal(uint64(45), publish.Properties[0].UintValue)
+	}
+}
+
+func TestQueueDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := NewQueue(0)
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		assert.NoError(q.Push(testQueuedPublish(payload, 1, 0)))
+	}
+
+	errFailed := errors.New("failed")
+	var payloads []string
+	err = q.Drain(func(publish *mqtt.PublishPacket) error {
+		if len(payloads) == 2 {
+			return errFailed
+		}
+		payloads = append(payloads, queuedPayload(publish))
+		return nil
+	})
+	assert.Equal(errFailed, err)
+	assert.Equal([]string{"a", "b"}, payloads)
+	assert.Equal(1, q.Len())
+
+	assert.NoError(q.Drain(func(publish *mqtt.PublishPacket) error {
+		payloads = append(payloads, queuedPayload(publish))
+		return nil
+	}))
+	assert.Equal([]string{"a", "b", "c"}, payloads)
+	assert.Equal(0, q.Len())
+}
+
+func TestQueueDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "mqtt-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := store.NewManualClock(time.Unix(1000, 0))
+	q, err := NewQueue(0, WithQueueDir(dir), WithQueueClock(clock))
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		assert.NoError(q.Push(testQueuedPublish(payload, 1, 30)))
+	}
+	_, ok := q.Pop()
+	assert.True(ok)
+
+	clock.Advance(10 * time.Second)
+	q, err = NewQueue(0, WithQueueDir(dir), WithQueueClock(clock))
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	assert.Equal(2, q.Len())
+	assert.NoError(q.Push(testQueuedPublish("d", 0, 0)))
+
+	var payloads []string
+	for {
+		publish, ok := q.Pop()
+		if !ok {
+			break
+		}
+		payloads = append(payloads, queuedPayload(publish))
+		if queuedPayload(publish) == "b" {
+			assert.Equal(uint64(20), publish.Properties[0].UintValue)
+		}
+	}
+	assert.Equal([]string{"b", "c", "d"}, payloads)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+	assert.Empty(files)
+}
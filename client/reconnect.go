@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Reconnector (re)connects a client to a server and resumes its session.
+//
+// To keep the session on the server over MQTT 5 connections, the CONNECT
+// packet must have a Session Expiry Interval property.
+type Reconnector struct {
+	// Dialer is used to connect to the server. If nil, a zero Dialer is used.
+	Dialer *Dialer
+	// Address is the address of the server.
+	Address string
+	// ConnectPacket is the CONNECT packet for the first connection.
+	// Reconnections use a copy with the Clean Start flag cleared.
+	ConnectPacket *mqtt.ConnectPacket
+	// Session is the session state of the client. If nil, the session is not
+	// resumed.
+	Session *Session
+	// Backoff is the backoff between connection attempts. If zero,
+	// DefaultBackoff is used.
+	Backoff Backoff
+	// MaxAttempts is the maximum number of connection attempts. If zero, the
+	// Reconnector retries until the context is done.
+	MaxAttempts int
+	// Discarded, if non-nil, is called with the unacknowledged PUBLISH packets
+	// that were discarded from the Session because the server did not have the
+	// session (see Session.Resume).
+	Discarded func(discarded []*mqtt.PublishPacket)
+
+	mu        sync.Mutex
+	connected bool
+}
+
+func (r *Reconnector) connectPacket() *mqtt.ConnectPacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.connected {
+		return r.ConnectPacket
+	}
+	connect := r.ConnectPacket.Clone()
+	connect.SetCleanSession(false)
+	return connect
+}
+
+// Connect connects to the server, retrying with backoff until it succeeds,
+// the context is done or MaxAttempts is reached. After the first successful
+// connection, the client reconnects with the Clean Start flag cleared, and
+// the packets returned by Session.Resume are sent to the server.
+func (r *Reconnector) Connect(ctx context.Context) (*Conn, error) {
+	dialer := r.Dialer
+	if dialer == nil {
+		dialer = &Dialer{}
+	}
+	backoff := r.Backoff
+	if backoff == (Backoff{}) {
+		backoff = DefaultBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := r.connect(ctx, dialer)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil || (r.MaxAttempts > 0 && attempt+1 >= r.MaxAttempts) {
+			return nil, err
+		}
+		var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+		if errors.As(err, &rcErr) && !retryable(rcErr.ReasonCode()) {
+			return nil, err
+		}
+		timer := time.NewTimer(backoff.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (r *Reconnector) connect(ctx context.Context, dialer *Dialer) (*Conn, error) {
+	conn, err := dialer.Connect(ctx, r.Address, r.connectPacket())
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.connected = true
+	r.mu.Unlock()
+	if r.Session == nil {
+		return conn, nil
+	}
+	packets, discarded := r.Session.Resume(conn.Connack)
+	if len(discarded) > 0 && r.Discarded != nil {
+		r.Discarded(discarded)
+	}
+	for _, packet := range packets {
+		if err = conn.Writer.WritePacketContext(ctx, packet); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// retryable returns whether a connection that was refused with the reason code
+// may succeed when retried.
+func retryable(reasonCode mqtt.ReasonCode) bool {
+	switch reasonCode {
+	case mqtt.ServerUnavailable, mqtt.ServerBusy, mqtt.QuotaExceeded, mqtt.ConnectionRateExceeded, mqtt.UnspecifiedError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	b := Backoff{Min: time.Second, Max: 10 * time.Second, Factor: 2}
+	assert.Equal(time.Second, b.Delay(0))
+	assert.Equal(2*time.Second, b.Delay(1))
+	assert.Equal(8*time.Second, b.Delay(3))
+	assert.Equal(10*time.Second, b.Delay(4))
+	assert.Equal(10*time.Second, b.Delay(1000))
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := b.Delay(2)
+		assert.True(delay >= 2*time.Second && delay <= 4*time.Second, delay)
+	}
+}
+
+func testPublish(packetIdentifier uint16, qos mqtt.QoS) *mqtt.PublishPacket {
+	publish := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte("topic"), PacketIdentifier: packetIdentifier},
+		PublishPayload: []byte("payload"),
+	}
+	publish.SetQoS(qos)
+	return publish
+}
+
+func TestSession(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSession()
+	s.Subscribe(&mqtt.SubscribePacket{SubscribePayload: []mqtt.Subscription{
+		{TopicFilter: mqtt.TopicFilter("foo/#"), QoS: mqtt.QoS1},
+		{TopicFilter: mqtt.TopicFilter("bar/+"), QoS: mqtt.QoS0},
+	}})
+	s.Subscribe(&mqtt.SubscribePacket{SubscribePayload: []mqtt.Subscription{
+		{TopicFilter: mqtt.TopicFilter("bar/+"), QoS: mqtt.QoS2},
+	}})
+	s.Unsubscribe(&mqtt.UnsubscribePacket{UnsubscribePayload: []mqtt.TopicFilter{mqtt.TopicFilter("foo/#")}})
+
+	s.Publish(testPublish(0, mqtt.QoS0))
+	s.Publish(testPublish(1, mqtt.QoS1))
+	s.Publish(testPublish(2, mqtt.QoS1))
+	s.Publish(testPublish(3, mqtt.QoS2))
+	s.Publish(testPublish(4, mqtt.QoS2))
+	s.Publish(testPublish(5, mqtt.QoS2))
+	assert.Equal(5, s.Inflight())
+
+	assert.Nil(s.Acknowledge(&mqtt.PubackPacket{PubackHeader: mqtt.PubackHeader{PacketIdentifier: 1}}))
+	pubrel := s.Acknowledge(&mqtt.PubrecPacket{PubrecHeader: mqtt.PubrecHeader{PacketIdentifier: 3}})
+	if assert.IsType(&mqtt.PubrelPacket{}, pubrel) {
+		assert.Equal(uint16(3), pubrel.(*mqtt.PubrelPacket).PacketIdentifier)
+	}
+	assert.Nil(s.Acknowledge(&mqtt.PubrecPacket{PubrecHeader: mqtt.PubrecHeader{PacketIdentifier: 4, ReasonCode: mqtt.QuotaExceeded}}))
+	assert.Equal(3, s.Inflight())
+
+	// The server has the session: retransmit.
+	connack := &mqtt.ConnackPacket{}
+	connack.SetSessionPresent(true)
+	packets, discarded := s.Resume(connack)
+	assert.Empty(discarded)
+	if assert.Len(packets, 3) {
+		assert.Equal(uint16(2), packets[0].(*mqtt.PublishPacket).PacketIdentifier)
+		assert.True(packets[0].(*mqtt.PublishPacket).Dup())
+		assert.Equal(uint16(3), packets[1].(*mqtt.PubrelPacket).PacketIdentifier)
+		assert.Equal(uint16(5), packets[2].(*mqtt.PublishPacket).PacketIdentifier)
+		assert.True(packets[2].(*mqtt.PublishPacket).Dup())
+	}
+
+	// The server lost the session: resubscribe and discard the session state.
+	packets, discarded = s.Resume(&mqtt.ConnackPacket{})
+	if assert.Len(packets, 1) {
+		subscribe := packets[0].(*mqtt.SubscribePacket)
+		assert.Equal(uint16(1), subscribe.PacketIdentifier)
+		assert.Equal([]mqtt.Subscription{{TopicFilter: mqtt.TopicFilter("bar/+"), QoS: mqtt.QoS2}}, subscribe.SubscribePayload)
+	}
+	if assert.Len(discarded, 2) {
+		assert.Equal(uint16(2), discarded[0].PacketIdentifier)
+		assert.Equal(uint16(5), discarded[1].PacketIdentifier)
+	}
+	assert.Equal(0, s.Inflight())
+	assert.Nil(s.Acknowledge(&mqtt.PubackPacket{PubackHeader: mqtt.PubackHeader{PacketIdentifier: 2}}))
+}
+
+func TestSessionResubscribe(t *testing.T) {
+	assert := assert.New(t)
+
+	s := NewSession()
+	s.Publish(testPublish(1, mqtt.QoS1))
+	s.Subscribe(&mqtt.SubscribePacket{
+		SubscribeHeader: mqtt.SubscribeHeader{PacketIdentifier: 2},
+		Properties:      mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier, UintValue: 1}},
+		SubscribePayload: []mqtt.Subscription{
+			{TopicFilter: mqtt.TopicFilter("foo/#"), QoS: mqtt.QoS1},
+			{TopicFilter: mqtt.TopicFilter("bar/#"), QoS: mqtt.QoS1},
+		},
+	})
+	s.Subscribe(&mqtt.SubscribePacket{
+		SubscribeHeader:  mqtt.SubscribeHeader{PacketIdentifier: 3},
+		Properties:       mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier, UintValue: 2}},
+		SubscribePayload: []mqtt.Subscription{{TopicFilter: mqtt.TopicFilter("baz/#"), QoS: mqtt.QoS2}},
+	})
+	assert.Equal(uint16(4), s.PacketIdentifier())
+	assert.Nil(s.Acknowledge(&mqtt.SubackPacket{SubackHeader: mqtt.SubackHeader{PacketIdentifier: 2}}))
+
+	// Each SUBSCRIBE is resent with its own Subscription Identifier, and with a
+	// new packet identifier. The in-flight PUBLISH is discarded.
+	packets, discarded := s.Resume(&mqtt.ConnackPacket{})
+	if assert.Len(discarded, 1) {
+		assert.Equal(uint16(1), discarded[0].PacketIdentifier)
+	}
+	if assert.Len(packets, 2) {
+		first, second := packets[0].(*mqtt.SubscribePacket), packets[1].(*mqtt.SubscribePacket)
+		assert.Equal(uint16(5), first.PacketIdentifier)
+		assert.Equal(mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier, UintValue: 1}}, first.Properties)
+		assert.Len(first.SubscribePayload, 2)
+		assert.Equal(uint16(6), second.PacketIdentifier)
+		assert.Equal(mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier, UintValue: 2}}, second.Properties)
+		assert.Len(second.SubscribePayload, 1)
+	}
+
+	// A subscription that is replaced moves to the SUBSCRIBE that replaced it,
+	// and SUBSCRIBE packets without subscriptions are not resent.
+	s.Subscribe(&mqtt.SubscribePacket{
+		Properties:       mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier, UintValue: 3}},
+		SubscribePayload: []mqtt.Subscription{{TopicFilter: mqtt.TopicFilter("baz/#"), QoS: mqtt.QoS0}},
+	})
+	s.Unsubscribe(&mqtt.UnsubscribePacket{UnsubscribePayload: []mqtt.TopicFilter{mqtt.TopicFilter("foo/#")}})
+	packets, _ = s.Resume(&mqtt.ConnackPacket{})
+	if assert.Len(packets, 2) {
+		first, second := packets[0].(*mqtt.SubscribePacket), packets[1].(*mqtt.SubscribePacket)
+		assert.Equal(uint16(7), first.PacketIdentifier)
+		assert.Equal([]mqtt.Subscription{{TopicFilter: mqtt.TopicFilter("bar/#"), QoS: mqtt.QoS1}}, first.SubscribePayload)
+		assert.Equal(uint16(8), second.PacketIdentifier)
+		assert.Equal(mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier, UintValue: 3}}, second.Properties)
+	}
+}
+
+func TestReconnector(t *testing.T) {
+	assert := assert.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var attempts int32
+	server := newTestServer(t, func(connect *mqtt.ConnectPacket) mqtt.Packet {
+		connack := connect.Connack()
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			assert.True(connect.CleanSession())
+		case 2:
+			connack.ReasonCode = mqtt.ServerUnavailable
+		default:
+			assert.False(connect.CleanSession())
+			connack.SetSessionPresent(true)
+		}
+		return connack
+	})
+	defer server.Close()
+
+	connect := testConnect()
+	connect.SetCleanSession(true)
+	r := &Reconnector{
+		Address:       server.Addr().String(),
+		ConnectPacket: connect,
+		Session:       NewSession(),
+		Backoff:       Backoff{Min: time.Millisecond, Max: 10 * time.Millisecond, Factor: 2},
+	}
+
+	conn, err := r.Connect(ctx)
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	publish := testPublish(1, mqtt.QoS1)
+	r.Session.Publish(publish)
+	assert.NoError(conn.Writer.WritePacket(publish))
+	select {
+	case packet := <-server.received:
+		assert.False(packet.(*mqtt.PublishPacket).Dup())
+	case <-ctx.Done():
+		t.Fatal("publish not received")
+	}
+	conn.Close()
+
+	// The second attempt is refused, the third succeeds and resumes the session.
+	conn, err = r.Connect(ctx)
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+	select {
+	case packet := <-server.received:
+		if assert.IsType(&mqtt.PublishPacket{}, packet) {
+			assert.True(packet.(*mqtt.PublishPacket).Dup())
+			assert.Equal(uint16(1), packet.(*mqtt.PublishPacket).PacketIdentifier)
+		}
+	case <-ctx.Done():
+		t.Fatal("publish not retransmitted")
+	}
+
+	// Connections that are refused for reasons that are not temporary are not
+	// retried.
+	refusing := newTestServer(t, func(connect *mqtt.ConnectPacket) mqtt.Packet {
+		connack := connect.Connack()
+		connack.ReasonCode = mqtt.NotAuthorized
+		return connack
+	})
+	defer refusing.Close()
+	r = &Reconnector{Address: refusing.Addr().String(), ConnectPacket: testConnect()}
+	_, err = r.Connect(ctx)
+	assert.Error(err)
+}
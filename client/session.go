@@ -0,0 +1,248 @@
+package client
+
+import (
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Session is the client side of the session state. It keeps track of the
+// subscriptions of the client, and of the QoS 1 and QoS 2 messages that were
+// not completely acknowledged by the server, so that they can be resumed after
+// reconnecting. A Session is safe for concurrent use.
+type Session struct {
+	mu               sync.Mutex
+	subscribes       []*mqtt.SubscribePacket // one per SUBSCRIBE, with its properties
+	inflight         []mqtt.Packet           // *mqtt.PublishPacket or *mqtt.PubrelPacket
+	reserved         []uint16                // packet identifiers of SUBSCRIBE packets without SUBACK
+	packetIdentifier uint16                  // the last allocated packet identifier
+}
+
+// NewSession returns a new, empty Session.
+func NewSession() *Session {
+	return &Session{}
+}
+
+func (s *Session) indexOf(packetIdentifier uint16) int {
+	for i, packet := range s.inflight {
+		switch packet := packet.(type) {
+		case *mqtt.PublishPacket:
+			if packet.PacketIdentifier == packetIdentifier {
+				return i
+			}
+		case *mqtt.PubrelPacket:
+			if packet.PacketIdentifier == packetIdentifier {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (s *Session) inUse(packetIdentifier uint16) bool {
+	for _, reserved := range s.reserved {
+		if reserved == packetIdentifier {
+			return true
+		}
+	}
+	return s.indexOf(packetIdentifier) >= 0
+}
+
+// PacketIdentifier returns a packet identifier that is not used by in-flight
+// messages or by SUBSCRIBE packets that were not acknowledged yet. The client
+// should use it for the PUBLISH and SUBSCRIBE packets that it records in the
+// Session. It returns 0 if all packet identifiers are in use.
+func (s *Session) PacketIdentifier() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextPacketIdentifier()
+}
+
+func (s *Session) nextPacketIdentifier() uint16 {
+	for i := 0; i < 65535; i++ {
+		s.packetIdentifier++
+		if s.packetIdentifier == 0 {
+			s.packetIdentifier = 1
+		}
+		if !s.inUse(s.packetIdentifier) {
+			return s.packetIdentifier
+		}
+	}
+	return 0
+}
+
+func (s *Session) release(packetIdentifier uint16) {
+	for i, reserved := range s.reserved {
+		if reserved == packetIdentifier {
+			s.reserved = append(s.reserved[:i], s.reserved[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Session) remove(i int) {
+	copy(s.inflight[i:], s.inflight[i+1:])
+	s.inflight[len(s.inflight)-1] = nil
+	s.inflight = s.inflight[:len(s.inflight)-1]
+}
+
+// Publish records a PUBLISH packet that is sent to the server. PUBLISH packets
+// with QoS 0 are not recorded.
+func (s *Session) Publish(publish *mqtt.PublishPacket) {
+	if publish.QoS() == mqtt.QoS0 {
+		return
+	}
+	publish = publish.Clone()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.indexOf(publish.PacketIdentifier); i >= 0 {
+		s.inflight[i] = publish
+		return
+	}
+	s.inflight = append(s.inflight, publish)
+}
+
+// Acknowledge processes a PUBACK, PUBREC, PUBCOMP or SUBACK packet that was
+// received from the server. If the packet is a PUBREC that does not have an error reason
+// code, Acknowledge returns the PUBREL packet that the client must send.
+func (s *Session) Acknowledge(packet mqtt.Packet) mqtt.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch packet := packet.(type) {
+	case *mqtt.PubackPacket:
+		if i := s.indexOf(packet.PacketIdentifier); i >= 0 {
+			s.remove(i)
+		}
+	case *mqtt.PubrecPacket:
+		i := s.indexOf(packet.PacketIdentifier)
+		if packet.ReasonCode.IsError() {
+			if i >= 0 {
+				s.remove(i)
+			}
+			return nil
+		}
+		pubrel := packet.Pubrel()
+		if i >= 0 {
+			s.inflight[i] = pubrel
+		} else {
+			s.inflight = append(s.inflight, pubrel)
+		}
+		return pubrel
+	case *mqtt.PubcompPacket:
+		if i := s.indexOf(packet.PacketIdentifier); i >= 0 {
+			s.remove(i)
+		}
+	case *mqtt.SubackPacket:
+		s.release(packet.PacketIdentifier)
+	}
+	return nil
+}
+
+// Inflight returns the number of messages that were not completely
+// acknowledged by the server.
+func (s *Session) Inflight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.inflight)
+}
+
+// Subscribe records the subscriptions of a SUBSCRIBE packet, together with
+// its properties, such as the Subscription Identifier. Subscriptions to topic
+// filters that the client already subscribed to replace the existing
+// subscriptions. The packet identifier of the SUBSCRIBE packet is in use until
+// the SUBACK packet is acknowledged.
+func (s *Session) Subscribe(subscribe *mqtt.SubscribePacket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscribe = subscribe.Clone()
+	for _, subscription := range subscribe.SubscribePayload {
+		s.removeSubscription(subscription.TopicFilter)
+	}
+	if len(subscribe.SubscribePayload) > 0 {
+		s.subscribes = append(s.subscribes, subscribe)
+	}
+	if subscribe.PacketIdentifier != 0 && !s.inUse(subscribe.PacketIdentifier) {
+		s.reserved = append(s.reserved, subscribe.PacketIdentifier)
+	}
+}
+
+// Unsubscribe removes the subscriptions of an UNSUBSCRIBE packet.
+func (s *Session) Unsubscribe(unsubscribe *mqtt.UnsubscribePacket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, topicFilter := range unsubscribe.UnsubscribePayload {
+		s.removeSubscription(topicFilter)
+	}
+}
+
+// removeSubscription removes the subscription to the topic filter, and the
+// SUBSCRIBE packet that it was in if that has no subscriptions left.
+func (s *Session) removeSubscription(topicFilter mqtt.TopicFilter) {
+	for i, subscribe := range s.subscribes {
+		for j, subscription := range subscribe.SubscribePayload {
+			if string(subscription.TopicFilter) != string(topicFilter) {
+				continue
+			}
+			subscribe.SubscribePayload = append(subscribe.SubscribePayload[:j], subscribe.SubscribePayload[j+1:]...)
+			if len(subscribe.SubscribePayload) == 0 {
+				copy(s.subscribes[i:], s.subscribes[i+1:])
+				s.subscribes[len(s.subscribes)-1] = nil
+				s.subscribes = s.subscribes[:len(s.subscribes)-1]
+			}
+			return
+		}
+	}
+}
+
+// Resume returns the packets that the client must send after reconnecting,
+// given the CONNACK packet of the new connection.
+//
+// If the server has the session (the Session Present flag is set), the
+// unacknowledged PUBLISH packets are retransmitted with the DUP flag set, and
+// the PUBREL packets of pending QoS 2 flows are retransmitted.
+//
+// If the server does not have the session, the client must discard its
+// session state as well. The client resubscribes with one SUBSCRIBE packet per
+// recorded SUBSCRIBE packet, with the same properties and with packet
+// identifiers from PacketIdentifier. The unacknowledged PUBLISH packets are
+// removed from the session and returned as discarded, so that the caller can
+// decide whether to publish them again as new messages. The PUBREL packets of
+// pending QoS 2 flows are dropped.
+func (s *Session) Resume(connack *mqtt.ConnackPacket) (packets []mqtt.Packet, discarded []*mqtt.PublishPacket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessionPresent := connack.SessionPresent()
+	// The server does not acknowledge SUBSCRIBE packets of the old connection.
+	s.reserved = s.reserved[:0]
+	if !sessionPresent {
+		for _, subscribe := range s.subscribes {
+			subscribe = subscribe.Clone()
+			subscribe.PacketIdentifier = s.nextPacketIdentifier()
+			s.reserved = append(s.reserved, subscribe.PacketIdentifier)
+			packets = append(packets, subscribe)
+		}
+	}
+	inflight := s.inflight[:0]
+	for _, packet := range s.inflight {
+		switch packet := packet.(type) {
+		case *mqtt.PublishPacket:
+			if !sessionPresent {
+				discarded = append(discarded, packet)
+				continue
+			}
+			packet.SetDup(true)
+			packets = append(packets, packet.Clone())
+			inflight = append(inflight, packet)
+		case *mqtt.PubrelPacket:
+			if sessionPresent {
+				packets = append(packets, packet.Clone())
+				inflight = append(inflight, packet)
+			}
+		}
+	}
+	for i := len(inflight); i < len(s.inflight); i++ {
+		s.inflight[i] = nil
+	}
+	s.inflight = inflight
+	return packets, discarded
+}
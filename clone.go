@@ -0,0 +1,195 @@
+package mqtt
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+// Clone returns a deep copy of the properties.
+func (properties Properties) Clone() Properties {
+	if properties == nil {
+		return nil
+	}
+	clone := make(Properties, len(properties))
+	for i, property := range properties {
+		clone[i] = property.Clone()
+	}
+	return clone
+}
+
+// Clone returns a deep copy of the property.
+func (p Property) Clone() Property {
+	p.BytesValue = cloneBytes(p.BytesValue)
+	p.StringPairValue.Key = cloneBytes(p.StringPairValue.Key)
+	p.StringPairValue.Value = cloneBytes(p.StringPairValue.Value)
+	return p
+}
+
+// Clone returns a deep copy of the ConnectPacket.
+func (p *ConnectPacket) Clone() *ConnectPacket {
+	clone := *p
+	clone.ConnectHeader.ProtocolName = cloneBytes(p.ConnectHeader.ProtocolName)
+	clone.ConnectPayload.ClientIdentifier = cloneBytes(p.ConnectPayload.ClientIdentifier)
+	clone.ConnectPayload.WillProperties = p.ConnectPayload.WillProperties.Clone()
+	clone.ConnectPayload.WillTopic = cloneBytes(p.ConnectPayload.WillTopic)
+	clone.ConnectPayload.WillMessage = cloneBytes(p.ConnectPayload.WillMessage)
+	clone.ConnectPayload.Username = cloneBytes(p.ConnectPayload.Username)
+	clone.ConnectPayload.Password = cloneBytes(p.ConnectPayload.Password)
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the ConnackPacket.
+func (p *ConnackPacket) Clone() *ConnackPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the PublishPacket.
+// A PublishPayloadReader can not be copied, so it is shared with the clone.
+func (p *PublishPacket) Clone() *PublishPacket {
+	clone := *p
+	clone.PublishHeader.TopicName = cloneBytes(p.PublishHeader.TopicName)
+	clone.Properties = p.Properties.Clone()
+	clone.PublishPayload = cloneBytes(p.PublishPayload)
+	return &clone
+}
+
+// Clone returns a deep copy of the PubackPacket.
+func (p *PubackPacket) Clone() *PubackPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the PubrecPacket.
+func (p *PubrecPacket) Clone() *PubrecPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the PubrelPacket.
+func (p *PubrelPacket) Clone() *PubrelPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the PubcompPacket.
+func (p *PubcompPacket) Clone() *PubcompPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the SubscribePacket.
+func (p *SubscribePacket) Clone() *SubscribePacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	if p.SubscribePayload != nil {
+		clone.SubscribePayload = make([]Subscription, len(p.SubscribePayload))
+		for i, subscription := range p.SubscribePayload {
+			subscription.TopicFilter = cloneBytes(subscription.TopicFilter)
+			clone.SubscribePayload[i] = subscription
+		}
+	}
+	return &clone
+}
+
+// Clone returns a deep copy of the SubackPacket.
+func (p *SubackPacket) Clone() *SubackPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	if p.SubackPayload != nil {
+		clone.SubackPayload = append(make([]ReasonCode, 0, len(p.SubackPayload)), p.SubackPayload...)
+	}
+	return &clone
+}
+
+// Clone returns a deep copy of the UnsubscribePacket.
+func (p *UnsubscribePacket) Clone() *UnsubscribePacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	if p.UnsubscribePayload != nil {
+		clone.UnsubscribePayload = make([]TopicFilter, len(p.UnsubscribePayload))
+		for i, topicFilter := range p.UnsubscribePayload {
+			clone.UnsubscribePayload[i] = cloneBytes(topicFilter)
+		}
+	}
+	return &clone
+}
+
+// Clone returns a deep copy of the UnsubackPacket.
+func (p *UnsubackPacket) Clone() *UnsubackPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	if p.UnsubackPayload != nil {
+		clone.UnsubackPayload = append(make([]ReasonCode, 0, len(p.UnsubackPayload)), p.UnsubackPayload...)
+	}
+	return &clone
+}
+
+// Clone returns a copy of the PingreqPacket.
+func (p *PingreqPacket) Clone() *PingreqPacket { return &PingreqPacket{} }
+
+// Clone returns a copy of the PingrespPacket.
+func (p *PingrespPacket) Clone() *PingrespPacket { return &PingrespPacket{} }
+
+// Clone returns a deep copy of the DisconnectPacket.
+func (p *DisconnectPacket) Clone() *DisconnectPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// Clone returns a deep copy of the AuthPacket.
+func (p *AuthPacket) Clone() *AuthPacket {
+	clone := *p
+	clone.Properties = p.Properties.Clone()
+	return &clone
+}
+
+// ClonePacket returns a deep copy of the packet.
+func ClonePacket(packet Packet) Packet {
+	switch packet := packet.(type) {
+	case *ConnectPacket:
+		return packet.Clone()
+	case *ConnackPacket:
+		return packet.Clone()
+	case *PublishPacket:
+		return packet.Clone()
+	case *EncodedPublishPacket:
+		clone := *packet // The encoded publish is not modified, so it can be shared.
+		return &clone
+	case *PubackPacket:
+		return packet.Clone()
+	case *PubrecPacket:
+		return packet.Clone()
+	case *PubrelPacket:
+		return packet.Clone()
+	case *PubcompPacket:
+		return packet.Clone()
+	case *SubscribePacket:
+		return packet.Clone()
+	case *SubackPacket:
+		return packet.Clone()
+	case *UnsubscribePacket:
+		return packet.Clone()
+	case *UnsubackPacket:
+		return packet.Clone()
+	case *PingreqPacket:
+		return packet.Clone()
+	case *PingrespPacket:
+		return packet.Clone()
+	case *DisconnectPacket:
+		return packet.Clone()
+	case *AuthPacket:
+		return packet.Clone()
+	default:
+		panic(errUnknownPacket)
+	}
+}
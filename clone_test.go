@@ -0,0 +1,151 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testProperties() Properties {
+	return Properties{
+		{Identifier: ReasonString, BytesValue: []byte("reason")},
+		{Identifier: UserProperty, StringPairValue: StringPair{Key: []byte("foo"), Value: []byte("bar")}},
+		{Identifier: UserProperty, StringPairValue: StringPair{Key: []byte("foo"), Value: []byte("baz")}},
+	}
+}
+
+func testPackets() []Packet {
+	connect := &ConnectPacket{
+		ConnectHeader:  ConnectHeader{ProtocolName: []byte("MQTT"), ProtocolVersion: 5, KeepAlive: 60},
+		ConnectPayload: ConnectPayload{ClientIdentifier: []byte("client")},
+		Properties:     Properties{{Identifier: SessionExpiryInterval, UintValue: 3600}},
+	}
+	connect.SetUsername([]byte("username"))
+	connect.SetPassword([]byte("password"))
+	connect.SetWill(Properties{{Identifier: WillDelayInterval, UintValue: 10}}, []byte("will-topic"), []byte("will-message"))
+
+	publish := &PublishPacket{
+		PublishHeader: PublishHeader{TopicName: []byte("foo"), PacketIdentifier: 1},
+		Properties: Properties{
+			{Identifier: ContentType, BytesValue: []byte("text/plain")},
+			{Identifier: UserProperty, StringPairValue: StringPair{Key: []byte("foo"), Value: []byte("bar")}},
+		},
+		PublishPayload: []byte("payload"),
+	}
+	publish.SetQoS(QoS1)
+
+	return []Packet{
+		connect,
+		&ConnackPacket{ConnackHeader: ConnackHeader{ReasonCode: NotAuthorized}, Properties: testProperties()},
+		publish,
+		&PubackPacket{PubackHeader: PubackHeader{PacketIdentifier: 1}, Properties: testProperties()},
+		&PubrecPacket{PubrecHeader: PubrecHeader{PacketIdentifier: 1}, Properties: testProperties()},
+		&PubrelPacket{PubrelHeader: PubrelHeader{PacketIdentifier: 1}, Properties: testProperties()},
+		&PubcompPacket{PubcompHeader: PubcompHeader{PacketIdentifier: 1}, Properties: testProperties()},
+		&SubscribePacket{
+			SubscribeHeader:  SubscribeHeader{PacketIdentifier: 1},
+			Properties:       Properties{{Identifier: SubscriptionIdentifier, UintValue: 1}},
+			SubscribePayload: []Subscription{{TopicFilter: []byte("foo/#"), QoS: QoS1}},
+		},
+		&SubackPacket{SubackHeader: SubackHeader{PacketIdentifier: 1}, Properties: testProperties(), SubackPayload: []ReasonCode{GrantedQoS1}},
+		&UnsubscribePacket{UnsubscribeHeader: UnsubscribeHeader{PacketIdentifier: 1}, UnsubscribePayload: []TopicFilter{[]byte("foo/#")}},
+		&UnsubackPacket{UnsubackHeader: UnsubackHeader{PacketIdentifier: 1}, Properties: testProperties(), UnsubackPayload: []ReasonCode{Success}},
+		&PingreqPacket{},
+		&PingrespPacket{},
+		&DisconnectPacket{DisconnectHeader: DisconnectHeader{ReasonCode: ServerMoved}, Properties: testProperties()},
+		&AuthPacket{AuthHeader: AuthHeader{ReasonCode: ContinueAuthentication}, Properties: testProperties()},
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, packet := range testPackets() {
+		t.Run(fmt.Sprintf("%T", packet), func(t *testing.T) {
+			assert := assert.New(t)
+
+			clone := ClonePacket(packet)
+			assert.Equal(packet, clone)
+			assert.True(PacketsEqual(packet, clone))
+
+			// Modifying the original must not modify the clone.
+			switch packet := packet.(type) {
+			case *ConnectPacket:
+				packet.ConnectPayload.ClientIdentifier[0] = 'x'
+				packet.ConnectPayload.WillProperties[0].UintValue = 20
+			case *PublishPacket:
+				packet.TopicName[0] = 'x'
+				packet.PublishPayload[0] = 'x'
+				packet.Properties[1].StringPairValue.Value[0] = 'x'
+			case *SubscribePacket:
+				packet.SubscribePayload[0].TopicFilter[0] = 'x'
+			case *SubackPacket:
+				packet.SubackPayload[0] = UnspecifiedError
+			case *UnsubscribePacket:
+				packet.UnsubscribePayload[0][0] = 'x'
+			case *UnsubackPacket:
+				packet.UnsubackPayload[0] = UnspecifiedError
+			case *PingreqPacket, *PingrespPacket:
+				return
+			default:
+				packetProperties(packet)[0].BytesValue[0] = 'x'
+			}
+			assert.False(PacketsEqual(packet, clone))
+		})
+	}
+}
+
+func packetProperties(packet Packet) Properties {
+	switch packet := packet.(type) {
+	case *ConnackPacket:
+		return packet.Properties
+	case *PubackPacket:
+		return packet.Properties
+	case *PubrecPacket:
+		return packet.Properties
+	case *PubrelPacket:
+		return packet.Properties
+	case *PubcompPacket:
+		return packet.Properties
+	case *DisconnectPacket:
+		return packet.Properties
+	case *AuthPacket:
+		return packet.Properties
+	}
+	return nil
+}
+
+func TestPropertiesEqual(t *testing.T) {
+	assert := assert.New(t)
+
+	a := testProperties()
+
+	assert.True(a.Equal(testProperties()))
+	assert.True(Properties(nil).Equal(Properties{}))
+
+	// The order of other properties is not significant.
+	b := Properties{a[1], a[0], a[2]}
+	assert.True(a.Equal(b))
+
+	// The order of User Properties is significant.
+	c := Properties{a[0], a[2], a[1]}
+	assert.False(a.Equal(c))
+
+	assert.False(a.Equal(a[:2]))
+}
+
+func TestPublishPacketEqual(t *testing.T) {
+	assert := assert.New(t)
+
+	a := &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo"), PacketIdentifier: 1}}
+	b := &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo"), PacketIdentifier: 2}}
+
+	// The Packet Identifier is not significant for QoS 0.
+	assert.True(a.Equal(b))
+
+	a.SetQoS(QoS1)
+	b.SetQoS(QoS1)
+	assert.False(a.Equal(b))
+
+	assert.False(a.Equal(nil))
+	assert.True((*PublishPacket)(nil).Equal(nil))
+}
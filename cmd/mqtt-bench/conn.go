@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+// conn is an MQTT connection to the broker under test.
+type conn struct {
+	net.Conn
+	reader *mqtt.PacketReader
+
+	mu     sync.Mutex
+	buf    *bufio.Writer
+	writer *mqtt.PacketWriter
+}
+
+func dial(cfg *config, clientID string) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", cfg.broker, cfg.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, buf: bufio.NewWriter(nc)}
+	c.reader = mqtt.NewReader(nc)
+	c.reader.SetProtocol(cfg.protocol)
+	c.writer = mqtt.NewWriter(c.buf)
+	c.writer.SetProtocol(cfg.protocol)
+
+	connect := new(mqtt.ConnectPacket)
+	connect.ProtocolVersion = cfg.protocol
+	connect.ClientIdentifier = []byte(clientID)
+	connect.SetCleanStart(true)
+	connect.KeepAlive = uint16(cfg.keepAlive / time.Second)
+	if cfg.username != "" {
+		connect.SetUsername([]byte(cfg.username))
+	}
+	if cfg.password != "" {
+		connect.SetPassword([]byte(cfg.password))
+	}
+
+	nc.SetDeadline(time.Now().Add(cfg.timeout))
+	if err = c.writePacket(connect); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	packet, err := c.reader.ReadPacket()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	connack, ok := packet.(*mqtt.ConnackPacket)
+	if !ok {
+		nc.Close()
+		return nil, errors.New("first packet was not CONNACK")
+	}
+	if connack.ReasonCode.IsError() {
+		nc.Close()
+		return nil, mqtt.NewReasonCodeError(connack.ReasonCode, fmt.Sprintf("connect failed: %s", connack.ReasonCode))
+	}
+	return c, nil
+}
+
+// writePacket writes the packet and flushes the buffered writer.
+func (c *conn) writePacket(packet mqtt.Packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writer.WritePacket(packet); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+// keepAlive sends PINGREQ packets until done is closed.
+func (c *conn) keepAlive(interval time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.writePacket(new(mqtt.PingreqPacket)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// disconnect sends a DISCONNECT packet and closes the connection.
+func (c *conn) disconnect() {
+	c.writePacket(new(mqtt.DisconnectPacket))
+	c.Close()
+}
@@ -0,0 +1,384 @@
+// Command mqtt-bench generates MQTT load against a broker and reports
+// throughput, end-to-end latency and errors.
+//
+// Publishers publish messages to a number of topics under a common prefix and
+// subscribers each subscribe to one of those topics. Every message carries its
+// publish time, either as a "ts" User Property (MQTT 5) or in the first 8 bytes
+// of the payload (MQTT 3.1.1 and earlier), so that subscribers can measure the
+// end-to-end latency.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+type config struct {
+	broker       string
+	protocol     byte
+	username     string
+	password     string
+	clientPrefix string
+	topic        string
+	topics       int
+	publishers   int
+	subscribers  int
+	qos          mqtt.QoS
+	size         int
+	rate         float64
+	inflight     int
+	duration     time.Duration
+	drain        time.Duration
+	timeout      time.Duration
+	keepAlive    time.Duration
+}
+
+// timestampKey is the User Property key that holds the publish timestamp.
+var timestampKey = []byte("ts")
+
+func main() {
+	var (
+		cfg      config
+		protocol uint
+		qos      uint
+	)
+	flag.StringVar(&cfg.broker, "broker", "localhost:1883", "address of the broker")
+	flag.UintVar(&protocol, "protocol", 5, "MQTT protocol version (3, 4 or 5)")
+	flag.StringVar(&cfg.username, "username", "", "username to connect with")
+	flag.StringVar(&cfg.password, "password", "", "password to connect with")
+	flag.StringVar(&cfg.clientPrefix, "client-prefix", "mqtt-bench", "prefix for client identifiers")
+	flag.StringVar(&cfg.topic, "topic", "mqtt-bench", "prefix for topic names")
+	flag.IntVar(&cfg.topics, "topics", 1, "number of topics to spread messages over")
+	flag.IntVar(&cfg.publishers, "publishers", 1, "number of publishers")
+	flag.IntVar(&cfg.subscribers, "subscribers", 1, "number of subscribers")
+	flag.UintVar(&qos, "qos", 0, "QoS of published messages and subscriptions")
+	flag.IntVar(&cfg.size, "size", 64, "payload size in bytes")
+	flag.Float64Var(&cfg.rate, "rate", 10, "messages per second per publisher (0 is unlimited)")
+	flag.IntVar(&cfg.inflight, "inflight", 100, "maximum unacknowledged QoS 1/2 messages per publisher")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "duration of the benchmark")
+	flag.DurationVar(&cfg.drain, "drain", time.Second, "time to wait for messages after publishing stops")
+	flag.DurationVar(&cfg.timeout, "timeout", 10*time.Second, "timeout for connecting and subscribing")
+	flag.DurationVar(&cfg.keepAlive, "keep-alive", 30*time.Second, "keep alive interval")
+	flag.Parse()
+
+	cfg.protocol, cfg.qos = byte(protocol), mqtt.QoS(qos)
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		cancel()
+	}()
+
+	st := newStats()
+	elapsed := run(ctx, &cfg, st)
+	st.report(os.Stdout, elapsed)
+}
+
+func (cfg *config) validate() error {
+	switch {
+	case cfg.protocol < 3 || cfg.protocol > 5:
+		return fmt.Errorf("invalid protocol version %d", cfg.protocol)
+	case cfg.qos > mqtt.QoS2:
+		return fmt.Errorf("invalid QoS %d", cfg.qos)
+	case cfg.topics < 1:
+		return fmt.Errorf("invalid number of topics %d", cfg.topics)
+	case cfg.publishers < 0 || cfg.subscribers < 0:
+		return fmt.Errorf("invalid number of publishers or subscribers")
+	case cfg.inflight < 1 || cfg.inflight > 65535:
+		return fmt.Errorf("invalid number of inflight messages %d", cfg.inflight)
+	}
+	if cfg.protocol < 5 && cfg.size < 8 {
+		cfg.size = 8 // The timestamp is stored in the payload.
+	}
+	return nil
+}
+
+func (cfg *config) topicName(i int) []byte {
+	return []byte(fmt.Sprintf("%s/%d", cfg.topic, i%cfg.topics))
+}
+
+// run runs the benchmark and returns the time during which was published.
+func run(ctx context.Context, cfg *config, st *stats) time.Duration {
+	subCtx, stopSubscribers := context.WithCancel(context.Background())
+	defer stopSubscribers()
+
+	var subscribers, ready sync.WaitGroup
+	for i := 0; i < cfg.subscribers; i++ {
+		subscribers.Add(1)
+		ready.Add(1)
+		go func(i int) {
+			defer subscribers.Done()
+			subscribe(subCtx, cfg, i, st, ready.Done)
+		}(i)
+	}
+	ready.Wait()
+
+	pubCtx, stopPublishers := context.WithTimeout(ctx, cfg.duration)
+	defer stopPublishers()
+
+	start := time.Now()
+	var publishers sync.WaitGroup
+	for i := 0; i < cfg.publishers; i++ {
+		publishers.Add(1)
+		go func(i int) {
+			defer publishers.Done()
+			publish(pubCtx, cfg, i, st)
+		}(i)
+	}
+	publishers.Wait()
+	elapsed := time.Since(start)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(cfg.drain):
+	}
+	stopSubscribers()
+	subscribers.Wait()
+
+	return elapsed
+}
+
+// idAllocator allocates packet identifiers that are not in use.
+type idAllocator struct {
+	mu    sync.Mutex
+	next  uint16
+	inUse map[uint16]bool
+}
+
+func (a *idAllocator) allocate() uint16 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for {
+		a.next++
+		if a.next == 0 {
+			a.next = 1
+		}
+		if !a.inUse[a.next] {
+			a.inUse[a.next] = true
+			return a.next
+		}
+	}
+}
+
+func (a *idAllocator) release(id uint16) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.inUse[id] {
+		return false
+	}
+	delete(a.inUse, id)
+	return true
+}
+
+func publish(ctx context.Context, cfg *config, i int, st *stats) {
+	c, err := dial(cfg, fmt.Sprintf("%s-pub-%d", cfg.clientPrefix, i))
+	if err != nil {
+		st.addError(err)
+		log.Printf("publisher %d: %v", i, err)
+		return
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		c.disconnect()
+	}()
+	go c.keepAlive(cfg.keepAlive, done)
+
+	ids := &idAllocator{inUse: make(map[uint16]bool)}
+	inflight := make(chan struct{}, cfg.inflight)
+	complete := func(id uint16) {
+		if ids.release(id) {
+			<-inflight
+		}
+	}
+
+	go func() {
+		for {
+			packet, err := c.reader.ReadPacket()
+			if err != nil {
+				select {
+				case <-done:
+				default:
+					st.addError(err)
+				}
+				return
+			}
+			switch packet := packet.(type) {
+			case *mqtt.PubackPacket:
+				st.addReasonCode(packet.ReasonCode)
+				complete(packet.PacketIdentifier)
+			case *mqtt.PubrecPacket:
+				st.addReasonCode(packet.ReasonCode)
+				if packet.ReasonCode.IsError() {
+					complete(packet.PacketIdentifier)
+					continue
+				}
+				if err := c.writePacket(packet.Pubrel()); err != nil {
+					st.addError(err)
+				}
+			case *mqtt.PubcompPacket:
+				st.addReasonCode(packet.ReasonCode)
+				complete(packet.PacketIdentifier)
+			case *mqtt.DisconnectPacket:
+				st.addReasonCode(packet.ReasonCode)
+				return
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if cfg.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	payload := make([]byte, cfg.size)
+	for n := i; ; n++ {
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+		if cfg.qos > mqtt.QoS0 {
+			select {
+			case <-ctx.Done():
+				return
+			case inflight <- struct{}{}:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		packet := new(mqtt.PublishPacket)
+		packet.SetQoS(cfg.qos)
+		packet.TopicName = cfg.topicName(n)
+		packet.PublishPayload = payload
+		if packet.QoS() > mqtt.QoS0 {
+			packet.PacketIdentifier = ids.allocate()
+		}
+		now := time.Now().UnixNano()
+		if cfg.protocol >= 5 {
+			packet.Properties = mqtt.Properties{{
+				Identifier: mqtt.UserProperty,
+				StringPairValue: mqtt.StringPair{
+					Key:   timestampKey,
+					Value: strconv.AppendInt(nil, now, 10),
+				},
+			}}
+		} else {
+			binary.BigEndian.PutUint64(payload, uint64(now))
+		}
+		if err := c.writePacket(packet); err != nil {
+			st.addError(err)
+			return
+		}
+		st.addSent(len(payload))
+	}
+}
+
+func subscribe(ctx context.Context, cfg *config, i int, st *stats, ready func()) {
+	var once sync.Once
+	defer once.Do(ready)
+
+	c, err := dial(cfg, fmt.Sprintf("%s-sub-%d", cfg.clientPrefix, i))
+	if err != nil {
+		st.addError(err)
+		log.Printf("subscriber %d: %v", i, err)
+		return
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go c.keepAlive(cfg.keepAlive, done)
+	go func() {
+		<-ctx.Done()
+		c.disconnect()
+	}()
+
+	subscribe := new(mqtt.SubscribePacket)
+	subscribe.PacketIdentifier = 1
+	subscribe.SubscribePayload = []mqtt.Subscription{{
+		TopicFilter: cfg.topicName(i),
+		QoS:         cfg.qos,
+	}}
+	if err := c.writePacket(subscribe); err != nil {
+		st.addError(err)
+		return
+	}
+	c.SetReadDeadline(time.Now().Add(cfg.timeout))
+
+	for {
+		packet, err := c.reader.ReadPacket()
+		if err != nil {
+			if ctx.Err() == nil {
+				st.addError(err)
+			}
+			return
+		}
+		switch packet := packet.(type) {
+		case *mqtt.SubackPacket:
+			c.SetReadDeadline(time.Time{})
+			for _, reasonCode := range packet.SubackPayload {
+				st.addReasonCode(reasonCode)
+			}
+			once.Do(ready)
+		case *mqtt.PublishPacket:
+			if sentAt, ok := publishTime(cfg, packet); ok {
+				st.addReceived(len(packet.PublishPayload), time.Since(sentAt))
+			} else {
+				st.addReceivedWithoutTimestamp(len(packet.PublishPayload))
+			}
+			if reply := packet.Reply(); reply != nil {
+				err = c.writePacket(reply)
+			}
+		case *mqtt.PubrelPacket:
+			err = c.writePacket(packet.Pubcomp())
+		case *mqtt.DisconnectPacket:
+			st.addReasonCode(packet.ReasonCode)
+			return
+		}
+		if err != nil {
+			st.addError(err)
+			return
+		}
+	}
+}
+
+// publishTime extracts the publish time from the packet. It returns false if
+// the packet does not contain a valid timestamp.
+func publishTime(cfg *config, packet *mqtt.PublishPacket) (time.Time, bool) {
+	if cfg.protocol >= 5 {
+		for _, property := range packet.Properties {
+			if property.Identifier != mqtt.UserProperty || string(property.StringPairValue.Key) != string(timestampKey) {
+				continue
+			}
+			nanos, err := strconv.ParseInt(string(property.StringPairValue.Value), 10, 64)
+			if err == nil {
+				return time.Unix(0, nanos), true
+			}
+		}
+		return time.Time{}, false
+	}
+	if len(packet.PublishPayload) < 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(packet.PublishPayload))), true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+// otherErrors is the key under which errors without reason code are counted.
+const otherErrors = "other"
+
+type stats struct {
+	sent          uint64
+	sentBytes     uint64
+	received      uint64
+	receivedBytes uint64
+	// untimed is the number of received messages without a valid timestamp,
+	// which are not included in the latencies.
+	untimed uint64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    map[string]uint64
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]uint64)}
+}
+
+func (s *stats) addSent(bytes int) {
+	atomic.AddUint64(&s.sent, 1)
+	atomic.AddUint64(&s.sentBytes, uint64(bytes))
+}
+
+func (s *stats) addReceived(bytes int, latency time.Duration) {
+	atomic.AddUint64(&s.received, 1)
+	atomic.AddUint64(&s.receivedBytes, uint64(bytes))
+	s.mu.Lock()
+	s.latencies = append(s.latencies, latency)
+	s.mu.Unlock()
+}
+
+func (s *stats) addReceivedWithoutTimestamp(bytes int) {
+	atomic.AddUint64(&s.received, 1)
+	atomic.AddUint64(&s.receivedBytes, uint64(bytes))
+	atomic.AddUint64(&s.untimed, 1)
+}
+
+// addReasonCode counts the reason code if it is an error.
+func (s *stats) addReasonCode(c mqtt.ReasonCode) {
+	if !c.IsError() {
+		return
+	}
+	s.mu.Lock()
+	s.errors[fmt.Sprintf("0x%02x %s", byte(c), c)]++
+	s.mu.Unlock()
+}
+
+// addError counts the error by its reason code, if it has one.
+func (s *stats) addError(err error) {
+	if err == nil {
+		return
+	}
+	if err, ok := err.(interface{ ReasonCode() mqtt.ReasonCode }); ok {
+		s.addReasonCode(err.ReasonCode())
+		return
+	}
+	s.mu.Lock()
+	s.errors[otherErrors]++
+	s.mu.Unlock()
+}
+
+// percentile returns the p-th percentile (0-100) of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seconds := elapsed.Seconds()
+	sent, sentBytes := atomic.LoadUint64(&s.sent), atomic.LoadUint64(&s.sentBytes)
+	received, receivedBytes := atomic.LoadUint64(&s.received), atomic.LoadUint64(&s.receivedBytes)
+
+	fmt.Fprintf(w, "duration:   %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "sent:       %d msgs (%.1f msg/s, %.1f KiB/s)\n", sent, float64(sent)/seconds, float64(sentBytes)/1024/seconds)
+	fmt.Fprintf(w, "received:   %d msgs (%.1f msg/s, %.1f KiB/s)\n", received, float64(received)/seconds, float64(receivedBytes)/1024/seconds)
+
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	fmt.Fprintf(w, "latency:    p50=%s p90=%s p99=%s max=%s\n",
+		percentile(s.latencies, 50),
+		percentile(s.latencies, 90),
+		percentile(s.latencies, 99),
+		percentile(s.latencies, 100),
+	)
+	if untimed := atomic.LoadUint64(&s.untimed); untimed > 0 {
+		fmt.Fprintf(w, "untimed:    %d msgs without valid timestamp\n", untimed)
+	}
+
+	if len(s.errors) == 0 {
+		fmt.Fprintln(w, "errors:     none")
+		return
+	}
+	keys := make([]string, 0, len(s.errors))
+	for k := range s.errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(w, "errors:")
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %-40s %d\n", k, s.errors[k])
+	}
+}
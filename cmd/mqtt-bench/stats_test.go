@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestPercentile(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Duration(0), percentile(nil, 50))
+
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(1*time.Millisecond, percentile(sorted, 0))
+	assert.Equal(50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(100*time.Millisecond, percentile(sorted, 100))
+}
+
+func TestStatsErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	st := newStats()
+	st.addReasonCode(mqtt.Success)
+	st.addReasonCode(mqtt.QuotaExceeded)
+	st.addError(mqtt.NewReasonCodeError(mqtt.QuotaExceeded, "quota exceeded"))
+	st.addError(errors.New("some error"))
+
+	assert.Equal(map[string]uint64{
+		"0x97 Quota exceeded": 2,
+		otherErrors:           1,
+	}, st.errors)
+}
+
+func TestPublishTime(t *testing.T) {
+	assert := assert.New(t)
+
+	sentAt := time.Unix(0, 1234567890)
+
+	tests := []struct {
+		name     string
+		protocol byte
+		packet   *mqtt.PublishPacket
+		ok       bool
+	}{
+		{"v5", 5, &mqtt.PublishPacket{Properties: mqtt.Properties{{
+			Identifier:      mqtt.UserProperty,
+			StringPairValue: mqtt.StringPair{Key: timestampKey, Value: []byte("1234567890")},
+		}}}, true},
+		{"v5 missing", 5, &mqtt.PublishPacket{}, false},
+		{"v5 malformed", 5, &mqtt.PublishPacket{Properties: mqtt.Properties{{
+			Identifier:      mqtt.UserProperty,
+			StringPairValue: mqtt.StringPair{Key: timestampKey, Value: []byte("foo")},
+		}}}, false},
+		{"v3.1.1", 4, &mqtt.PublishPacket{PublishPayload: []byte{0, 0, 0, 0, 0x49, 0x96, 0x02, 0xd2}}, true},
+		{"v3.1.1 short", 4, &mqtt.PublishPacket{PublishPayload: []byte{0x49}}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := publishTime(&config{protocol: tt.protocol}, tt.packet)
+		assert.Equal(tt.ok, ok, tt.name)
+		if tt.ok {
+			assert.True(sentAt.Equal(got), tt.name)
+		}
+	}
+
+	st := newStats()
+	st.addReceived(10, time.Millisecond)
+	st.addReceivedWithoutTimestamp(10)
+	assert.Equal(uint64(2), st.received)
+	assert.Equal(uint64(1), st.untimed)
+	assert.Len(st.latencies, 1)
+}
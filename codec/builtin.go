@@ -0,0 +1,108 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"htdvisser.dev/mqtt"
+)
+
+type jsonCodec struct{}
+
+// JSON is the codec for application/json.
+var JSON Codec = jsonCodec{}
+
+func (jsonCodec) ContentType() string                        { return "application/json" }
+func (jsonCodec) PayloadFormat() byte                        { return mqtt.PayloadFormatUTF8 }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+var errInvalidText = errors.New("codec: text is not valid UTF-8")
+
+type textCodec struct{}
+
+// Text is the codec for text/plain. It encodes strings, byte slices, values
+// that implement encoding.TextMarshaler and values that implement fmt.Stringer,
+// and decodes into strings, byte slices, values that implement
+// encoding.TextUnmarshaler and empty interfaces.
+var Text Codec = textCodec{}
+
+func (textCodec) ContentType() string { return "text/plain" }
+func (textCodec) PayloadFormat() byte { return mqtt.PayloadFormatUTF8 }
+
+func (textCodec) Marshal(v interface{}) (data []byte, err error) {
+	switch v := v.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case encoding.TextMarshaler:
+		if data, err = v.MarshalText(); err != nil {
+			return nil, err
+		}
+	case fmt.Stringer:
+		data = []byte(v.String())
+	default:
+		return nil, fmt.Errorf("codec: can not encode %T as text", v)
+	}
+	if !utf8.Valid(data) {
+		return nil, errInvalidText
+	}
+	return data, nil
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	if !utf8.Valid(data) {
+		return errInvalidText
+	}
+	switch v := v.(type) {
+	case *string:
+		*v = string(data)
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	case encoding.TextUnmarshaler:
+		return v.UnmarshalText(data)
+	case *interface{}:
+		*v = string(data)
+	default:
+		return fmt.Errorf("codec: can not decode text into %T", v)
+	}
+	return nil
+}
+
+type rawCodec struct{}
+
+// Raw is the codec for application/octet-stream. It encodes byte slices and
+// strings, and decodes into byte slices, strings and empty interfaces.
+var Raw Codec = rawCodec{}
+
+func (rawCodec) ContentType() string { return "application/octet-stream" }
+func (rawCodec) PayloadFormat() byte { return mqtt.PayloadFormatBytes }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("codec: can not encode %T as raw bytes", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	case *string:
+		*v = string(data)
+	case *interface{}:
+		*v = append([]byte(nil), data...)
+	default:
+		return fmt.Errorf("codec: can not decode raw bytes into %T", v)
+	}
+	return nil
+}
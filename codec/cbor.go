@@ -0,0 +1,733 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"htdvisser.dev/mqtt"
+)
+
+type cborCodec struct{}
+
+// CBOR is the codec for application/cbor (RFC 8949). It supports booleans,
+// integers, floats, strings, byte slices, slices, arrays, maps, structs and
+// pointers to those. Struct fields can be renamed or skipped with a `cbor`
+// struct tag, in the same way as with encoding/json. Map keys are sorted, so
+// that the encoding is deterministic.
+//
+// When decoding into an empty interface, CBOR produces nil, bool, uint64 (for
+// unsigned integers), int64 (for negative integers), float64, string, []byte,
+// []interface{}, map[string]interface{} (for maps with only string keys) or
+// map[interface{}]interface{}.
+var CBOR Codec = cborCodec{}
+
+func (cborCodec) ContentType() string { return "application/cbor" }
+func (cborCodec) PayloadFormat() byte { return mqtt.PayloadFormatBytes }
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	var e cborEncoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("codec: can not decode CBOR into %T", v)
+	}
+	d := cborDecoder{data: data}
+	if err := d.decode(rv.Elem(), 0); err != nil {
+		return err
+	}
+	if len(d.data) > 0 {
+		return errCBORTrailingData
+	}
+	return nil
+}
+
+// CBOR major types.
+const (
+	cborUint   byte = 0
+	cborNegInt byte = 1
+	cborBytes  byte = 2
+	cborText   byte = 3
+	cborArray  byte = 4
+	cborMap    byte = 5
+	cborTag    byte = 6
+	cborSimple byte = 7
+)
+
+// CBOR simple values and special encodings.
+const (
+	cborFalse      byte = 0xf4
+	cborTrue       byte = 0xf5
+	cborNull       byte = 0xf6
+	cborUndefined  byte = 0xf7
+	cborFloat32    byte = 0xfa
+	cborFloat64    byte = 0xfb
+	cborBreak      byte = 0xff
+	cborIndefinite byte = 31
+)
+
+type cborEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *cborEncoder) writeHead(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		e.buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		e.buf.Write([]byte{major | 24, byte(n)})
+	case n <= math.MaxUint16:
+		var b [3]byte
+		b[0] = major | 25
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		e.buf.Write(b[:])
+	case n <= math.MaxUint32:
+		var b [5]byte
+		b[0] = major | 26
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.buf.Write(b[:])
+	default:
+		var b [9]byte
+		b[0] = major | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.buf.Write(b[:])
+	}
+}
+
+func (e *cborEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf.WriteByte(cborNull)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf.WriteByte(cborTrue)
+		} else {
+			e.buf.WriteByte(cborFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := v.Int(); i < 0 {
+			e.writeHead(cborNegInt, uint64(-1-i))
+		} else {
+			e.writeHead(cborUint, uint64(i))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeHead(cborUint, v.Uint())
+	case reflect.Float32:
+		var b [5]byte
+		b[0] = cborFloat32
+		binary.BigEndian.PutUint32(b[1:], math.Float32bits(float32(v.Float())))
+		e.buf.Write(b[:])
+	case reflect.Float64:
+		var b [9]byte
+		b[0] = cborFloat64
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(v.Float()))
+		e.buf.Write(b[:])
+	case reflect.String:
+		e.writeHead(cborText, uint64(v.Len()))
+		e.buf.WriteString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeHead(cborBytes, uint64(v.Len()))
+			for i := 0; i < v.Len(); i++ {
+				e.buf.WriteByte(byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+		e.writeHead(cborArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf.WriteByte(cborNull)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("codec: can not encode %s as CBOR", v.Type())
+	}
+	return nil
+}
+
+// encodeMap encodes a map with its keys sorted by their encoding, as in the
+// core deterministic encoding of RFC 8949.
+func (e *cborEncoder) encodeMap(v reflect.Value) error {
+	type entry struct{ key, value []byte }
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		var key, value cborEncoder
+		if err := key.encode(iter.Key()); err != nil {
+			return err
+		}
+		if err := value.encode(iter.Value()); err != nil {
+			return err
+		}
+		entries = append(entries, entry{key.buf.Bytes(), value.buf.Bytes()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	e.writeHead(cborMap, uint64(len(entries)))
+	for _, entry := range entries {
+		e.buf.Write(entry.key)
+		e.buf.Write(entry.value)
+	}
+	return nil
+}
+
+type cborField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+func cborFields(t reflect.Type) []cborField {
+	fields := make([]cborField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		field := cborField{name: f.Name, index: i}
+		if tag, ok := f.Tag.Lookup("cbor"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				field.name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				if option == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func (e *cborEncoder) encodeStruct(v reflect.Value) error {
+	fields := cborFields(v.Type())
+	n := 0
+	for _, field := range fields {
+		if !field.omitEmpty || !isEmptyValue(v.Field(field.index)) {
+			n++
+		}
+	}
+	e.writeHead(cborMap, uint64(n))
+	for _, field := range fields {
+		value := v.Field(field.index)
+		if field.omitEmpty && isEmptyValue(value) {
+			continue
+		}
+		e.writeHead(cborText, uint64(len(field.name)))
+		e.buf.WriteString(field.name)
+		if err := e.encode(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	errCBORUnexpectedEnd    = errors.New("codec: unexpected end of CBOR data")
+	errCBORTrailingData     = errors.New("codec: trailing data after CBOR data item")
+	errCBORMalformed        = errors.New("codec: malformed CBOR data")
+	errCBORTooDeep          = errors.New("codec: CBOR data is nested too deeply")
+	errCBORKeyNotComparable = errors.New("codec: CBOR map key is not comparable")
+)
+
+// maxCBORDepth limits the nesting of decoded CBOR data items.
+const maxCBORDepth = 256
+
+type cborDecoder struct {
+	data []byte
+}
+
+// readHead reads the head of a data item, and returns its major type,
+// additional information and argument.
+func (d *cborDecoder) readHead() (major, info byte, arg uint64, err error) {
+	if len(d.data) == 0 {
+		return 0, 0, 0, errCBORUnexpectedEnd
+	}
+	major, info = d.data[0]>>5, d.data[0]&0x1f
+	d.data = d.data[1:]
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == cborIndefinite && major >= cborBytes && major <= cborMap:
+		return major, info, 0, nil
+	case info == cborIndefinite && major == cborSimple:
+		return major, info, 0, nil // break
+	default:
+		return 0, 0, 0, errCBORMalformed
+	}
+	if len(d.data) < size {
+		return 0, 0, 0, errCBORUnexpectedEnd
+	}
+	for _, b := range d.data[:size] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.data = d.data[size:]
+	return major, info, arg, nil
+}
+
+func (d *cborDecoder) peekBreak() bool {
+	if len(d.data) > 0 && d.data[0] == cborBreak {
+		d.data = d.data[1:]
+		return true
+	}
+	return false
+}
+
+// readString reads the content of a (possibly indefinite length) byte or text
+// string.
+func (d *cborDecoder) readString(major, info byte, arg uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		if arg > uint64(len(d.data)) {
+			return nil, errCBORUnexpectedEnd
+		}
+		s := d.data[:arg]
+		d.data = d.data[arg:]
+		return s, nil
+	}
+	var s []byte
+	for !d.peekBreak() {
+		chunkMajor, chunkInfo, chunkArg, err := d.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, errCBORMalformed
+		}
+		chunk, err := d.readString(chunkMajor, chunkInfo, chunkArg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+	return s, nil
+}
+
+// readLength returns the number of items in an array or map, or -1 for
+// indefinite length. Each item takes at least one byte, which is used to reject
+// lengths that exceed the remaining data before allocating anything.
+func (d *cborDecoder) readLength(info byte, arg uint64, itemSize uint64) (int, error) {
+	if info == cborIndefinite {
+		return -1, nil
+	}
+	if arg > uint64(len(d.data))/itemSize {
+		return 0, errCBORUnexpectedEnd
+	}
+	return int(arg), nil
+}
+
+// more returns whether the array or map with length n has more items after i.
+func (d *cborDecoder) more(i, n int) bool {
+	if n < 0 {
+		return !d.peekBreak()
+	}
+	return i < n
+}
+
+func (d *cborDecoder) decode(v reflect.Value, depth int) error {
+	if depth > maxCBORDepth {
+		return errCBORTooDeep
+	}
+	if len(d.data) > 0 && (d.data[0] == cborNull || d.data[0] == cborUndefined) {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			d.data = d.data[1:]
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem(), depth+1)
+	}
+	if v.Kind() == reflect.Interface {
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("codec: can not decode CBOR into %s", v.Type())
+		}
+		value, err := d.decodeInterface(depth)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return err
+	}
+	mismatch := func(what string) error {
+		return fmt.Errorf("codec: can not decode CBOR %s into %s", what, v.Type())
+	}
+	switch major {
+	case cborUint, cborNegInt:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if arg > math.MaxInt64 {
+				return mismatch("integer")
+			}
+			i := int64(arg)
+			if major == cborNegInt {
+				i = -1 - i
+			}
+			if v.OverflowInt(i) {
+				return mismatch("integer")
+			}
+			v.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if major == cborNegInt || v.OverflowUint(arg) {
+				return mismatch("integer")
+			}
+			v.SetUint(arg)
+		case reflect.Float32, reflect.Float64:
+			f := float64(arg)
+			if major == cborNegInt {
+				f = -1 - f
+			}
+			v.SetFloat(f)
+		default:
+			return mismatch("integer")
+		}
+	case cborBytes:
+		s, err := d.readString(major, info, arg)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(append([]byte(nil), s...))
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8 && v.Len() == len(s):
+			reflect.Copy(v, reflect.ValueOf(s))
+		default:
+			return mismatch("byte string")
+		}
+	case cborText:
+		s, err := d.readString(major, info, arg)
+		if err != nil {
+			return err
+		}
+		if v.Kind() != reflect.String {
+			return mismatch("text string")
+		}
+		v.SetString(string(s))
+	case cborArray:
+		n, err := d.readLength(info, arg, 1)
+		if err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Slice:
+			if n >= 0 {
+				v.Set(reflect.MakeSlice(v.Type(), n, n))
+			} else {
+				v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			}
+			for i := 0; d.more(i, n); i++ {
+				if n < 0 {
+					v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+				}
+				if err := d.decode(v.Index(i), depth+1); err != nil {
+					return err
+				}
+			}
+		case reflect.Array:
+			i := 0
+			for ; d.more(i, n); i++ {
+				if i >= v.Len() {
+					return mismatch("array")
+				}
+				if err := d.decode(v.Index(i), depth+1); err != nil {
+					return err
+				}
+			}
+			if i != v.Len() {
+				return mismatch("array")
+			}
+		default:
+			return mismatch("array")
+		}
+	case cborMap:
+		n, err := d.readLength(info, arg, 2)
+		if err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			for i := 0; d.more(i, n); i++ {
+				key := reflect.New(v.Type().Key()).Elem()
+				if err := d.decode(key, depth+1); err != nil {
+					return err
+				}
+				if key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable() {
+					return errCBORKeyNotComparable
+				}
+				value := reflect.New(v.Type().Elem()).Elem()
+				if err := d.decode(value, depth+1); err != nil {
+					return err
+				}
+				v.SetMapIndex(key, value)
+			}
+		case reflect.Struct:
+			fields := cborFields(v.Type())
+			for i := 0; d.more(i, n); i++ {
+				var name string
+				if err := d.decode(reflect.ValueOf(&name).Elem(), depth+1); err != nil {
+					return err
+				}
+				field, ok := findField(fields, name)
+				if !ok {
+					if err := d.skip(depth + 1); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := d.decode(v.Field(field.index), depth+1); err != nil {
+					return err
+				}
+			}
+		default:
+			return mismatch("map")
+		}
+	case cborTag:
+		return d.decode(v, depth+1)
+	case cborSimple:
+		switch {
+		case info == 20 || info == 21:
+			if v.Kind() != reflect.Bool {
+				return mismatch("boolean")
+			}
+			v.SetBool(info == 21)
+		case info >= 25 && info <= 27:
+			if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+				return mismatch("float")
+			}
+			v.SetFloat(cborFloat(info, arg))
+		case info == 22 || info == 23:
+			v.Set(reflect.Zero(v.Type()))
+		default:
+			return errCBORMalformed
+		}
+	}
+	return nil
+}
+
+func findField(fields []cborField, name string) (cborField, bool) {
+	for _, field := range fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, name) {
+			return field, true
+		}
+	}
+	return cborField{}, false
+}
+
+// cborFloat converts the argument of a half, single or double precision float
+// to a float64.
+func cborFloat(info byte, arg uint64) float64 {
+	switch info {
+	case 25:
+		exp, mant := (arg>>10)&0x1f, float64(arg&0x3ff)
+		var f float64
+		switch exp {
+		case 0:
+			f = math.Ldexp(mant, -24)
+		case 0x1f:
+			if mant == 0 {
+				f = math.Inf(1)
+			} else {
+				f = math.NaN()
+			}
+		default:
+			f = math.Ldexp(mant+1024, int(exp)-25)
+		}
+		if arg&0x8000 != 0 {
+			f = -f
+		}
+		return f
+	case 26:
+		return float64(math.Float32frombits(uint32(arg)))
+	default:
+		return math.Float64frombits(arg)
+	}
+}
+
+// skip skips the next data item.
+func (d *cborDecoder) skip(depth int) error {
+	var v interface{}
+	return d.decode(reflect.ValueOf(&v).Elem(), depth)
+}
+
+func (d *cborDecoder) decodeInterface(depth int) (interface{}, error) {
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case cborUint:
+		return arg, nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("codec: CBOR negative integer overflows int64")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes:
+		s, err := d.readString(major, info, arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), s...), nil
+	case cborText:
+		s, err := d.readString(major, info, arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(s), nil
+	case cborArray:
+		n, err := d.readLength(info, arg, 1)
+		if err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		if n >= 0 {
+			values = make([]interface{}, 0, n)
+		}
+		for i := 0; d.more(i, n); i++ {
+			value, err := d.decodeNested(depth)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		if values == nil {
+			values = []interface{}{}
+		}
+		return values, nil
+	case cborMap:
+		n, err := d.readLength(info, arg, 2)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{})
+		stringKeys := true
+		for i := 0; d.more(i, n); i++ {
+			key, err := d.decodeNested(depth)
+			if err != nil {
+				return nil, err
+			}
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, errCBORKeyNotComparable
+			}
+			if _, ok := key.(string); !ok {
+				stringKeys = false
+			}
+			if m[key], err = d.decodeNested(depth); err != nil {
+				return nil, err
+			}
+		}
+		if !stringKeys {
+			return m, nil
+		}
+		sm := make(map[string]interface{}, len(m))
+		for key, value := range m {
+			sm[key.(string)] = value
+		}
+		return sm, nil
+	case cborTag:
+		return d.decodeNested(depth)
+	default: // cborSimple
+		switch {
+		case info == 20 || info == 21:
+			return info == 21, nil
+		case info == 22 || info == 23:
+			return nil, nil
+		case info >= 25 && info <= 27:
+			return cborFloat(info, arg), nil
+		default:
+			return nil, errCBORMalformed
+		}
+	}
+}
+
+func (d *cborDecoder) decodeNested(depth int) (interface{}, error) {
+	if depth+1 > maxCBORDepth {
+		return nil, errCBORTooDeep
+	}
+	return d.decodeInterface(depth + 1)
+}
@@ -0,0 +1,189 @@
+// Package codec encodes and decodes MQTT 5 payloads according to the Content
+// Type property of PUBLISH packets.
+//
+// The DefaultRegistry contains codecs for JSON, text, CBOR and raw bytes.
+// Publishers use Encode to set the payload together with the Content Type and
+// Payload Format Indicator properties, and handlers use Decode to decode the
+// payload with the codec for the Content Type of the packet.
+package codec // import "htdvisser.dev/mqtt/codec"
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime"
+	"strings"
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Codec encodes and decodes payloads of a content type.
+type Codec interface {
+	// ContentType returns the MIME type of the encoded payloads.
+	ContentType() string
+	// PayloadFormat returns the Payload Format Indicator for the encoded
+	// payloads (mqtt.PayloadFormatBytes or mqtt.PayloadFormatUTF8).
+	PayloadFormat() byte
+	// Marshal encodes v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var errUnknownContentType = errors.New("codec: unknown content type")
+
+// Registry is a set of codecs, keyed on their content type. A Registry is safe
+// for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	codecs map[string]Codec
+}
+
+// NewRegistry returns a new Registry with the given codecs.
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{codecs: make(map[string]Codec)}
+	for _, codec := range codecs {
+		r.Register(codec)
+	}
+	return r
+}
+
+// DefaultRegistry is the Registry with the built-in codecs.
+var DefaultRegistry = NewRegistry(JSON, Text, CBOR, Raw)
+
+// mediaType returns the media type of the content type, without parameters
+// and in lower case.
+func mediaType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// Register registers the codec for its content type. It replaces any codec
+// that was registered for the same content type.
+func (r *Registry) Register(codec Codec) {
+	r.mu.Lock()
+	r.codecs[mediaType(codec.ContentType())] = codec
+	r.mu.Unlock()
+}
+
+// Lookup returns the codec for the content type. Parameters of the content
+// type, such as a charset, are ignored.
+func (r *Registry) Lookup(contentType string) (Codec, bool) {
+	r.mu.RLock()
+	codec, ok := r.codecs[mediaType(contentType)]
+	r.mu.RUnlock()
+	return codec, ok
+}
+
+func getProperty(properties mqtt.Properties, identifier mqtt.PropertyIdentifier) (mqtt.Property, bool) {
+	for _, property := range properties {
+		if property.Identifier == identifier {
+			return property, true
+		}
+	}
+	return mqtt.Property{}, false
+}
+
+func setProperty(properties mqtt.Properties, property mqtt.Property) mqtt.Properties {
+	for i := range properties {
+		if properties[i].Identifier == property.Identifier {
+			properties[i] = property
+			return properties
+		}
+	}
+	return append(properties, property)
+}
+
+func removeProperty(properties mqtt.Properties, identifier mqtt.PropertyIdentifier) mqtt.Properties {
+	filtered := properties[:0]
+	for _, property := range properties {
+		if property.Identifier != identifier {
+			filtered = append(filtered, property)
+		}
+	}
+	return filtered
+}
+
+// codecFor returns the codec for the PUBLISH packet. Packets without Content
+// Type property are decoded as text if they have a UTF-8 payload format, and as
+// raw bytes otherwise.
+func (r *Registry) codecFor(publish *mqtt.PublishPacket) (Codec, error) {
+	contentType, ok := getProperty(publish.Properties, mqtt.ContentType)
+	if !ok {
+		if publish.PayloadFormat() == mqtt.PayloadFormatUTF8 {
+			return Text, nil
+		}
+		return Raw, nil
+	}
+	codec, ok := r.Lookup(string(contentType.BytesValue))
+	if !ok {
+		return nil, errUnknownContentType
+	}
+	return codec, nil
+}
+
+// Decode decodes the payload of the PUBLISH packet into the value pointed to by
+// v, using the codec for the Content Type of the packet. Payloads that indicate
+// that they are UTF-8 but are not valid UTF-8 are rejected with an error with
+// the PayloadFormatInvalid reason code.
+//
+// If the packet has a PublishPayloadReader, Decode reads the payload from it,
+// and replaces it with the PublishPayload, so that the packet can still be
+// used after decoding.
+func (r *Registry) Decode(publish *mqtt.PublishPacket, v interface{}) error {
+	codec, err := r.codecFor(publish)
+	if err != nil {
+		return err
+	}
+	payload := publish.PublishPayload
+	if publish.PublishPayloadReader != nil {
+		if payload, err = ioutil.ReadAll(publish.PublishPayloadReader); err != nil {
+			return err
+		}
+		publish.PublishPayload, publish.PublishPayloadReader, publish.PublishPayloadSize = payload, nil, 0
+	}
+	if err = mqtt.ValidatePayloadFormat(publish); err != nil {
+		return err
+	}
+	return codec.Unmarshal(payload, v)
+}
+
+// Encode encodes v with the codec for the content type, and sets it as the
+// payload of the PUBLISH packet, together with the Content Type and Payload
+// Format Indicator properties.
+func (r *Registry) Encode(publish *mqtt.PublishPacket, contentType string, v interface{}) error {
+	codec, ok := r.Lookup(contentType)
+	if !ok {
+		return errUnknownContentType
+	}
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	publish.PublishPayload, publish.PublishPayloadReader, publish.PublishPayloadSize = payload, nil, 0
+	publish.Properties = setProperty(publish.Properties, mqtt.Property{
+		Identifier: mqtt.ContentType,
+		BytesValue: []byte(contentType),
+	})
+	if format := codec.PayloadFormat(); format != mqtt.PayloadFormatBytes {
+		publish.Properties = setProperty(publish.Properties, mqtt.Property{
+			Identifier: mqtt.PayloadFormatIndicator,
+			ByteValue:  format,
+		})
+	} else {
+		publish.Properties = removeProperty(publish.Properties, mqtt.PayloadFormatIndicator)
+	}
+	return nil
+}
+
+// Decode decodes the payload of the PUBLISH packet with the DefaultRegistry.
+func Decode(publish *mqtt.PublishPacket, v interface{}) error {
+	return DefaultRegistry.Decode(publish, v)
+}
+
+// Encode encodes the payload of the PUBLISH packet with the DefaultRegistry.
+func Encode(publish *mqtt.PublishPacket, contentType string, v interface{}) error {
+	return DefaultRegistry.Encode(publish, contentType, v)
+}
@@ -0,0 +1,193 @@
+package codec
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func getPayloadFormat(properties mqtt.Properties) (byte, bool) {
+	property, ok := getProperty(properties, mqtt.PayloadFormatIndicator)
+	return property.ByteValue, ok
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, contentType := range []string{"application/json", "Application/JSON", "application/json; charset=utf-8"} {
+		codec, ok := DefaultRegistry.Lookup(contentType)
+		if assert.True(ok, contentType) {
+			assert.Equal(JSON, codec)
+		}
+	}
+	_, ok := DefaultRegistry.Lookup("application/xml")
+	assert.False(ok)
+
+	r := NewRegistry()
+	_, ok = r.Lookup("text/plain")
+	assert.False(ok)
+	r.Register(Text)
+	_, ok = r.Lookup("text/plain")
+	assert.True(ok)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	type message struct {
+		Name  string `json:"name" cbor:"name"`
+		Count int    `json:"count" cbor:"count"`
+	}
+
+	tests := []struct {
+		contentType string
+		value       interface{}
+		decoded     interface{}
+		format      byte
+	}{
+		{"application/json", message{"foo", 42}, &message{}, mqtt.PayloadFormatUTF8},
+		{"text/plain", "hello", new(string), mqtt.PayloadFormatUTF8},
+		{"application/cbor", message{"foo", 42}, &message{}, mqtt.PayloadFormatBytes},
+		{"application/octet-stream", []byte{0x00, 0xff}, new([]byte), mqtt.PayloadFormatBytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			assert := assert.New(t)
+
+			publish := &mqtt.PublishPacket{Properties: mqtt.Properties{
+				{Identifier: mqtt.PayloadFormatIndicator, ByteValue: mqtt.PayloadFormatUTF8},
+			}}
+			if !assert.NoError(Encode(publish, tt.contentType, tt.value)) {
+				return
+			}
+			contentType, ok := getProperty(publish.Properties, mqtt.ContentType)
+			if assert.True(ok) {
+				assert.Equal(tt.contentType, string(contentType.BytesValue))
+			}
+			format, ok := getPayloadFormat(publish.Properties)
+			assert.Equal(tt.format == mqtt.PayloadFormatUTF8, ok)
+			assert.Equal(tt.format, format)
+
+			if assert.NoError(Decode(publish, tt.decoded)) {
+				switch decoded := tt.decoded.(type) {
+				case *message:
+					assert.Equal(tt.value, *decoded)
+				case *string:
+					assert.Equal(tt.value, *decoded)
+				case *[]byte:
+					assert.Equal(tt.value, *decoded)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeWithoutContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	var decoded interface{}
+	publish := &mqtt.PublishPacket{PublishPayload: []byte("hello")}
+	assert.NoError(Decode(publish, &decoded))
+	assert.Equal([]byte("hello"), decoded)
+
+	publish.Properties = mqtt.Properties{{Identifier: mqtt.PayloadFormatIndicator, ByteValue: mqtt.PayloadFormatUTF8}}
+	assert.NoError(Decode(publish, &decoded))
+	assert.Equal("hello", decoded)
+
+	publish.PublishPayload = []byte{0xff}
+	err := Decode(publish, &decoded)
+	var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+	if assert.True(errors.As(err, &rcErr)) {
+		assert.Equal(mqtt.PayloadFormatInvalid, rcErr.ReasonCode())
+	}
+
+	publish.Properties = mqtt.Properties{{Identifier: mqtt.ContentType, BytesValue: []byte("application/xml")}}
+	assert.Equal(errUnknownContentType, Decode(publish, &decoded))
+	assert.Equal(errUnknownContentType, Encode(publish, "application/xml", "foo"))
+}
+
+func TestCBOR(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		encoded []byte
+	}{
+		{"zero", uint64(0), []byte{0x00}},
+		{"small", uint64(23), []byte{0x17}},
+		{"uint8", uint64(24), []byte{0x18, 0x18}},
+		{"uint16", uint64(1000), []byte{0x19, 0x03, 0xe8}},
+		{"uint32", uint64(1000000), []byte{0x1a, 0x00, 0x0f, 0x42, 0x40}},
+		{"uint64", uint64(math.MaxUint64), []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"negative", int64(-1000), []byte{0x39, 0x03, 0xe7}},
+		{"float", 1.1, []byte{0xfb, 0x3f, 0xf1, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{"false", false, []byte{0xf4}},
+		{"true", true, []byte{0xf5}},
+		{"null", nil, []byte{0xf6}},
+		{"bytes", []byte{0x01, 0x02}, []byte{0x42, 0x01, 0x02}},
+		{"text", "IETF", []byte{0x64, 0x49, 0x45, 0x54, 0x46}},
+		{"array", []interface{}{uint64(1), "a"}, []byte{0x82, 0x01, 0x61, 0x61}},
+		{"map", map[string]interface{}{"b": uint64(2), "a": uint64(1)}, []byte{0xa2, 0x61, 0x61, 0x01, 0x61, 0x62, 0x02}},
+		{"mixed keys", map[interface{}]interface{}{uint64(1): "a"}, []byte{0xa1, 0x01, 0x61, 0x61}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			encoded, err := CBOR.Marshal(tt.value)
+			if assert.NoError(err) {
+				assert.Equal(tt.encoded, encoded)
+			}
+			var decoded interface{}
+			if assert.NoError(CBOR.Unmarshal(tt.encoded, &decoded)) {
+				assert.Equal(tt.value, decoded)
+			}
+		})
+	}
+}
+
+func TestCBORDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	type inner struct {
+		Values []int `cbor:"values"`
+	}
+	type outer struct {
+		Name    string            `cbor:"name"`
+		Inner   *inner            `cbor:"inner"`
+		Labels  map[string]string `cbor:"labels,omitempty"`
+		Skipped string            `cbor:"-"`
+	}
+	value := outer{Name: "foo", Inner: &inner{Values: []int{1, -2, 3}}, Skipped: "bar"}
+	encoded, err := CBOR.Marshal(value)
+	assert.NoError(err)
+	var decoded outer
+	if assert.NoError(CBOR.Unmarshal(encoded, &decoded)) {
+		value.Skipped = ""
+		assert.Equal(value, decoded)
+	}
+
+	// Indefinite length array and text string, half precision float, tag.
+	var indefinite interface{}
+	assert.NoError(CBOR.Unmarshal([]byte{0x9f, 0x7f, 0x61, 0x61, 0x61, 0x62, 0xff, 0xf9, 0x3c, 0x00, 0xc1, 0x01, 0xff}, &indefinite))
+	assert.Equal([]interface{}{"ab", 1.0, uint64(1)}, indefinite)
+
+	var small int8
+	assert.Error(CBOR.Unmarshal([]byte{0x19, 0x03, 0xe8}, &small))
+	var s string
+	assert.Error(CBOR.Unmarshal([]byte{0x01}, &s))
+	assert.Equal(errCBORTrailingData, CBOR.Unmarshal([]byte{0x01, 0x02}, &indefinite))
+	assert.Equal(errCBORUnexpectedEnd, CBOR.Unmarshal([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, &indefinite))
+
+	deep := make([]byte, maxCBORDepth+2)
+	for i := range deep {
+		deep[i] = 0x81
+	}
+	assert.Equal(errCBORTooDeep, CBOR.Unmarshal(deep, &indefinite))
+
+	// Map with an array as key.
+	assert.Equal(errCBORKeyNotComparable, CBOR.Unmarshal([]byte{0xa1, 0x81, 0x01, 0x01}, &indefinite))
+	var keyed map[interface{}]interface{}
+	assert.Equal(errCBORKeyNotComparable, CBOR.Unmarshal([]byte{0xa1, 0x81, 0x01, 0x01}, &keyed))
+}
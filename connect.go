@@ -23,7 +23,15 @@ func (p ConnectPacket) fixedHeader(protocol byte) (h FixedHeader) {
 }
 
 func (p ConnectPacket) size(protocol byte) uint32 {
-	size := 2 + len(p.ConnectHeader.ProtocolName) + 1 + 1 + 2
+	protocolName := p.ConnectHeader.ProtocolName
+	if len(protocolName) == 0 {
+		if protocol == 3 {
+			protocolName = protocolMQIsdp
+		} else {
+			protocolName = protocolMQTT
+		}
+	}
+	size := 2 + len(protocolName) + 1 + 1 + 2
 	size += 2 + len(p.ConnectPayload.ClientIdentifier)
 	if p.ConnectHeader.Will() {
 		size += 2 + len(p.ConnectPayload.WillTopic)
@@ -99,16 +107,8 @@ type ConnectHeader struct {
 // ConnectHeaderFlags are the flags in the header of the Connect packet.
 type ConnectHeaderFlags byte
 
-var errInvalidConnectHeaderFlags = NewReasonCodeError(MalformedPacket, "mqtt: invalid connect header flags")
-
 func (r *PacketReader) validateConnectHeaderFlags(f ConnectHeaderFlags) error {
-	if f&0x18 == 0x18 {
-		return errInvalidQoS
-	}
-	if f&0x01 == 0x01 {
-		return errInvalidConnectHeaderFlags
-	}
-	return nil
+	return validateConnectFlags(f, r.protocol)
 }
 
 // Username returns the Username bit from the connect header flags.
@@ -225,6 +225,7 @@ func (r *PacketReader) readConnectHeader() {
 		r.err = errUnsupportedProtocolVersion
 		return
 	}
+	r.protocol = packet.ConnectHeader.ProtocolVersion
 	var f byte
 	f, r.err = r.readByte()
 	if r.err != nil {
@@ -300,7 +301,7 @@ func (r *PacketReader) readConnectPayload() {
 	}
 	if packet.ConnectHeader.Will() {
 		if r.protocol >= 5 {
-			packet.ConnectPayload.WillProperties = r.readProperties()
+			packet.ConnectPayload.WillProperties = r.appendProperties(packet.ConnectPayload.WillProperties[:0])
 			if r.err != nil {
 				return
 			}
@@ -313,6 +314,10 @@ func (r *PacketReader) readConnectPayload() {
 		if r.err != nil {
 			return
 		}
+		r.err = r.checkTopic(packet.ConnectPayload.WillTopic, errTopicNameTooLong)
+		if r.err != nil {
+			return
+		}
 		packet.ConnectPayload.WillMessage, r.err = r.readBytes()
 		if r.err != nil {
 			return
@@ -1,6 +1,7 @@
 package mqtt
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -97,3 +98,21 @@ func TestConnectPacket(t *testing.T) {
 	assert.Equal([]byte("will-topic"), topic)
 	assert.Equal([]byte("will-message"), message)
 }
+
+func TestConnectPacketDefaultProtocolName(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, protocol := range []byte{3, 4, 5} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetProtocol(protocol)
+		err := w.WritePacket(&ConnectPacket{ConnectPayload: ConnectPayload{ClientIdentifier: []byte("client")}})
+		assert.NoError(err)
+
+		r := NewReader(&buf)
+		packet, err := r.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal(protocol, packet.(*ConnectPacket).ProtocolVersion)
+		}
+	}
+}
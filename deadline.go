@@ -0,0 +1,127 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// aLongTimeAgo is a deadline in the past, used to interrupt blocked reads and writes.
+var aLongTimeAgo = time.Unix(1, 0)
+
+var (
+	errReadAborted  = errors.New("mqtt: reader unusable after aborted read")
+	errWriteAborted = errors.New("mqtt: writer unusable after aborted write")
+)
+
+// withDeadline calls f with the context's deadline set using setDeadline, and
+// interrupts f by setting a deadline in the past when the context is done.
+// When f returns, the deadline is cleared. Timeout errors caused by the context
+// are replaced by the context error.
+func withDeadline(ctx context.Context, setDeadline func(time.Time) error, f func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		setDeadline(deadline)
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			setDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+	err := f()
+	close(stop)
+	<-stopped
+	setDeadline(time.Time{})
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// ReadPacketContext reads the next packet, like ReadPacket, but returns the
+// context error when the context is done before a packet is read.
+//
+// Cancellation requires that the io.Reader passed to NewReader has a
+// SetReadDeadline method, such as a net.Conn; otherwise the context is only
+// checked before reading. The read deadline is cleared when ReadPacketContext
+// returns.
+//
+// If the read is aborted before the first byte of a packet is read, the reader
+// can be used again. If it is aborted in the middle of a packet, the rest of
+// the stream can no longer be interpreted, and all subsequent reads fail.
+func (r *PacketReader) ReadPacketContext(ctx context.Context) (Packet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.readDeadliner == nil || ctx.Done() == nil {
+		return r.readPacket()
+	}
+	var packet Packet
+	nReadBefore := r.nReadTotal
+	err := withDeadline(ctx, r.readDeadliner.SetReadDeadline, func() (err error) {
+		packet, err = r.readPacket()
+		return err
+	})
+	if err != nil {
+		if isContextError(err) && r.nReadTotal != nReadBefore {
+			r.abortErr = errReadAborted
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+// WritePacketContext writes the given packet, like WritePacket, but returns the
+// context error when the context is done before the packet is written.
+//
+// Cancellation requires that the io.Writer passed to NewWriter has a
+// SetWriteDeadline method, such as a net.Conn; otherwise the context is only
+// checked before writing. The write deadline is cleared when WritePacketContext
+// returns.
+//
+// If the write is aborted after part of the packet was written, all subsequent
+// writes fail.
+func (w *PacketWriter) WritePacketContext(ctx context.Context, packet Packet) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if w.writeDeadliner == nil || ctx.Done() == nil {
+		return w.writePacket(packet)
+	}
+	nWrittenBefore := w.nWrittenTotal
+	err := withDeadline(ctx, w.writeDeadliner.SetWriteDeadline, func() error {
+		return w.writePacket(packet)
+	})
+	if isContextError(err) && w.nWrittenTotal != nWrittenBefore {
+		w.abortErr = errWriteAborted
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPacketContext(t *testing.T) {
+	assert := assert.New(t)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	r := NewReader(local)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.ReadPacketContext(ctx)
+	assert.Equal(context.Canceled, err)
+
+	// Aborted between packets.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.ReadPacketContext(ctx)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// The reader can still be used.
+	go remote.Write([]byte{0xc0, 0x00})
+	packet, err := r.ReadPacketContext(context.Background())
+	assert.NoError(err)
+	assert.Equal(&PingreqPacket{}, packet)
+
+	// Aborted in the middle of a packet.
+	go remote.Write([]byte{0xc0})
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = r.ReadPacketContext(ctx)
+	assert.Equal(context.Canceled, err)
+
+	// The reader can no longer be used.
+	_, err = r.ReadPacket()
+	assert.Equal(errReadAborted, err)
+}
+
+func TestWritePacketContext(t *testing.T) {
+	assert := assert.New(t)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	w := NewWriter(local)
+
+	// Aborted before anything is written.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := w.WritePacketContext(ctx, &PingreqPacket{})
+	assert.Equal(context.DeadlineExceeded, err)
+
+	// The writer can still be used.
+	go remote.Read(make([]byte, 2))
+	err = w.WritePacketContext(context.Background(), &PingreqPacket{})
+	assert.NoError(err)
+
+	// Aborted after the fixed header is written.
+	go remote.Read(make([]byte, 2))
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err = w.WritePacketContext(ctx, &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo")}})
+	assert.Equal(context.Canceled, err)
+
+	// The writer can no longer be used.
+	err = w.WritePacket(&PingreqPacket{})
+	assert.Equal(errWriteAborted, err)
+}
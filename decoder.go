@@ -0,0 +1,178 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Decoder decodes MQTT packets from byte chunks that are pushed to it, instead
+// of reading them from an io.Reader. It is intended for servers that use an
+// event loop instead of a goroutine per connection.
+//
+// A Decoder parses packets with a PacketReader, so it supports the same
+// ReaderOptions, except WithStreamingPayloads. Packets returned by a Decoder do
+// not reference the chunks that are passed to Feed. If the Decoder has a
+// MemoryBudget (see WithMemoryBudget), the bytes of incomplete packets that it
+// buffers are reserved from it until they are decoded or the Decoder is closed.
+type Decoder struct {
+	reader *PacketReader
+	src    bytes.Reader
+	buf    []byte
+	err    error
+
+	reserved uint64 // The capacity of buf that is reserved from the MemoryBudget.
+}
+
+// NewDecoder returns a new Decoder.
+func NewDecoder(opts ...ReaderOption) *Decoder {
+	d := &Decoder{}
+	d.reader = NewReader(&d.src, opts...)
+	d.reader.streamPayloads = false
+	return d
+}
+
+// SetProtocol sets the MQTT protocol version.
+func (d *Decoder) SetProtocol(protocol byte) {
+	d.reader.SetProtocol(protocol)
+}
+
+// Buffered returns the number of bytes of incomplete packets that the Decoder
+// has buffered.
+func (d *Decoder) Buffered() int {
+	return len(d.buf)
+}
+
+// peekFixedHeader returns the length of the fixed header and the remaining
+// length of the packet at the start of b. It returns ok=false if b does not
+// contain the complete fixed header.
+func peekFixedHeader(b []byte) (headerLength, remainingLength uint32, ok bool, err error) {
+	for i := 1; i < len(b); i++ {
+		remainingLength |= uint32(b[i]&0x7f) << (7 * uint(i-1))
+		if b[i]&0x80 == 0 {
+			return uint32(i + 1), remainingLength, true, nil
+		}
+		if i == 4 {
+			return 0, 0, false, errInvalidRemainingLength
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// peek is like peekFixedHeader, but it also checks the packet type and flags,
+// the role and the packet length as soon as they are available, so that the
+// Decoder does not buffer the bytes of packets that it would reject.
+func (d *Decoder) peek(b []byte) (headerLength, remainingLength uint32, ok bool, err error) {
+	if len(b) == 0 {
+		return 0, 0, false, nil
+	}
+	header := FixedHeader{typeAndFlags: b[0]}
+	if err = d.reader.validateFixedHeader(header); err != nil {
+		return 0, 0, false, err
+	}
+	if err = d.reader.checkRole(header.PacketType()); err != nil {
+		return 0, 0, false, err
+	}
+	headerLength, remainingLength, ok, err = peekFixedHeader(b)
+	if err == nil && ok {
+		err = d.reader.checkPacketLength(headerLength, remainingLength)
+	}
+	return headerLength, remainingLength, ok, err
+}
+
+// buffer appends b to the buffer of incomplete packets. If the buffer must
+// grow, the extra capacity is reserved from the MemoryBudget.
+func (d *Decoder) buffer(b []byte) error {
+	if len(d.buf)+len(b) > cap(d.buf) {
+		grown := make([]byte, len(d.buf), 2*cap(d.buf)+len(b))
+		if budget := d.reader.budget; budget != nil {
+			extra := uint64(cap(grown)) - d.reserved
+			if !budget.reserve(extra) {
+				return errMemoryBudgetExceeded
+			}
+			d.reserved += extra
+		}
+		copy(grown, d.buf)
+		d.buf = grown
+	}
+	d.buf = append(d.buf, b...)
+	return nil
+}
+
+// releaseBuffer releases the buffer of incomplete packets, and its reservation
+// from the MemoryBudget.
+func (d *Decoder) releaseBuffer() {
+	if d.reserved > 0 {
+		d.reader.budget.release(d.reserved)
+		d.reserved = 0
+	}
+	d.buf = nil
+}
+
+var errDecoderClosed = errors.New("mqtt: decoder closed")
+
+// Close releases the buffer of incomplete packets, and returns its bytes to
+// the MemoryBudget. Subsequent calls to Feed return an error.
+func (d *Decoder) Close() error {
+	d.releaseBuffer()
+	if d.err == nil {
+		d.err = errDecoderClosed
+	}
+	return nil
+}
+
+// Feed feeds a chunk of bytes to the Decoder, and returns the packets that are
+// complete. Incomplete packets are buffered until the rest of their bytes are
+// fed. Feed does not retain b.
+//
+// If Feed returns an error, the rest of the stream can not be interpreted, and
+// all subsequent calls to Feed return the same error. Packets that were decoded
+// before the error are still returned.
+func (d *Decoder) Feed(b []byte) (packets []Packet, err error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	data := b
+	if len(d.buf) > 0 {
+		if err = d.buffer(b); err != nil {
+			d.fail(err)
+			return nil, err
+		}
+		data = d.buf
+	}
+	for {
+		headerLength, remainingLength, ok, err := d.peek(data)
+		if err != nil {
+			d.fail(err)
+			return packets, err
+		}
+		if !ok || uint32(len(data)) < headerLength+remainingLength {
+			break
+		}
+		packetLength := headerLength + remainingLength
+		d.src.Reset(data[:packetLength])
+		packet, err := d.reader.ReadPacket()
+		if err != nil {
+			d.fail(err)
+			return packets, err
+		}
+		packets = append(packets, packet)
+		data = data[packetLength:]
+	}
+	if len(d.buf) > 0 {
+		d.buf = d.buf[:copy(d.buf, data)]
+		if len(d.buf) == 0 && d.reserved > 0 {
+			d.releaseBuffer() // Idle connections do not hold on to the budget.
+		}
+	} else if err = d.buffer(data); err != nil {
+		d.fail(err)
+		return packets, err
+	}
+	return packets, nil
+}
+
+// fail makes the error sticky, and releases the buffer, since the rest of the
+// stream can not be decoded.
+func (d *Decoder) fail(err error) {
+	d.err = err
+	d.releaseBuffer()
+}
@@ -0,0 +1,130 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecoder(t *testing.T) {
+	packets := []Packet{
+		&ConnectPacket{
+			ConnectHeader:  ConnectHeader{ProtocolName: []byte("MQTT"), ProtocolVersion: 5},
+			ConnectPayload: ConnectPayload{ClientIdentifier: []byte("client")},
+		},
+		&PublishPacket{
+			PublishHeader:  PublishHeader{TopicName: []byte("foo")},
+			Properties:     Properties{{Identifier: ContentType, BytesValue: []byte("text/plain")}},
+			PublishPayload: bytes.Repeat([]byte("bar"), 100),
+		},
+		&PingreqPacket{},
+		&SubscribePacket{
+			SubscribeHeader:  SubscribeHeader{PacketIdentifier: 1},
+			SubscribePayload: []Subscription{{TopicFilter: TopicFilter("foo/#"), QoS: QoS1}},
+		},
+		&DisconnectPacket{},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetProtocol(5)
+	for _, packet := range packets {
+		if !assert.NoError(t, w.WritePacket(packet)) {
+			return
+		}
+	}
+	stream := buf.Bytes()
+
+	for _, chunkSize := range []int{1, 2, 3, 7, 64, len(stream)} {
+		assert := assert.New(t)
+		d := NewDecoder()
+		var decoded []Packet
+		for i := 0; i < len(stream); i += chunkSize {
+			end := i + chunkSize
+			if end > len(stream) {
+				end = len(stream)
+			}
+			chunk := append([]byte(nil), stream[i:end]...)
+			packets, err := d.Feed(chunk)
+			if !assert.NoError(err, "chunk size %d", chunkSize) {
+				break
+			}
+			for j := range chunk {
+				chunk[j] = 0 // Packets must not reference fed chunks.
+			}
+			decoded = append(decoded, packets...)
+		}
+		assert.Equal(0, d.Buffered())
+		if assert.Len(decoded, len(packets), "chunk size %d", chunkSize) {
+			for i, packet := range packets {
+				assert.Equal(packet, decoded[i], "chunk size %d packet %d", chunkSize, i)
+			}
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewDecoder(WithMaxPacketLength(16))
+	packets, err := d.Feed([]byte{0xc0, 0x00, 0x30, 0x80, 0x01})
+	assert.Len(packets, 1)
+	assertReasonCode(t, err, PacketTooLarge)
+	_, err2 := d.Feed([]byte{0xc0, 0x00})
+	assert.Equal(err, err2)
+
+	d = NewDecoder()
+	_, err = d.Feed([]byte{0x30, 0x80, 0x80, 0x80, 0x80})
+	assert.Equal(errInvalidRemainingLength, err)
+
+	d = NewDecoder()
+	_, err = d.Feed([]byte{0x00, 0x00})
+	assert.Error(err)
+
+	// The packet type is checked before the rest of the packet is buffered.
+	d = NewDecoder()
+	_, err = d.Feed([]byte{0x00})
+	assert.Equal(errReservedPacketType, err)
+	assert.Equal(0, d.Buffered())
+
+	d = NewDecoder(WithRole(RoleServer))
+	_, err = d.Feed([]byte{0x20})
+	assertReasonCode(t, err, ProtocolError)
+	assert.Equal(0, d.Buffered())
+}
+
+func TestDecoderMemoryBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	budget := NewMemoryBudget(64)
+	d := NewDecoder(WithMemoryBudget(budget))
+
+	// The fixed header of a PUBLISH with remaining length 100, and its first
+	// bytes.
+	_, err := d.Feed([]byte{0x30, 100, 0x00, 0x03, 'f', 'o', 'o'})
+	assert.NoError(err)
+	assert.Equal(7, d.Buffered())
+	assert.True(budget.Used() >= 7)
+
+	_, err = d.Feed(bytes.Repeat([]byte{'x'}, 64))
+	assertReasonCode(t, err, QuotaExceeded)
+	assert.Equal(uint64(0), budget.Used())
+
+	// The buffer is released when it is empty, and when the Decoder is closed.
+	d = NewDecoder(WithMemoryBudget(budget))
+	_, err = d.Feed([]byte{0xc0})
+	assert.NoError(err)
+	assert.NotEqual(uint64(0), budget.Used())
+	packets, err := d.Feed([]byte{0x00})
+	assert.NoError(err)
+	assert.Len(packets, 1)
+	assert.Equal(uint64(0), budget.Used())
+
+	_, err = d.Feed([]byte{0xc0})
+	assert.NoError(err)
+	assert.NoError(d.Close())
+	assert.Equal(uint64(0), budget.Used())
+	_, err = d.Feed([]byte{0x00})
+	assert.Equal(errDecoderClosed, err)
+}
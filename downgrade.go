@@ -0,0 +1,120 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithStrict returns a WriterOption that makes the Writer return an error
+// instead of silently dropping data that can not be represented in the
+// protocol version of the Writer, such as properties, reason codes and
+// subscription options in MQTT versions before 5, or AUTH packets. See
+// Downgrade for the data that is checked.
+func WithStrict() WriterOption {
+	return writerOptionFunc(func(w *PacketWriter) {
+		w.strict = true
+	})
+}
+
+func describeProperties(properties Properties) string {
+	names := make([]string, len(properties))
+	for i, property := range properties {
+		names[i] = property.Identifier.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// Downgrade returns the data of the packet that would be lost when it is
+// written in the given protocol version. It returns nil if the packet can be
+// written without losing data.
+func Downgrade(p Packet, protocol byte) (lost []string) {
+	if protocol >= 5 {
+		return nil
+	}
+	reasonCode := func(reasonCode ReasonCode) {
+		if reasonCode != Success {
+			lost = append(lost, fmt.Sprintf("reason code %s", reasonCode))
+		}
+	}
+	properties := func(what string, properties Properties) {
+		if len(properties) > 0 {
+			lost = append(lost, fmt.Sprintf("%s (%s)", what, describeProperties(properties)))
+		}
+	}
+	switch p := p.(type) {
+	case *EncodedPublishPacket:
+		return Downgrade(p.PublishPacket(), protocol)
+	case *ConnectPacket:
+		properties("properties", p.Properties)
+		if p.ConnectHeader.Will() {
+			properties("will properties", p.WillProperties)
+		}
+	case *ConnackPacket:
+		properties("properties", p.Properties)
+		if p.ReasonCode > 0x05 {
+			// Only the return codes 0x01 to 0x05 of MQTT 3.1.1 can be represented.
+			reasonCode(p.ReasonCode)
+		}
+		if protocol < 4 && p.SessionPresent() {
+			lost = append(lost, "session present flag")
+		}
+	case *PublishPacket:
+		properties("properties", p.Properties)
+	case *PubackPacket:
+		properties("properties", p.Properties)
+		reasonCode(p.ReasonCode)
+	case *PubrecPacket:
+		properties("properties", p.Properties)
+		reasonCode(p.ReasonCode)
+	case *PubrelPacket:
+		properties("properties", p.Properties)
+		reasonCode(p.ReasonCode)
+	case *PubcompPacket:
+		properties("properties", p.Properties)
+		reasonCode(p.ReasonCode)
+	case *SubscribePacket:
+		properties("properties", p.Properties)
+		for _, subscription := range p.SubscribePayload {
+			if subscription.SubscriptionOptions != 0 {
+				lost = append(lost, fmt.Sprintf("subscription options of %q", subscription.TopicFilter))
+			}
+		}
+	case *SubackPacket:
+		properties("properties", p.Properties)
+		for i, returnCode := range p.SubackPayload {
+			// MQTT 3.1.1 only has the Failure (0x80) return code.
+			if returnCode.IsError() && (protocol < 4 || returnCode != UnspecifiedError) {
+				lost = append(lost, fmt.Sprintf("reason code %s of subscription %d", returnCode, i))
+			}
+		}
+	case *UnsubscribePacket:
+		properties("properties", p.Properties)
+	case *UnsubackPacket:
+		properties("properties", p.Properties)
+		for i, returnCode := range p.UnsubackPayload {
+			if returnCode != Success {
+				lost = append(lost, fmt.Sprintf("reason code %s of topic filter %d", returnCode, i))
+			}
+		}
+	case *DisconnectPacket:
+		properties("properties", p.Properties)
+		reasonCode(p.ReasonCode)
+	case *AuthPacket:
+		lost = append(lost, "AUTH packet")
+	}
+	return lost
+}
+
+func (w *PacketWriter) checkStrict(packet Packet) error {
+	if !w.strict {
+		return nil
+	}
+	lost := Downgrade(packet, w.protocol)
+	if len(lost) == 0 {
+		return nil
+	}
+	return NewReasonCodeError(ImplementationSpecificError, fmt.Sprintf(
+		"mqtt: %s packet can not be written in protocol version %d without losing %s",
+		packet.PacketType(), w.protocol, strings.Join(lost, ", "),
+	))
+}
@@ -0,0 +1,79 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDowngrade(t *testing.T) {
+	userProperty := Property{Identifier: UserProperty, StringPairValue: StringPair{Key: []byte("k"), Value: []byte("v")}}
+
+	tests := []struct {
+		name     string
+		packet   Packet
+		protocol byte
+		lost     []string
+	}{
+		{"v5", &PubackPacket{PubackHeader: PubackHeader{ReasonCode: QuotaExceeded}}, 5, nil},
+		{"plain publish", &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo")}}, 4, nil},
+		{
+			"connect properties",
+			&ConnectPacket{
+				ConnectHeader:  ConnectHeader{ConnectHeaderFlags: 0x04},
+				Properties:     Properties{{Identifier: SessionExpiryInterval, UintValue: 60}, userProperty},
+				ConnectPayload: ConnectPayload{WillProperties: Properties{{Identifier: WillDelayInterval, UintValue: 10}}},
+			},
+			4,
+			[]string{"properties (Session Expiry Interval, User Property)", "will properties (Will Delay Interval)"},
+		},
+		{"connack return code", &ConnackPacket{ConnackHeader: ConnackHeader{ReasonCode: 0x05}}, 4, nil},
+		{"connack reason code", &ConnackPacket{ConnackHeader: ConnackHeader{ReasonCode: QuotaExceeded}}, 4, []string{"reason code Quota exceeded"}},
+		{"connack session present", &ConnackPacket{ConnackHeader: ConnackHeader{ConnackHeaderFlags: 0x01}}, 3, []string{"session present flag"}},
+		{"puback reason code", &PubackPacket{PubackHeader: PubackHeader{ReasonCode: NoMatchingSubscribers}}, 4, []string{"reason code No matching subscribers"}},
+		{
+			"subscription options",
+			&SubscribePacket{SubscribePayload: []Subscription{{TopicFilter: []byte("foo"), QoS: QoS1, SubscriptionOptions: 0x04}, {TopicFilter: []byte("bar"), QoS: QoS1}}},
+			4,
+			[]string{`subscription options of "foo"`},
+		},
+		{"suback failure", &SubackPacket{SubackPayload: []ReasonCode{GrantedQoS1, UnspecifiedError}}, 4, nil},
+		{"suback reason code", &SubackPacket{SubackPayload: []ReasonCode{GrantedQoS1, NotAuthorized}}, 4, []string{"reason code Not authorized of subscription 1"}},
+		{"unsuback reason code", &UnsubackPacket{UnsubackPayload: []ReasonCode{Success, NoSubscriptionExisted}}, 4, []string{"reason code No subscription existed of topic filter 1"}},
+		{"disconnect reason code", &DisconnectPacket{DisconnectHeader: DisconnectHeader{ReasonCode: ServerShuttingDown}}, 4, []string{"reason code Server shutting down"}},
+		{"auth", &AuthPacket{}, 4, []string{"AUTH packet"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			assert.Equal(tt.lost, Downgrade(tt.packet, tt.protocol))
+		})
+	}
+}
+
+func TestStrictWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, WithStrict())
+
+	err := w.WritePacket(&DisconnectPacket{DisconnectHeader: DisconnectHeader{ReasonCode: ServerShuttingDown}})
+	if assert.Error(err) {
+		assert.Equal("mqtt: DISCONNECT packet can not be written in protocol version 4 without losing reason code Server shutting down", err.Error())
+	}
+	assert.Equal(0, buf.Len())
+
+	assert.NoError(w.WritePacket(&DisconnectPacket{}))
+	assert.Equal([]byte{0xe0, 0x00}, buf.Bytes())
+
+	w.SetProtocol(5)
+	assert.NoError(w.WritePacket(&AuthPacket{}))
+
+	// A writer that is not strict silently drops the data.
+	buf.Reset()
+	w = NewWriter(&buf)
+	assert.NoError(w.WritePacket(&DisconnectPacket{DisconnectHeader: DisconnectHeader{ReasonCode: ServerShuttingDown}}))
+	assert.Equal([]byte{0xe0, 0x00}, buf.Bytes())
+}
@@ -0,0 +1,247 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+)
+
+// EncodedPublish is a PUBLISH packet that is encoded once per protocol version,
+// so that it can be written to many connections without encoding it again for
+// each of them. Only the QoS, packet identifier and DUP flag can differ per
+// connection (see Packet).
+//
+// Topic aliases are specific to a connection, so the PublishPacket must have a
+// topic name and must not have a Topic Alias property. The PublishPacket must
+// not be modified after it is passed to NewEncodedPublish. An EncodedPublish is
+// safe for concurrent use.
+type EncodedPublish struct {
+	publish *PublishPacket
+
+	mu        sync.Mutex
+	encodings map[byte]*publishEncoding
+}
+
+// publishEncoding is the encoding of a PUBLISH packet, without the fixed header
+// and the packet identifier.
+type publishEncoding struct {
+	topic []byte // The topic name, including its length.
+	body  []byte // The properties and the payload.
+	err   error
+}
+
+// NewEncodedPublish returns a new EncodedPublish for the PUBLISH packet.
+func NewEncodedPublish(publish *PublishPacket) *EncodedPublish {
+	return &EncodedPublish{
+		publish:   publish,
+		encodings: make(map[byte]*publishEncoding),
+	}
+}
+
+var (
+	errEncodedPublishStream     = errors.New("mqtt: an encoded publish can not have a publish payload reader")
+	errNotRawPublish            = NewReasonCodeError(MalformedPacket, "mqtt: raw bytes are not a complete PUBLISH packet")
+	errEncodedPublishTopic      = errors.New("mqtt: an encoded publish must have a topic name")
+	errEncodedPublishTopicAlias = errors.New("mqtt: an encoded publish must not have a topic alias")
+)
+
+// parsePublishEncoding splits the raw bytes of a PUBLISH packet.
+func parsePublishEncoding(raw []byte) (*publishEncoding, error) {
+	if len(raw) == 0 || PacketType(raw[0]>>4) != PUBLISH {
+		return nil, errNotRawPublish
+	}
+	headerLength, remainingLength, ok, err := peekFixedHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || uint32(len(raw)) != headerLength+remainingLength || remainingLength < 2 {
+		return nil, errNotRawPublish
+	}
+	rest := raw[headerLength:]
+	topicLength := 2 + int(binary.BigEndian.Uint16(rest))
+	bodyStart := topicLength
+	if PublishFlags(raw[0]).QoS() > 0 {
+		bodyStart += 2 // Skip the packet identifier.
+	}
+	if bodyStart > len(rest) {
+		return nil, errNotRawPublish
+	}
+	return &publishEncoding{topic: rest[:topicLength], body: rest[bodyStart:]}, nil
+}
+
+// SetRawBytes sets the encoding for the protocol version to the raw bytes of
+// the PUBLISH packet, as returned by the RawBytes method of a PacketReader. This
+// makes it possible to forward a PUBLISH packet to connections of the same
+// protocol version without encoding it at all. The raw bytes must not be
+// modified after they are passed to SetRawBytes.
+//
+// Topic aliases are specific to a connection, so the raw bytes must have a
+// topic name and must not have a Topic Alias property. A PUBLISH packet that
+// was received with a topic alias must be forwarded from its encoding instead,
+// after its topic name is restored and its Topic Alias property is removed.
+func (e *EncodedPublish) SetRawBytes(protocol byte, raw []byte) error {
+	enc, err := parsePublishEncoding(raw)
+	if err != nil {
+		return err
+	}
+	if len(enc.topic) <= 2 {
+		return errEncodedPublishTopic
+	}
+	if protocol >= 5 {
+		r := NewReader(bytes.NewReader(enc.body))
+		r.SetProtocol(protocol)
+		r.header.remainingLength = uint32(len(enc.body))
+		properties := r.readProperties()
+		if r.err != nil {
+			return r.err
+		}
+		if hasTopicAlias(properties) {
+			return errEncodedPublishTopicAlias
+		}
+	}
+	e.mu.Lock()
+	e.encodings[protocol] = enc
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *EncodedPublish) encoding(protocol byte) *publishEncoding {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if enc, ok := e.encodings[protocol]; ok {
+		return enc
+	}
+	enc := &publishEncoding{}
+	switch {
+	case e.publish.PublishPayloadReader != nil:
+		enc.err = errEncodedPublishStream
+	case len(e.publish.TopicName) == 0:
+		enc.err = errEncodedPublishTopic
+	case protocol >= 5 && hasTopicAlias(e.publish.Properties):
+		enc.err = errEncodedPublishTopicAlias
+	default:
+		publish := *e.publish
+		publish.PublishFlags = 0
+		publish.SetRetain(e.publish.Retain())
+		publish.PacketIdentifier = 0
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetProtocol(protocol)
+		if enc.err = w.WritePacket(&publish); enc.err == nil {
+			if parsed, err := parsePublishEncoding(buf.Bytes()); err != nil {
+				enc.err = err
+			} else {
+				enc = parsed
+			}
+		}
+	}
+	e.encodings[protocol] = enc
+	return enc
+}
+
+func hasTopicAlias(properties Properties) bool {
+	for _, property := range properties {
+		if property.Identifier == TopicAlias {
+			return true
+		}
+	}
+	return false
+}
+
+// Packet returns a PUBLISH packet with the given QoS, packet identifier and DUP
+// flag, that is written from the encoding of the EncodedPublish.
+func (e *EncodedPublish) Packet(qos QoS, packetIdentifier uint16, dup bool) *EncodedPublishPacket {
+	return &EncodedPublishPacket{encoded: e, QoS: qos, PacketIdentifier: packetIdentifier, Dup: dup}
+}
+
+// EncodedPublishPacket is a PUBLISH packet that is written from the encoding of
+// an EncodedPublish.
+type EncodedPublishPacket struct {
+	encoded *EncodedPublish
+
+	QoS              QoS
+	PacketIdentifier uint16
+	Dup              bool
+}
+
+func (*EncodedPublishPacket) _isPacket() {}
+
+// PacketType returns the packet type of the Publish packet.
+func (*EncodedPublishPacket) PacketType() PacketType { return PUBLISH }
+
+func (p *EncodedPublishPacket) publishFlags() PublishFlags {
+	var f PublishFlags
+	f.SetRetain(p.encoded.publish.Retain())
+	f.SetQoS(p.QoS)
+	f.SetDup(p.Dup)
+	return f
+}
+
+func (p *EncodedPublishPacket) fixedHeader(protocol byte) (h FixedHeader) {
+	h.SetPacketType(PUBLISH)
+	h.typeAndFlags |= byte(p.publishFlags())
+	enc := p.encoded.encoding(protocol)
+	h.remainingLength = uint32(len(enc.topic) + len(enc.body))
+	if p.QoS > 0 {
+		h.remainingLength += 2
+	}
+	return
+}
+
+// PublishPacket returns the PUBLISH packet with the QoS, packet identifier and
+// DUP flag of p. It shares its topic name, properties and payload with the
+// PUBLISH packet of the EncodedPublish.
+func (p *EncodedPublishPacket) PublishPacket() *PublishPacket {
+	publish := *p.encoded.publish
+	publish.PublishFlags = p.publishFlags()
+	publish.PacketIdentifier = p.PacketIdentifier
+	return &publish
+}
+
+// writeEncodedPublish writes the encoding of the packet, with only the fixed
+// header and packet identifier written for this packet.
+func (w *PacketWriter) writeEncodedPublish(packet *EncodedPublishPacket) error {
+	if packet.QoS > QoS2 {
+		return errPublishQoS
+	}
+	if packet.QoS > QoS0 && packet.PacketIdentifier == 0 {
+		return errZeroPacketIdentifier(w.protocol, PUBLISH)
+	}
+	if err := w.checkStrict(packet); err != nil {
+		return err
+	}
+	enc := packet.encoded.encoding(w.protocol)
+	if enc.err != nil {
+		return enc.err
+	}
+	header := packet.fixedHeader(w.protocol)
+	if header.remainingLength > maxRemainingLength {
+		return errInvalidRemainingLength
+	}
+	var buf [7]byte
+	buf[0] = header.typeAndFlags
+	n := 1 + binary.PutUvarint(buf[1:5], uint64(header.remainingLength))
+	id := buf[5:5]
+	if packet.QoS > 0 {
+		binary.BigEndian.PutUint16(buf[5:], packet.PacketIdentifier)
+		id = buf[5:]
+	}
+	if _, ok := w.w.(net.Conn); ok {
+		// Connections write net.Buffers with a single system call where possible.
+		buffers := net.Buffers{buf[:n], enc.topic, id, enc.body}
+		written, err := buffers.WriteTo(w.w)
+		w.nWrittenTotal += uint64(written)
+		return err
+	}
+	for _, b := range [][]byte{buf[:n], enc.topic, id, enc.body} {
+		if len(b) == 0 {
+			continue
+		}
+		if err := w.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package mqtt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	packets := []Packet{
+		&PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo")}, PublishPayload: bytes.Repeat([]byte("bar"), 100)},
+		&PingreqPacket{},
+		&SubscribePacket{
+			SubscribeHeader:  SubscribeHeader{PacketIdentifier: 1},
+			SubscribePayload: []Subscription{{TopicFilter: TopicFilter("foo/#"), QoS: QoS1}},
+		},
+	}
+	var encoded [][]byte
+	var buf bytes.Buffer
+	for _, packet := range packets {
+		var packetBuf bytes.Buffer
+		assert.NoError(NewWriter(&packetBuf).WritePacket(packet))
+		encoded = append(encoded, packetBuf.Bytes())
+		buf.Write(packetBuf.Bytes())
+	}
+	stream := buf.Bytes()
+
+	r := NewReader(bytes.NewReader(stream), WithRawBytes())
+	var raws [][]byte
+	for range packets {
+		_, err := r.ReadPacket()
+		assert.NoError(err)
+		raws = append(raws, r.RawBytes())
+	}
+	assert.Equal(encoded, raws)
+
+	_, err := r.ReadPacket()
+	assert.Error(err)
+	assert.Nil(r.RawBytes())
+
+	r = NewReader(bytes.NewReader(stream))
+	_, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Nil(r.RawBytes())
+}
+
+func TestEncodedPublish(t *testing.T) {
+	publish := &PublishPacket{
+		PublishHeader:  PublishHeader{TopicName: []byte("foo/bar")},
+		Properties:     Properties{{Identifier: ContentType, BytesValue: []byte("text/plain")}},
+		PublishPayload: []byte("hello"),
+	}
+	publish.SetRetain(true)
+	encoded := NewEncodedPublish(publish)
+
+	for _, protocol := range []byte{3, 4, 5} {
+		for _, packet := range []*EncodedPublishPacket{
+			encoded.Packet(QoS0, 0, false),
+			encoded.Packet(QoS1, 42, false),
+			encoded.Packet(QoS2, 0xABCD, true),
+		} {
+			assert := assert.New(t)
+
+			var expected, actual bytes.Buffer
+			w := NewWriter(&expected)
+			w.SetProtocol(protocol)
+			assert.NoError(w.WritePacket(packet.PublishPacket()))
+			w = NewWriter(&actual)
+			w.SetProtocol(protocol)
+			assert.NoError(w.WritePacket(packet))
+			assert.Equal(expected.Bytes(), actual.Bytes(), "protocol %d QoS %d", protocol, packet.QoS)
+
+			assert.True(PacketsEqual(packet, packet.PublishPacket()))
+			assert.True(PacketsEqual(packet.PublishPacket(), ClonePacket(packet)))
+		}
+	}
+
+	// Forward the raw bytes of a QoS 1 PUBLISH as QoS 0.
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetProtocol(5)
+	qos1 := publish.Clone()
+	qos1.SetQoS(QoS1)
+	qos1.PacketIdentifier = 1
+	assert.NoError(t, w.WritePacket(qos1))
+	r := NewReader(&buf, WithRawBytes())
+	r.SetProtocol(5)
+	read, err := r.ReadPacket()
+	if !assert.NoError(t, err) {
+		return
+	}
+	forwarded := NewEncodedPublish(read.(*PublishPacket))
+	assert.NoError(t, forwarded.SetRawBytes(5, r.RawBytes()))
+	var expected, actual bytes.Buffer
+	w = NewWriter(&expected)
+	w.SetProtocol(5)
+	assert.NoError(t, w.WritePacket(encoded.Packet(QoS0, 0, false)))
+	w = NewWriter(&actual)
+	w.SetProtocol(5)
+	assert.NoError(t, w.WritePacket(forwarded.Packet(QoS0, 0, false)))
+	assert.Equal(t, expected.Bytes(), actual.Bytes())
+
+	assert.Equal(t, errNotRawPublish, forwarded.SetRawBytes(5, []byte{0xc0, 0x00}))
+	assert.Equal(t, errNotRawPublish, forwarded.SetRawBytes(5, []byte{0x30, 0x05, 0x00, 0x10}))
+
+	// Raw bytes with a topic alias can not be forwarded to other connections.
+	aliased := publish.Clone()
+	aliased.TopicName = nil
+	aliased.Properties = append(aliased.Properties, Property{Identifier: TopicAlias, UintValue: 1})
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetProtocol(5)
+	assert.NoError(t, w.WritePacket(aliased))
+	assert.Equal(t, errEncodedPublishTopic, forwarded.SetRawBytes(5, buf.Bytes()))
+	aliased.TopicName = []byte("foo/bar")
+	buf.Reset()
+	assert.NoError(t, w.WritePacket(aliased))
+	assert.Equal(t, errEncodedPublishTopicAlias, forwarded.SetRawBytes(5, buf.Bytes()))
+
+	// Neither can an encoding with a topic alias.
+	w = NewWriter(ioutil.Discard)
+	w.SetProtocol(5)
+	assert.Equal(t, errEncodedPublishTopicAlias, w.WritePacket(NewEncodedPublish(aliased).Packet(QoS0, 0, false)))
+	aliased.TopicName = nil
+	assert.Equal(t, errEncodedPublishTopic, w.WritePacket(NewEncodedPublish(aliased).Packet(QoS0, 0, false)))
+
+	// The QoS and packet identifier are validated when writing.
+	w = NewWriter(ioutil.Discard)
+	w.SetProtocol(5)
+	assert.Equal(t, errPublishQoS, w.WritePacket(encoded.Packet(QoS(3), 1, false)))
+	assert.Error(t, w.WritePacket(encoded.Packet(QoS1, 0, false)))
+
+	w = NewWriter(ioutil.Discard)
+	w.SetProtocol(4)
+	assert.Error(t, NewWriter(ioutil.Discard, WithStrict()).WritePacket(encoded.Packet(QoS0, 0, false)))
+	assert.NoError(t, w.WritePacket(encoded.Packet(QoS0, 0, false)))
+}
+
+func BenchmarkFanOut(b *testing.B) {
+	publish := &PublishPacket{
+		PublishHeader:  PublishHeader{TopicName: []byte("foo/bar")},
+		Properties:     Properties{{Identifier: ContentType, BytesValue: []byte("application/json")}},
+		PublishPayload: bytes.Repeat([]byte("x"), 1024),
+	}
+	w := NewWriter(ioutil.Discard)
+	w.SetProtocol(5)
+
+	b.Run("PublishPacket", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p := *publish
+			p.SetQoS(QoS1)
+			p.PacketIdentifier = uint16(i) | 1
+			if err := w.WritePacket(&p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("EncodedPublish", func(b *testing.B) {
+		b.ReportAllocs()
+		encoded := NewEncodedPublish(publish)
+		for i := 0; i < b.N; i++ {
+			if err := w.WritePacket(encoded.Packet(QoS1, uint16(i)|1, false)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
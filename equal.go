@@ -0,0 +1,284 @@
+package mqtt
+
+import "bytes"
+
+// Equal returns whether the property is equal to the other property.
+func (p Property) Equal(other Property) bool {
+	return p.Identifier == other.Identifier &&
+		p.UintValue == other.UintValue &&
+		bytes.Equal(p.BytesValue, other.BytesValue) &&
+		bytes.Equal(p.StringPairValue.Key, other.StringPairValue.Key) &&
+		bytes.Equal(p.StringPairValue.Value, other.StringPairValue.Value) &&
+		p.ByteValue == other.ByteValue
+}
+
+// Equal returns whether the properties are equal to the other properties.
+// The order of properties is ignored, except for the order of User Properties,
+// which is significant.
+func (properties Properties) Equal(other Properties) bool {
+	if len(properties) != len(other) {
+		return false
+	}
+	matched := make([]bool, len(other))
+next:
+	for _, property := range properties {
+		for i, otherProperty := range other {
+			if !matched[i] && property.Equal(otherProperty) {
+				matched[i] = true
+				continue next
+			}
+		}
+		return false
+	}
+	var i, j int
+	for {
+		for i < len(properties) && properties[i].Identifier != UserProperty {
+			i++
+		}
+		for j < len(other) && other[j].Identifier != UserProperty {
+			j++
+		}
+		if i == len(properties) || j == len(other) {
+			return i == len(properties) && j == len(other)
+		}
+		if !properties[i].Equal(other[j]) {
+			return false
+		}
+		i++
+		j++
+	}
+}
+
+// Equal returns whether the ConnectPacket is semantically equal to the other ConnectPacket.
+func (p *ConnectPacket) Equal(other *ConnectPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	willProperties, willTopic, willMessage := p.Will()
+	otherWillProperties, otherWillTopic, otherWillMessage := other.Will()
+	return bytes.Equal(p.ConnectHeader.ProtocolName, other.ConnectHeader.ProtocolName) &&
+		p.ConnectHeader.ProtocolVersion == other.ConnectHeader.ProtocolVersion &&
+		p.ConnectHeader.ConnectHeaderFlags == other.ConnectHeader.ConnectHeaderFlags &&
+		p.ConnectHeader.KeepAlive == other.ConnectHeader.KeepAlive &&
+		bytes.Equal(p.ConnectPayload.ClientIdentifier, other.ConnectPayload.ClientIdentifier) &&
+		willProperties.Equal(otherWillProperties) &&
+		bytes.Equal(willTopic, otherWillTopic) &&
+		bytes.Equal(willMessage, otherWillMessage) &&
+		bytes.Equal(p.Username(), other.Username()) &&
+		bytes.Equal(p.Password(), other.Password()) &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the ConnackPacket is semantically equal to the other ConnackPacket.
+func (p *ConnackPacket) Equal(other *ConnackPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.ConnackHeader == other.ConnackHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the PublishPacket is semantically equal to the other PublishPacket.
+// The Packet Identifier is ignored for QoS 0 packets. For packets with a
+// PublishPayloadReader, only the PublishPayloadSize is compared.
+func (p *PublishPacket) Equal(other *PublishPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	if p.PublishPayloadReader != nil || other.PublishPayloadReader != nil {
+		if p.PublishPayloadReader == nil || other.PublishPayloadReader == nil || p.PublishPayloadSize != other.PublishPayloadSize {
+			return false
+		}
+	} else if !bytes.Equal(p.PublishPayload, other.PublishPayload) {
+		return false
+	}
+	if p.PublishFlags.QoS() > QoS0 && p.PublishHeader.PacketIdentifier != other.PublishHeader.PacketIdentifier {
+		return false
+	}
+	return p.PublishFlags == other.PublishFlags &&
+		bytes.Equal(p.PublishHeader.TopicName, other.PublishHeader.TopicName) &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the PubackPacket is semantically equal to the other PubackPacket.
+func (p *PubackPacket) Equal(other *PubackPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.PubackHeader == other.PubackHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the PubrecPacket is semantically equal to the other PubrecPacket.
+func (p *PubrecPacket) Equal(other *PubrecPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.PubrecHeader == other.PubrecHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the PubrelPacket is semantically equal to the other PubrelPacket.
+func (p *PubrelPacket) Equal(other *PubrelPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.PubrelHeader == other.PubrelHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the PubcompPacket is semantically equal to the other PubcompPacket.
+func (p *PubcompPacket) Equal(other *PubcompPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.PubcompHeader == other.PubcompHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the SubscribePacket is semantically equal to the other SubscribePacket.
+func (p *SubscribePacket) Equal(other *SubscribePacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	if p.SubscribeHeader != other.SubscribeHeader ||
+		!p.Properties.Equal(other.Properties) ||
+		len(p.SubscribePayload) != len(other.SubscribePayload) {
+		return false
+	}
+	for i, subscription := range p.SubscribePayload {
+		if !bytes.Equal(subscription.TopicFilter, other.SubscribePayload[i].TopicFilter) ||
+			subscription.QoS != other.SubscribePayload[i].QoS {
+			return false
+		}
+	}
+	return true
+}
+
+func reasonCodesEqual(a, b []ReasonCode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal returns whether the SubackPacket is semantically equal to the other SubackPacket.
+func (p *SubackPacket) Equal(other *SubackPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.SubackHeader == other.SubackHeader &&
+		p.Properties.Equal(other.Properties) &&
+		reasonCodesEqual(p.SubackPayload, other.SubackPayload)
+}
+
+// Equal returns whether the UnsubscribePacket is semantically equal to the other UnsubscribePacket.
+func (p *UnsubscribePacket) Equal(other *UnsubscribePacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	if p.UnsubscribeHeader != other.UnsubscribeHeader ||
+		!p.Properties.Equal(other.Properties) ||
+		len(p.UnsubscribePayload) != len(other.UnsubscribePayload) {
+		return false
+	}
+	for i, topicFilter := range p.UnsubscribePayload {
+		if !bytes.Equal(topicFilter, other.UnsubscribePayload[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal returns whether the UnsubackPacket is semantically equal to the other UnsubackPacket.
+func (p *UnsubackPacket) Equal(other *UnsubackPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.UnsubackHeader == other.UnsubackHeader &&
+		p.Properties.Equal(other.Properties) &&
+		reasonCodesEqual(p.UnsubackPayload, other.UnsubackPayload)
+}
+
+// Equal returns whether the PingreqPacket is equal to the other PingreqPacket.
+func (p *PingreqPacket) Equal(other *PingreqPacket) bool {
+	return (p == nil) == (other == nil)
+}
+
+// Equal returns whether the PingrespPacket is equal to the other PingrespPacket.
+func (p *PingrespPacket) Equal(other *PingrespPacket) bool {
+	return (p == nil) == (other == nil)
+}
+
+// Equal returns whether the DisconnectPacket is semantically equal to the other DisconnectPacket.
+func (p *DisconnectPacket) Equal(other *DisconnectPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.DisconnectHeader == other.DisconnectHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// Equal returns whether the AuthPacket is semantically equal to the other AuthPacket.
+func (p *AuthPacket) Equal(other *AuthPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.AuthHeader == other.AuthHeader &&
+		p.Properties.Equal(other.Properties)
+}
+
+// PacketsEqual returns whether the packets are semantically equal.
+func PacketsEqual(a, b Packet) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if a.PacketType() != b.PacketType() {
+		return false
+	}
+	if encoded, ok := a.(*EncodedPublishPacket); ok {
+		a = encoded.PublishPacket()
+	}
+	if encoded, ok := b.(*EncodedPublishPacket); ok {
+		b = encoded.PublishPacket()
+	}
+	switch a := a.(type) {
+	case *ConnectPacket:
+		return a.Equal(b.(*ConnectPacket))
+	case *ConnackPacket:
+		return a.Equal(b.(*ConnackPacket))
+	case *PublishPacket:
+		return a.Equal(b.(*PublishPacket))
+	case *PubackPacket:
+		return a.Equal(b.(*PubackPacket))
+	case *PubrecPacket:
+		return a.Equal(b.(*PubrecPacket))
+	case *PubrelPacket:
+		return a.Equal(b.(*PubrelPacket))
+	case *PubcompPacket:
+		return a.Equal(b.(*PubcompPacket))
+	case *SubscribePacket:
+		return a.Equal(b.(*SubscribePacket))
+	case *SubackPacket:
+		return a.Equal(b.(*SubackPacket))
+	case *UnsubscribePacket:
+		return a.Equal(b.(*UnsubscribePacket))
+	case *UnsubackPacket:
+		return a.Equal(b.(*UnsubackPacket))
+	case *PingreqPacket:
+		return a.Equal(b.(*PingreqPacket))
+	case *PingrespPacket:
+		return a.Equal(b.(*PingrespPacket))
+	case *DisconnectPacket:
+		return a.Equal(b.(*DisconnectPacket))
+	case *AuthPacket:
+		return a.Equal(b.(*AuthPacket))
+	default:
+		panic(errUnknownPacket)
+	}
+}
@@ -15,7 +15,7 @@ func Example_client() {
 	}
 	defer conn.Close()
 
-	reader, writer := mqtt.NewReader(conn), mqtt.NewWriter(conn)
+	reader, writer := mqtt.NewReader(conn, mqtt.WithRole(mqtt.RoleClient)), mqtt.NewWriter(conn)
 
 	connect := new(mqtt.ConnectPacket)
 	connect.SetCleanSession(true)
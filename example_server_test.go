@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"htdvisser.dev/mqtt"
+	"htdvisser.dev/mqtt/auth"
 )
 
 func ListenAndAccept(address string, handle func(net.Conn) error) error {
@@ -28,11 +29,34 @@ func ListenAndAccept(address string, handle func(net.Conn) error) error {
 	}
 }
 
-func checkAuth(username, password []byte) error {
-	return nil
+func loadAuth() (auth.Authenticator, auth.Authorizer) {
+	passwords, err := auth.LoadPasswordFile("passwd")
+	if err != nil {
+		log.Fatal(err)
+	}
+	acl, err := auth.LoadACLFile("acl")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return passwords, acl
+}
+
+func lookupSCRAMCredentials(username string) (auth.SCRAMCredentials, error) {
+	return auth.SCRAMCredentials{}, errors.New("unknown user")
+}
+
+func usesEnhancedAuth(connect *mqtt.ConnectPacket) bool {
+	for _, property := range connect.Properties {
+		if property.Identifier == mqtt.AuthenticationMethod {
+			return true
+		}
+	}
+	return false
 }
 
 func Example_server() {
+	authenticator, authorizer := loadAuth()
+
 	ListenAndAccept("localhost:1883", func(conn net.Conn) error {
 		ctx, cancel := context.WithCancel(context.Background())
 		var wg sync.WaitGroup
@@ -41,7 +65,7 @@ func Example_server() {
 			wg.Wait()
 		}()
 
-		reader, writer := mqtt.NewReader(conn), mqtt.NewWriter(conn)
+		reader, writer := mqtt.NewReader(conn, mqtt.WithRole(mqtt.RoleServer)), mqtt.NewWriter(conn)
 
 		timeout := 10 * time.Second
 
@@ -61,8 +85,33 @@ func Example_server() {
 
 		connack := connect.Connack()
 
-		if err := checkAuth(connect.Username(), connect.Password()); err != nil {
-			connack.ReasonCode = mqtt.BadUsernameOrPassword
+		client := auth.NewClientInfo(conn, connect)
+		authServer := auth.NewServer(&auth.SCRAMServer{Lookup: lookupSCRAMCredentials})
+
+		if usesEnhancedAuth(connect) {
+			reply, err := authServer.Connect(connect)
+			for err == nil && reply.PacketType() == mqtt.AUTH {
+				if err = writer.WritePacket(reply); err != nil {
+					return err
+				}
+				conn.SetReadDeadline(time.Now().Add(timeout)) // Read deadline for AUTH.
+				if packet, err = reader.ReadPacket(); err != nil {
+					return err
+				}
+				authPacket, ok := packet.(*mqtt.AuthPacket)
+				if !ok {
+					return errors.New("expected auth packet")
+				}
+				reply, err = authServer.Auth(authPacket)
+			}
+			if err != nil {
+				writer.WritePacket(reply)
+				return err
+			}
+			connack.Properties = append(connack.Properties, reply.(*mqtt.ConnackPacket).Properties...)
+			client.Username = authServer.Identity()
+		} else if err := authenticator.Authenticate(ctx, client); err != nil {
+			connack.ReasonCode = auth.ErrorReasonCode(err)
 			return writer.WritePacket(connack)
 		}
 
@@ -114,13 +163,20 @@ func Example_server() {
 			switch packet.PacketType() {
 			case mqtt.PUBLISH:
 				publish := packet.(*mqtt.PublishPacket)
+				authErr := authorizer.AuthorizePublish(ctx, client, publish.TopicName)
 				switch publish.QoS() {
 				case mqtt.QoS1:
 					puback := publish.Puback()
+					if authErr != nil {
+						puback.ReasonCode = auth.ErrorReasonCode(authErr)
+					}
 					// TODO: Handle QoS 1 publish
 					controlPackets <- puback
 				case mqtt.QoS2:
 					pubrec := publish.Pubrec()
+					if authErr != nil {
+						pubrec.ReasonCode = auth.ErrorReasonCode(authErr)
+					}
 					// TODO: Handle QoS 2 publish
 					controlPackets <- pubrec
 				}
@@ -145,7 +201,14 @@ func Example_server() {
 			case mqtt.SUBSCRIBE:
 				subscribe := packet.(*mqtt.SubscribePacket)
 				suback := subscribe.Suback()
-				// TODO: Handle subscribe, set reason codes in suback
+				for i, subscription := range subscribe.SubscribePayload {
+					if err := authorizer.AuthorizeSubscribe(ctx, client, subscription.TopicFilter); err != nil {
+						suback.SubackPayload[i] = auth.ErrorReasonCode(err)
+						continue
+					}
+					suback.SubackPayload[i] = mqtt.ReasonCode(subscription.QoS)
+					// TODO: Handle subscribe
+				}
 				controlPackets <- suback
 			case mqtt.UNSUBSCRIBE:
 				unsubscribe := packet.(*mqtt.UnsubscribePacket)
@@ -162,11 +225,12 @@ func Example_server() {
 				// TODO: Handle disconnect
 				return nil
 			case mqtt.AUTH:
-				auth := packet.(*mqtt.AuthPacket)
-				if auth.ReasonCode != mqtt.ContinueAuthentication {
-					return errors.New("received auth packet with invalid reason code")
+				authPacket := packet.(*mqtt.AuthPacket)
+				reply, authErr := authServer.Auth(authPacket)
+				controlPackets <- reply
+				if authErr != nil {
+					return authErr
 				}
-				// TODO: Handle auth
 			}
 			if err != nil {
 				return err
@@ -3,6 +3,7 @@ package mqtt
 import (
 	"encoding/binary"
 	"fmt"
+	"time"
 )
 
 // PacketType is the MQTT packet type.
@@ -116,6 +117,9 @@ func (r *PacketReader) readFixedHeader() {
 	if r.err != nil {
 		return
 	}
+	if r.observer != nil {
+		r.readStart = time.Now()
+	}
 	r.header.remainingLength = 5 // Enough to read the "remaining length" field.
 	var remainingLength uint64
 	remainingLength, r.err = r.readUvarint()
@@ -131,10 +135,16 @@ func (r *PacketReader) readFixedHeader() {
 	if r.err != nil {
 		return
 	}
-	if r.maxPacketLength > 0 && r.nRead+r.header.remainingLength > r.maxPacketLength {
-		r.err = errPacketTooLarge
-		return
+	r.err = r.checkPacketLength(r.nRead, r.header.remainingLength)
+}
+
+// checkPacketLength checks the length of a packet with the given fixed header
+// length and remaining length against the maximum packet length.
+func (r *PacketReader) checkPacketLength(headerLength, remainingLength uint32) error {
+	if r.maxPacketLength > 0 && headerLength+remainingLength > r.maxPacketLength {
+		return errPacketTooLarge
 	}
+	return nil
 }
 
 func (w *PacketWriter) writeFixedHeader() (err error) {
@@ -145,6 +155,7 @@ func (w *PacketWriter) writeFixedHeader() (err error) {
 	var buf [5]byte
 	buf[0] = header.typeAndFlags
 	n := binary.PutUvarint(buf[1:], uint64(header.remainingLength))
-	_, err = w.w.Write(buf[:n+1])
+	n, err = w.w.Write(buf[:n+1])
+	w.nWrittenTotal += uint64(n)
 	return err
 }
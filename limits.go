@@ -0,0 +1,164 @@
+package mqtt
+
+import (
+	"bytes"
+	"sync"
+)
+
+// limits are limits on the contents of the packets that a PacketReader reads.
+// Zero values mean that there is no limit.
+type limits struct {
+	maxProperties         int
+	maxUserProperties     int
+	maxUserPropertiesSize int
+	maxSubscriptions      int
+	maxTopicLength        int
+	maxTopicLevels        int
+}
+
+// WithMaxProperties returns a ReaderOption that limits the number of properties
+// in a property list.
+func WithMaxProperties(properties int) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.limits.maxProperties = properties
+	})
+}
+
+// WithMaxUserProperties returns a ReaderOption that limits the number of user
+// properties in a property list, and the total size in bytes of their keys and
+// values.
+func WithMaxUserProperties(userProperties, bytes int) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.limits.maxUserProperties = userProperties
+		r.limits.maxUserPropertiesSize = bytes
+	})
+}
+
+// WithMaxSubscriptions returns a ReaderOption that limits the number of topic
+// filters in a SUBSCRIBE or UNSUBSCRIBE packet.
+func WithMaxSubscriptions(subscriptions int) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.limits.maxSubscriptions = subscriptions
+	})
+}
+
+// WithMaxTopicLength returns a ReaderOption that limits the length in bytes and
+// the number of levels of topic names and topic filters.
+func WithMaxTopicLength(bytes, levels int) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.limits.maxTopicLength = bytes
+		r.limits.maxTopicLevels = levels
+	})
+}
+
+var (
+	errTooManyProperties      = NewReasonCodeError(ImplementationSpecificError, "mqtt: too many properties")
+	errTooManyUserProperties  = NewReasonCodeError(ImplementationSpecificError, "mqtt: too many user properties")
+	errUserPropertiesTooLarge = NewReasonCodeError(ImplementationSpecificError, "mqtt: user properties too large")
+	errTooManySubscriptions   = NewReasonCodeError(ImplementationSpecificError, "mqtt: too many topic filters")
+	errTopicNameTooLong       = NewReasonCodeError(TopicNameInvalid, "mqtt: topic name too long")
+	errTopicFilterTooLong     = NewReasonCodeError(TopicFilterInvalid, "mqtt: topic filter too long")
+)
+
+// propertyCounter counts the properties in a property list for checking them
+// against the limits.
+type propertyCounter struct {
+	properties         int
+	userProperties     int
+	userPropertiesSize int
+}
+
+// checkProperty counts the property and checks that the property list does not
+// exceed the limits.
+func (r *PacketReader) checkProperty(c *propertyCounter, property Property) error {
+	c.properties++
+	if r.limits.maxProperties > 0 && c.properties > r.limits.maxProperties {
+		return errTooManyProperties
+	}
+	if property.Identifier != UserProperty {
+		return nil
+	}
+	c.userProperties++
+	c.userPropertiesSize += len(property.StringPairValue.Key) + len(property.StringPairValue.Value)
+	if r.limits.maxUserProperties > 0 && c.userProperties > r.limits.maxUserProperties {
+		return errTooManyUserProperties
+	}
+	if r.limits.maxUserPropertiesSize > 0 && c.userPropertiesSize > r.limits.maxUserPropertiesSize {
+		return errUserPropertiesTooLarge
+	}
+	return nil
+}
+
+// checkSubscriptions checks that adding a topic filter to the n topic filters
+// of a SUBSCRIBE or UNSUBSCRIBE packet does not exceed the limits.
+func (r *PacketReader) checkSubscriptions(n int) error {
+	if r.limits.maxSubscriptions > 0 && n >= r.limits.maxSubscriptions {
+		return errTooManySubscriptions
+	}
+	return nil
+}
+
+// checkTopic checks the length and the number of levels of a topic name or
+// topic filter.
+func (r *PacketReader) checkTopic(topic []byte, err error) error {
+	if r.limits.maxTopicLength > 0 && len(topic) > r.limits.maxTopicLength {
+		return err
+	}
+	if r.limits.maxTopicLevels > 0 && bytes.Count(topic, []byte{topicLevelSeparator})+1 > r.limits.maxTopicLevels {
+		return err
+	}
+	return nil
+}
+
+// MemoryBudget limits the total number of bytes that PacketReaders buffer
+// while reading packets. A single MemoryBudget can be shared by many
+// PacketReaders, so that a process can cap the memory that is used for reading
+// packets from all its connections. A MemoryBudget is safe for concurrent use.
+type MemoryBudget struct {
+	mu    sync.Mutex
+	limit uint64
+	used  uint64
+}
+
+// NewMemoryBudget returns a new MemoryBudget of the given number of bytes.
+func NewMemoryBudget(bytes uint64) *MemoryBudget {
+	return &MemoryBudget{limit: bytes}
+}
+
+// Used returns the number of bytes that are currently reserved.
+func (b *MemoryBudget) Used() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+func (b *MemoryBudget) reserve(bytes uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+bytes > b.limit {
+		return false
+	}
+	b.used += bytes
+	return true
+}
+
+func (b *MemoryBudget) release(bytes uint64) {
+	b.mu.Lock()
+	b.used -= bytes
+	b.mu.Unlock()
+}
+
+var errMemoryBudgetExceeded = NewReasonCodeError(QuotaExceeded, "mqtt: memory budget exceeded")
+
+// WithMemoryBudget returns a ReaderOption that makes the Reader reserve bytes
+// from the MemoryBudget for the buffers that it allocates while reading a
+// packet. Large payloads are reserved in chunks as they arrive, so that a peer
+// can not reserve much more than it actually sends, and streamed payloads (see
+// WithStreamingPayloads) are not reserved at all. The reservation is released
+// when the packet has been read. If the MemoryBudget does not have enough bytes
+// left, the Reader returns an error with the QuotaExceeded reason code.
+func WithMemoryBudget(budget *MemoryBudget) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.budget = budget
+	})
+}
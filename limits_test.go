@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderLimits(t *testing.T) {
+	userProperties := func(n int, value string) Properties {
+		properties := make(Properties, n)
+		for i := range properties {
+			properties[i] = Property{Identifier: UserProperty, StringPairValue: StringPair{Key: []byte("key"), Value: []byte(value)}}
+		}
+		return properties
+	}
+	subscribe := func(filters ...string) *SubscribePacket {
+		p := &SubscribePacket{SubscribeHeader: SubscribeHeader{PacketIdentifier: 1}}
+		for _, filter := range filters {
+			p.SubscribePayload = append(p.SubscribePayload, Subscription{TopicFilter: TopicFilter(filter)})
+		}
+		return p
+	}
+	unsubscribe := func(filters ...string) *UnsubscribePacket {
+		p := &UnsubscribePacket{UnsubscribeHeader: UnsubscribeHeader{PacketIdentifier: 1}}
+		for _, filter := range filters {
+			p.UnsubscribePayload = append(p.UnsubscribePayload, TopicFilter(filter))
+		}
+		return p
+	}
+	publish := func(topic string, properties Properties) *PublishPacket {
+		return &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte(topic)}, Properties: properties}
+	}
+
+	tests := []struct {
+		name       string
+		packet     Packet
+		option     ReaderOption
+		reasonCode ReasonCode // zero if the packet is within the limits.
+	}{
+		{"properties", publish("foo", userProperties(3, "v")), WithMaxProperties(3), 0},
+		{"too many properties", publish("foo", userProperties(4, "v")), WithMaxProperties(3), ImplementationSpecificError},
+		{"user properties", publish("foo", userProperties(3, "v")), WithMaxUserProperties(3, 12), 0},
+		{"too many user properties", publish("foo", userProperties(4, "v")), WithMaxUserProperties(3, 0), ImplementationSpecificError},
+		{"user properties too large", publish("foo", userProperties(2, "value")), WithMaxUserProperties(0, 12), ImplementationSpecificError},
+		{"subscriptions", subscribe("a", "b"), WithMaxSubscriptions(2), 0},
+		{"too many subscriptions", subscribe("a", "b", "c"), WithMaxSubscriptions(2), ImplementationSpecificError},
+		{"too many unsubscriptions", unsubscribe("a", "b", "c"), WithMaxSubscriptions(2), ImplementationSpecificError},
+		{"topic name", publish("a/b/c", nil), WithMaxTopicLength(5, 3), 0},
+		{"topic name too long", publish("a/b/cd", nil), WithMaxTopicLength(5, 0), TopicNameInvalid},
+		{"topic name too deep", publish("a/b/c/d", nil), WithMaxTopicLength(0, 3), TopicNameInvalid},
+		{"topic filter too long", subscribe("a/b/cd"), WithMaxTopicLength(5, 0), TopicFilterInvalid},
+		{"topic filter too deep", unsubscribe("a/+/#/"), WithMaxTopicLength(0, 3), TopicFilterInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.SetProtocol(5)
+			assert.NoError(w.WritePacket(tt.packet))
+
+			r := NewReader(&buf, tt.option, WithoutValidation())
+			r.SetProtocol(5)
+			_, err := r.ReadPacket()
+			if tt.reasonCode == 0 {
+				assert.NoError(err)
+				return
+			}
+			assertReasonCode(t, err, tt.reasonCode)
+		})
+	}
+}
+
+func TestReadRemaining(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := make([]byte, 3*readRemainingChunkSize+5)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	assert.NoError(w.WritePacket(&PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo")}, PublishPayload: payload}))
+	packet, err := NewReader(&buf).ReadPacket()
+	if assert.NoError(err) {
+		assert.Equal(payload, packet.(*PublishPacket).PublishPayload)
+	}
+
+	// A PUBLISH that claims to be 256 MB but ends early.
+	truncated := []byte{0x30, 0xff, 0xff, 0xff, 0x7f, 0x00, 0x03, 'f', 'o', 'o', 'b', 'a', 'r'}
+	_, err = NewReader(bytes.NewReader(truncated)).ReadPacket()
+	assert.Equal(io.ErrUnexpectedEOF, err)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	assert := assert.New(t)
+
+	budget := NewMemoryBudget(16)
+
+	pr, pw := io.Pipe()
+	blocked := NewReader(pr, WithMemoryBudget(budget))
+	done := make(chan error)
+	go func() {
+		_, err := blocked.ReadPacket()
+		done <- err
+	}()
+	// A PUBLISH with remaining length 10 and topic "foo", of which the payload
+	// did not arrive yet. Only the buffers for the topic and the payload are
+	// reserved, not the remaining length.
+	_, err := pw.Write([]byte{0x30, 0x0a, 0x00, 0x03, 'f', 'o', 'o'})
+	assert.NoError(err)
+
+	var buf bytes.Buffer
+	assert.NoError(NewWriter(&buf).WritePacket(&PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo")}, PublishPayload: []byte("barbazqux")}))
+	publish := buf.Bytes()
+	r := NewReader(bytes.NewReader(publish), WithMemoryBudget(budget))
+
+	for budget.Used() < 8 { // Wait for the blocked reader to reserve its buffers.
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(uint64(8), budget.Used())
+	_, err = r.ReadPacket()
+	assertReasonCode(t, err, QuotaExceeded)
+	assert.Equal(uint64(8), budget.Used())
+
+	_, err = pw.Write([]byte{'b', 'a', 'r', 'b', 'a'})
+	assert.NoError(err)
+	assert.NoError(<-done)
+	assert.Equal(uint64(0), budget.Used())
+
+	r = NewReader(bytes.NewReader(publish), WithMemoryBudget(budget))
+	_, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(uint64(0), budget.Used())
+
+	// Streamed payloads are not reserved.
+	budget = NewMemoryBudget(4)
+	r = NewReader(bytes.NewReader(publish), WithMemoryBudget(budget), WithStreamingPayloads(4))
+	_, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(uint64(0), budget.Used())
+}
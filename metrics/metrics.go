@@ -0,0 +1,294 @@
+// Package metrics collects metrics about the packets that PacketReaders read
+// and PacketWriters write.
+//
+// A Collector is an mqtt.Observer that aggregates the number of packets, bytes,
+// durations and errors per direction and packet type, and the number of reason
+// codes per direction, packet type and reason code. The totals can be exposed
+// with expvar, since a Collector is an expvar.Var, or in the Prometheus text
+// exposition format, since a Collector is an http.Handler.
+package metrics // import "htdvisser.dev/mqtt/metrics"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"htdvisser.dev/mqtt"
+)
+
+type packetKey struct {
+	direction  mqtt.Direction
+	packetType mqtt.PacketType
+}
+
+type packetCounters struct {
+	// The counters are the first fields, so that they are 64-bit aligned.
+	packets  uint64
+	bytes    uint64
+	duration uint64 // nanoseconds
+	errors   uint64
+}
+
+type reasonCodeKey struct {
+	direction  mqtt.Direction
+	packetType mqtt.PacketType
+	reasonCode mqtt.ReasonCode
+}
+
+// Collector collects metrics about packets. Collectors are safe for concurrent
+// use, and one Collector is typically shared by the PacketReaders and
+// PacketWriters of all connections.
+type Collector struct {
+	// Namespace is the prefix of the metric names. The default is "mqtt".
+	Namespace string
+
+	mu          sync.RWMutex
+	packets     map[packetKey]*packetCounters
+	reasonCodes map[reasonCodeKey]*uint64
+}
+
+// NewCollector returns a new Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		packets:     make(map[packetKey]*packetCounters),
+		reasonCodes: make(map[reasonCodeKey]*uint64),
+	}
+}
+
+func (c *Collector) packetCounters(key packetKey) *packetCounters {
+	c.mu.RLock()
+	counters, ok := c.packets[key]
+	c.mu.RUnlock()
+	if ok {
+		return counters
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counters, ok = c.packets[key]; !ok {
+		counters = &packetCounters{}
+		c.packets[key] = counters
+	}
+	return counters
+}
+
+func (c *Collector) reasonCodeCounter(key reasonCodeKey) *uint64 {
+	c.mu.RLock()
+	counter, ok := c.reasonCodes[key]
+	c.mu.RUnlock()
+	if ok {
+		return counter
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok = c.reasonCodes[key]; !ok {
+		counter = new(uint64)
+		c.reasonCodes[key] = counter
+	}
+	return counter
+}
+
+// reasonCodes returns the reason codes in the packet.
+func reasonCodes(packet mqtt.Packet) []mqtt.ReasonCode {
+	switch packet := packet.(type) {
+	case *mqtt.ConnackPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	case *mqtt.PubackPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	case *mqtt.PubrecPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	case *mqtt.PubrelPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	case *mqtt.PubcompPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	case *mqtt.SubackPacket:
+		return packet.SubackPayload
+	case *mqtt.UnsubackPacket:
+		return packet.UnsubackPayload
+	case *mqtt.DisconnectPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	case *mqtt.AuthPacket:
+		return []mqtt.ReasonCode{packet.ReasonCode}
+	}
+	return nil
+}
+
+// ObservePacket implements mqtt.Observer.
+func (c *Collector) ObservePacket(event mqtt.PacketEvent) {
+	counters := c.packetCounters(packetKey{event.Direction, event.PacketType})
+	if event.Err != nil {
+		atomic.AddUint64(&counters.errors, 1)
+		var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+		if errors.As(event.Err, &rcErr) {
+			atomic.AddUint64(c.reasonCodeCounter(reasonCodeKey{event.Direction, event.PacketType, rcErr.ReasonCode()}), 1)
+		}
+	} else {
+		atomic.AddUint64(&counters.packets, 1)
+		for _, reasonCode := range reasonCodes(event.Packet) {
+			atomic.AddUint64(c.reasonCodeCounter(reasonCodeKey{event.Direction, event.PacketType, reasonCode}), 1)
+		}
+	}
+	atomic.AddUint64(&counters.bytes, event.Size)
+	atomic.AddUint64(&counters.duration, uint64(event.Duration))
+}
+
+// PacketTotals are the totals for a direction and packet type.
+type PacketTotals struct {
+	Direction  string  `json:"direction"`
+	PacketType string  `json:"packet_type"`
+	Packets    uint64  `json:"packets"`
+	Bytes      uint64  `json:"bytes"`
+	Seconds    float64 `json:"seconds"`
+	Errors     uint64  `json:"errors"`
+}
+
+// ReasonCodeTotals are the totals for a direction, packet type and reason code.
+type ReasonCodeTotals struct {
+	Direction  string `json:"direction"`
+	PacketType string `json:"packet_type"`
+	ReasonCode string `json:"reason_code"`
+	Count      uint64 `json:"count"`
+}
+
+// Totals are the totals of a Collector.
+type Totals struct {
+	Packets     []PacketTotals     `json:"packets"`
+	ReasonCodes []ReasonCodeTotals `json:"reason_codes"`
+}
+
+func packetTypeLabel(t mqtt.PacketType) string {
+	if t == 0 {
+		return "UNKNOWN" // Reading failed before the packet type was read.
+	}
+	return t.String()
+}
+
+func reasonCodeLabel(c mqtt.ReasonCode) string {
+	return fmt.Sprintf("0x%02x", byte(c))
+}
+
+// Totals returns the current totals, sorted by direction, packet type and
+// reason code.
+func (c *Collector) Totals() Totals {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	packetKeys := make([]packetKey, 0, len(c.packets))
+	for key := range c.packets {
+		packetKeys = append(packetKeys, key)
+	}
+	sort.Slice(packetKeys, func(i, j int) bool {
+		if packetKeys[i].direction != packetKeys[j].direction {
+			return packetKeys[i].direction < packetKeys[j].direction
+		}
+		return packetKeys[i].packetType < packetKeys[j].packetType
+	})
+	reasonCodeKeys := make([]reasonCodeKey, 0, len(c.reasonCodes))
+	for key := range c.reasonCodes {
+		reasonCodeKeys = append(reasonCodeKeys, key)
+	}
+	sort.Slice(reasonCodeKeys, func(i, j int) bool {
+		if reasonCodeKeys[i].direction != reasonCodeKeys[j].direction {
+			return reasonCodeKeys[i].direction < reasonCodeKeys[j].direction
+		}
+		if reasonCodeKeys[i].packetType != reasonCodeKeys[j].packetType {
+			return reasonCodeKeys[i].packetType < reasonCodeKeys[j].packetType
+		}
+		return reasonCodeKeys[i].reasonCode < reasonCodeKeys[j].reasonCode
+	})
+
+	totals := Totals{
+		Packets:     make([]PacketTotals, 0, len(packetKeys)),
+		ReasonCodes: make([]ReasonCodeTotals, 0, len(reasonCodeKeys)),
+	}
+	for _, key := range packetKeys {
+		counters := c.packets[key]
+		totals.Packets = append(totals.Packets, PacketTotals{
+			Direction:  key.direction.String(),
+			PacketType: packetTypeLabel(key.packetType),
+			Packets:    atomic.LoadUint64(&counters.packets),
+			Bytes:      atomic.LoadUint64(&counters.bytes),
+			Seconds:    float64(atomic.LoadUint64(&counters.duration)) / 1e9,
+			Errors:     atomic.LoadUint64(&counters.errors),
+		})
+	}
+	for _, key := range reasonCodeKeys {
+		totals.ReasonCodes = append(totals.ReasonCodes, ReasonCodeTotals{
+			Direction:  key.direction.String(),
+			PacketType: packetTypeLabel(key.packetType),
+			ReasonCode: reasonCodeLabel(key.reasonCode),
+			Count:      atomic.LoadUint64(c.reasonCodes[key]),
+		})
+	}
+	return totals
+}
+
+// String returns the totals as JSON. It implements expvar.Var, so that a
+// Collector can be published with expvar.Publish.
+func (c *Collector) String() string {
+	b, err := json.Marshal(c.Totals())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+func (c *Collector) namespace() string {
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	return "mqtt"
+}
+
+// WritePrometheus writes the totals in the Prometheus text exposition format.
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	totals := c.Totals()
+	ns := c.namespace()
+	ew := &errWriter{w: w}
+
+	packetMetrics := []struct {
+		name, help, kind string
+		value            func(PacketTotals) string
+	}{
+		{"packets_total", "Number of packets.", "counter", func(t PacketTotals) string { return fmt.Sprint(t.Packets) }},
+		{"packet_bytes_total", "Number of bytes of packets.", "counter", func(t PacketTotals) string { return fmt.Sprint(t.Bytes) }},
+		{"packet_duration_seconds_total", "Time spent reading or writing packets.", "counter", func(t PacketTotals) string { return fmt.Sprint(t.Seconds) }},
+		{"packet_errors_total", "Number of failed packet reads or writes.", "counter", func(t PacketTotals) string { return fmt.Sprint(t.Errors) }},
+	}
+	for _, metric := range packetMetrics {
+		ew.printf("# HELP %s_%s %s\n", ns, metric.name, metric.help)
+		ew.printf("# TYPE %s_%s %s\n", ns, metric.name, metric.kind)
+		for _, t := range totals.Packets {
+			ew.printf("%s_%s{direction=%q,packet_type=%q} %s\n", ns, metric.name, t.Direction, t.PacketType, metric.value(t))
+		}
+	}
+	ew.printf("# HELP %s_reason_codes_total Number of reason codes in packets and errors.\n", ns)
+	ew.printf("# TYPE %s_reason_codes_total counter\n", ns)
+	for _, t := range totals.ReasonCodes {
+		ew.printf("%s_reason_codes_total{direction=%q,packet_type=%q,reason_code=%q} %d\n", ns, t.Direction, t.PacketType, t.ReasonCode, t.Count)
+	}
+	return ew.err
+}
+
+// ServeHTTP serves the totals in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WritePrometheus(w)
+}
+
+// errWriter keeps the first error of a sequence of writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
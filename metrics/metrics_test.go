@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+var _ expvar.Var = (*Collector)(nil)
+
+func TestCollector(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCollector()
+
+	var buf bytes.Buffer
+	w := mqtt.NewWriter(&buf, mqtt.WithWriterObserver(c))
+	w.SetProtocol(5)
+	assert.NoError(w.WritePacket(&mqtt.SubackPacket{
+		SubackPayload: []mqtt.ReasonCode{mqtt.GrantedQoS1, mqtt.NotAuthorized},
+	}))
+	assert.NoError(w.WritePacket(&mqtt.PubackPacket{PubackHeader: mqtt.PubackHeader{PacketIdentifier: 1}}))
+
+	r := mqtt.NewReader(&buf, mqtt.WithReaderObserver(c))
+	r.SetProtocol(5)
+	for {
+		if _, err := r.ReadPacket(); err != nil {
+			break
+		}
+	}
+
+	c.ObservePacket(mqtt.PacketEvent{
+		Direction:  mqtt.Inbound,
+		PacketType: mqtt.PUBLISH,
+		Size:       10,
+		Duration:   time.Second,
+		Err:        mqtt.NewReasonCodeError(mqtt.PacketTooLarge, "mqtt: packet too large"),
+	})
+
+	totals := c.Totals()
+	assert.Equal([]PacketTotals{
+		{Direction: "inbound", PacketType: "UNKNOWN", Errors: 1},
+		{Direction: "inbound", PacketType: "PUBLISH", Bytes: 10, Seconds: 1, Errors: 1},
+		{Direction: "inbound", PacketType: "PUBACK", Packets: 1, Bytes: 6, Seconds: totals.Packets[2].Seconds},
+		{Direction: "inbound", PacketType: "SUBACK", Packets: 1, Bytes: 7, Seconds: totals.Packets[3].Seconds},
+		{Direction: "outbound", PacketType: "PUBACK", Packets: 1, Bytes: 6, Seconds: totals.Packets[4].Seconds},
+		{Direction: "outbound", PacketType: "SUBACK", Packets: 1, Bytes: 7, Seconds: totals.Packets[5].Seconds},
+	}, totals.Packets)
+	assert.Equal([]ReasonCodeTotals{
+		{Direction: "inbound", PacketType: "PUBLISH", ReasonCode: "0x95", Count: 1},
+		{Direction: "inbound", PacketType: "PUBACK", ReasonCode: "0x00", Count: 1},
+		{Direction: "inbound", PacketType: "SUBACK", ReasonCode: "0x01", Count: 1},
+		{Direction: "inbound", PacketType: "SUBACK", ReasonCode: "0x87", Count: 1},
+		{Direction: "outbound", PacketType: "PUBACK", ReasonCode: "0x00", Count: 1},
+		{Direction: "outbound", PacketType: "SUBACK", ReasonCode: "0x01", Count: 1},
+		{Direction: "outbound", PacketType: "SUBACK", ReasonCode: "0x87", Count: 1},
+	}, totals.ReasonCodes)
+
+	var decoded Totals
+	if assert.NoError(json.Unmarshal([]byte(c.String()), &decoded)) {
+		assert.Equal(totals, decoded)
+	}
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal("text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.True(strings.HasPrefix(body, "# HELP mqtt_packets_total "))
+	assert.Contains(body, "# TYPE mqtt_packets_total counter\n")
+	assert.Contains(body, `mqtt_packets_total{direction="outbound",packet_type="SUBACK"} 1`+"\n")
+	assert.Contains(body, `mqtt_packet_bytes_total{direction="inbound",packet_type="PUBLISH"} 10`+"\n")
+	assert.Contains(body, `mqtt_packet_duration_seconds_total{direction="inbound",packet_type="PUBLISH"} 1`+"\n")
+	assert.Contains(body, `mqtt_packet_errors_total{direction="inbound",packet_type="UNKNOWN"} 1`+"\n")
+	assert.Contains(body, `mqtt_reason_codes_total{direction="inbound",packet_type="SUBACK",reason_code="0x87"} 1`+"\n")
+
+	c.Namespace = "broker"
+	buf.Reset()
+	assert.NoError(c.WritePrometheus(&buf))
+	assert.Contains(buf.String(), `broker_packets_total{direction="inbound",packet_type="PUBACK"} 1`+"\n")
+}
+
+func BenchmarkCollector(b *testing.B) {
+	c := NewCollector()
+	event := mqtt.PacketEvent{
+		Direction:  mqtt.Outbound,
+		PacketType: mqtt.PUBACK,
+		Packet:     &mqtt.PubackPacket{},
+		Size:       4,
+		Duration:   time.Microsecond,
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.ObservePacket(event)
+		}
+	})
+}
+
+func ExampleCollector() {
+	collector := NewCollector()
+	expvar.Publish("mqtt", collector)  // Served on /debug/vars.
+	http.Handle("/metrics", collector) // Scraped by Prometheus.
+
+	conn, err := net.Dial("tcp", "localhost:1883")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	reader := mqtt.NewReader(conn, mqtt.WithReaderObserver(collector))
+	writer := mqtt.NewWriter(conn, mqtt.WithWriterObserver(collector))
+	_, _ = reader, writer
+}
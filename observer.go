@@ -0,0 +1,97 @@
+package mqtt
+
+import "time"
+
+// Direction is the direction of a packet.
+type Direction byte
+
+// Direction values.
+const (
+	Inbound  Direction = iota // The packet was read.
+	Outbound                  // The packet was written.
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "outbound"
+	}
+	return "inbound"
+}
+
+// PacketEvent is an event for a packet that was read or written.
+type PacketEvent struct {
+	Direction Direction
+	// PacketType is the type of the packet. It is zero if reading failed
+	// before the packet type was read.
+	PacketType PacketType
+	// Packet is the packet that was read or written. It is nil if reading
+	// failed.
+	Packet Packet
+	// Size is the number of bytes that were read or written, including the
+	// fixed header. Payloads that are streamed (see WithStreamingPayloads) are
+	// not included.
+	Size uint64
+	// Duration is the time it took to read or write the packet. For reads, it
+	// is measured from the moment that the first byte of the packet was read.
+	Duration time.Duration
+	// Err is the error if reading or writing failed.
+	Err error
+}
+
+// Observer observes the packets that a PacketReader reads or a PacketWriter
+// writes. ObservePacket is called while the PacketReader or PacketWriter is
+// locked, so it must not use them, and it should return quickly.
+type Observer interface {
+	ObservePacket(event PacketEvent)
+}
+
+// ObserverFunc is a func that implements Observer.
+type ObserverFunc func(event PacketEvent)
+
+// ObservePacket implements Observer.
+func (f ObserverFunc) ObservePacket(event PacketEvent) { f(event) }
+
+// WithReaderObserver returns a ReaderOption that makes the Reader call the
+// Observer for every packet that it reads, and for every read that fails.
+func WithReaderObserver(observer Observer) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.observer = observer
+	})
+}
+
+// WithWriterObserver returns a WriterOption that makes the Writer call the
+// Observer for every packet that it writes, and for every write that fails.
+func WithWriterObserver(observer Observer) WriterOption {
+	return writerOptionFunc(func(w *PacketWriter) {
+		w.observer = observer
+	})
+}
+
+// observeRead calls the observer for a packet that was read.
+func (r *PacketReader) observeRead(nReadBefore uint64, packet Packet, err error) {
+	event := PacketEvent{
+		Direction: Inbound,
+		Packet:    packet,
+		Size:      r.nReadTotal - nReadBefore,
+		Err:       err,
+	}
+	if event.Size > 0 {
+		event.PacketType = r.header.PacketType()
+	}
+	if !r.readStart.IsZero() {
+		event.Duration = time.Since(r.readStart)
+	}
+	r.observer.ObservePacket(event)
+}
+
+// observeWrite calls the observer for a packet that was written.
+func (w *PacketWriter) observeWrite(start time.Time, nWrittenBefore uint64, packet Packet, err error) {
+	w.observer.ObservePacket(PacketEvent{
+		Direction:  Outbound,
+		PacketType: packet.PacketType(),
+		Packet:     packet,
+		Size:       w.nWrittenTotal - nWrittenBefore,
+		Duration:   time.Since(start),
+		Err:        err,
+	})
+}
@@ -0,0 +1,91 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserver(t *testing.T) {
+	assert := assert.New(t)
+
+	var events []PacketEvent
+	observer := ObserverFunc(func(event PacketEvent) { events = append(events, event) })
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, WithWriterObserver(observer))
+	w.SetProtocol(5)
+
+	publish := &PublishPacket{
+		PublishHeader:  PublishHeader{TopicName: []byte("foo")},
+		PublishPayload: []byte("bar"),
+	}
+	assert.NoError(w.WritePacket(publish))
+	assert.NoError(w.WritePacket(&PingreqPacket{}))
+	assert.Error(NewWriter(failingWriter{}, WithWriterObserver(observer)).WritePacket(&PingreqPacket{}))
+
+	if assert.Len(events, 3) {
+		assert.Equal(Outbound, events[0].Direction)
+		assert.Equal(PUBLISH, events[0].PacketType)
+		assert.Equal(publish, events[0].Packet)
+		assert.Equal(uint64(11), events[0].Size)
+		assert.NoError(events[0].Err)
+
+		assert.Equal(PINGREQ, events[1].PacketType)
+		assert.Equal(uint64(2), events[1].Size)
+
+		assert.Equal(PINGREQ, events[2].PacketType)
+		assert.Equal(uint64(0), events[2].Size)
+		assert.Error(events[2].Err)
+	}
+
+	events = nil
+	buf.Write([]byte{0x30, 0x05, 0x00}) // Truncated PUBLISH.
+	r := NewReader(&buf, WithReaderObserver(observer))
+	r.SetProtocol(5)
+
+	packet, err := r.ReadPacket()
+	assert.NoError(err)
+	_, err = r.ReadPacket()
+	assert.NoError(err)
+	_, err = r.ReadPacket()
+	assert.Error(err)
+
+	if assert.Len(events, 3) {
+		assert.Equal(Inbound, events[0].Direction)
+		assert.Equal(PUBLISH, events[0].PacketType)
+		assert.Equal(packet, events[0].Packet)
+		assert.Equal(uint64(11), events[0].Size)
+		assert.NoError(events[0].Err)
+
+		assert.Equal(PINGREQ, events[1].PacketType)
+		assert.Equal(uint64(2), events[1].Size)
+
+		assert.Equal(PUBLISH, events[2].PacketType)
+		assert.Nil(events[2].Packet)
+		assert.Equal(uint64(3), events[2].Size)
+		assert.Error(events[2].Err)
+	}
+
+	events = nil
+	_, err = NewReader(&buf, WithReaderObserver(observer)).ReadPacket()
+	assert.True(errors.Is(err, io.EOF))
+	if assert.Len(events, 1) {
+		assert.Equal(PacketType(0), events[0].PacketType)
+		assert.Equal(uint64(0), events[0].Size)
+		assert.Zero(events[0].Duration)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestDirectionString(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("inbound", Inbound.String())
+	assert.Equal("outbound", Outbound.String())
+}
@@ -6,3 +6,40 @@ type Packet interface {
 	PacketType() PacketType
 	fixedHeader(protocol byte) FixedHeader
 }
+
+func newPacket(t PacketType) Packet {
+	switch t {
+	case CONNECT:
+		return new(ConnectPacket)
+	case CONNACK:
+		return new(ConnackPacket)
+	case PUBLISH:
+		return new(PublishPacket)
+	case PUBACK:
+		return new(PubackPacket)
+	case PUBREC:
+		return new(PubrecPacket)
+	case PUBREL:
+		return new(PubrelPacket)
+	case PUBCOMP:
+		return new(PubcompPacket)
+	case SUBSCRIBE:
+		return new(SubscribePacket)
+	case SUBACK:
+		return new(SubackPacket)
+	case UNSUBSCRIBE:
+		return new(UnsubscribePacket)
+	case UNSUBACK:
+		return new(UnsubackPacket)
+	case PINGREQ:
+		return new(PingreqPacket)
+	case PINGRESP:
+		return new(PingrespPacket)
+	case DISCONNECT:
+		return new(DisconnectPacket)
+	case AUTH:
+		return new(AuthPacket)
+	default:
+		panic(errUnknownPacket)
+	}
+}
@@ -0,0 +1,90 @@
+package mqtt
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// Payload Format Indicator values.
+const (
+	PayloadFormatBytes byte = 0 // The payload is unspecified bytes.
+	PayloadFormatUTF8  byte = 1 // The payload is UTF-8 encoded character data.
+)
+
+var errPayloadFormatInvalid = NewReasonCodeError(PayloadFormatInvalid, "mqtt: payload is not valid UTF-8")
+
+// PayloadFormat returns the value of the Payload Format Indicator property of
+// the PUBLISH packet, or PayloadFormatBytes if the packet does not have the
+// property.
+func (p *PublishPacket) PayloadFormat() byte {
+	for _, property := range p.Properties {
+		if property.Identifier == PayloadFormatIndicator {
+			return property.ByteValue
+		}
+	}
+	return PayloadFormatBytes
+}
+
+// ValidatePayloadFormat returns an error with the PayloadFormatInvalid reason
+// code if the PUBLISH packet indicates that its payload is UTF-8 encoded
+// character data, but the payload is not valid UTF-8.
+//
+// Streamed payloads (see WithStreamingPayloads) can not be checked before they
+// are read. Instead, the PublishPayloadReader of the packet is replaced with one
+// that returns the error as soon as it reads invalid UTF-8, and at the latest
+// at the end of the payload.
+func ValidatePayloadFormat(p *PublishPacket) error {
+	if p.PayloadFormat() != PayloadFormatUTF8 {
+		return nil
+	}
+	if p.PublishPayloadReader != nil {
+		if _, ok := p.PublishPayloadReader.(*utf8Reader); !ok {
+			p.PublishPayloadReader = &utf8Reader{r: p.PublishPayloadReader}
+		}
+		return nil
+	}
+	if !utf8.Valid(p.PublishPayload) {
+		return errPayloadFormatInvalid
+	}
+	return nil
+}
+
+// utf8Reader validates the UTF-8 of the payload that is read from r.
+type utf8Reader struct {
+	r       io.Reader
+	buf     []byte
+	partial []byte // The start of a rune that continues in the next read.
+}
+
+func (u *utf8Reader) Read(b []byte) (n int, err error) {
+	n, err = u.r.Read(b)
+	u.buf = append(append(u.buf[:0], u.partial...), b[:n]...)
+	complete := len(u.buf)
+	for i := len(u.buf) - 1; i >= 0 && i > len(u.buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(u.buf[i]) {
+			if !utf8.FullRune(u.buf[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+	if !utf8.Valid(u.buf[:complete]) {
+		return n, errPayloadFormatInvalid
+	}
+	u.partial = append(u.partial[:0], u.buf[complete:]...)
+	if err == io.EOF && len(u.partial) > 0 {
+		return n, errPayloadFormatInvalid
+	}
+	return n, err
+}
+
+// WithPayloadFormatValidation returns a ReaderOption that makes the Reader
+// return an error with the PayloadFormatInvalid reason code for PUBLISH packets
+// that indicate that their payload is UTF-8 encoded character data, but that
+// have a payload that is not valid UTF-8 (see ValidatePayloadFormat). Streamed
+// payloads are checked while they are read.
+func WithPayloadFormatValidation() ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.validatePayloadFormat = true
+	})
+}
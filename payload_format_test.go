@@ -0,0 +1,105 @@
+package mqtt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePayloadFormat(t *testing.T) {
+	utf8Format := Properties{{Identifier: PayloadFormatIndicator, ByteValue: PayloadFormatUTF8}}
+
+	tests := []struct {
+		name    string
+		packet  *PublishPacket
+		invalid bool
+	}{
+		{"bytes", &PublishPacket{PublishPayload: []byte{0xff, 0xfe}}, false},
+		{"valid UTF-8", &PublishPacket{Properties: utf8Format, PublishPayload: []byte("héllo")}, false},
+		{"invalid UTF-8", &PublishPacket{Properties: utf8Format, PublishPayload: []byte{0xff, 0xfe}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			err := ValidatePayloadFormat(tt.packet)
+			if !tt.invalid {
+				assert.NoError(err)
+				return
+			}
+			assertReasonCode(t, err, PayloadFormatInvalid)
+		})
+	}
+}
+
+func TestValidateStreamedPayloadFormat(t *testing.T) {
+	utf8Format := Properties{{Identifier: PayloadFormatIndicator, ByteValue: PayloadFormatUTF8}}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		invalid bool
+	}{
+		{"valid UTF-8", []byte("héllo wörld"), false},
+		{"invalid UTF-8", []byte{'a', 0xff, 'b'}, true},
+		{"truncated rune", []byte{'a', 0xc3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			packet := &PublishPacket{
+				Properties:           utf8Format,
+				PublishPayloadReader: iotest.OneByteReader(bytes.NewReader(tt.payload)),
+				PublishPayloadSize:   uint32(len(tt.payload)),
+			}
+			assert.NoError(ValidatePayloadFormat(packet))
+			payload, err := ioutil.ReadAll(packet.PublishPayloadReader)
+			if !tt.invalid {
+				assert.NoError(err)
+				assert.Equal(tt.payload, payload)
+				return
+			}
+			assertReasonCode(t, err, PayloadFormatInvalid)
+		})
+	}
+}
+
+func TestReaderPayloadFormatValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetProtocol(5)
+	invalid := &PublishPacket{
+		PublishHeader:  PublishHeader{TopicName: []byte("foo")},
+		Properties:     Properties{{Identifier: PayloadFormatIndicator, ByteValue: PayloadFormatUTF8}},
+		PublishPayload: []byte{0xff, 0xfe},
+	}
+	assert.NoError(w.WritePacket(invalid))
+	assert.NoError(w.WritePacket(invalid))
+
+	r := NewReader(&buf)
+	r.SetProtocol(5)
+	_, err := r.ReadPacket()
+	assert.NoError(err)
+
+	r = NewReader(&buf, WithPayloadFormatValidation())
+	r.SetProtocol(5)
+	_, err = r.ReadPacket()
+	assertReasonCode(t, err, PayloadFormatInvalid)
+
+	// Streamed payloads are checked while they are read.
+	buf.Reset()
+	assert.NoError(w.WritePacket(invalid))
+	r = NewReader(&buf, WithPayloadFormatValidation(), WithStreamingPayloads(1))
+	r.SetProtocol(5)
+	packet, err := r.ReadPacket()
+	if assert.NoError(err) {
+		_, err = ioutil.ReadAll(packet.(*PublishPacket).PublishPayloadReader)
+		assertReasonCode(t, err, PayloadFormatInvalid)
+	}
+}
@@ -0,0 +1,76 @@
+package mqtt
+
+import "sync"
+
+// PacketPool is a pool of packets that can be reused to reduce allocations.
+// A PacketPool is safe for concurrent use.
+type PacketPool struct {
+	pools [AUTH + 1]sync.Pool
+}
+
+// NewPacketPool returns a new PacketPool.
+func NewPacketPool() *PacketPool {
+	p := &PacketPool{}
+	for t := CONNECT; t <= AUTH; t++ {
+		t := t
+		p.pools[t].New = func() interface{} { return newPacket(t) }
+	}
+	return p
+}
+
+// Get returns a packet of the given type from the pool.
+func (p *PacketPool) Get(t PacketType) Packet {
+	if t < CONNECT || t > AUTH {
+		panic(errUnknownPacket)
+	}
+	return p.pools[t].Get().(Packet)
+}
+
+// Put resets the packet and returns it to the pool. The packet, and any slices
+// obtained from it, must not be used after calling Put.
+func (p *PacketPool) Put(packet Packet) {
+	switch packet := packet.(type) {
+	case *ConnectPacket:
+		packet.Reset()
+	case *ConnackPacket:
+		packet.Reset()
+	case *PublishPacket:
+		packet.Reset()
+	case *PubackPacket:
+		packet.Reset()
+	case *PubrecPacket:
+		packet.Reset()
+	case *PubrelPacket:
+		packet.Reset()
+	case *PubcompPacket:
+		packet.Reset()
+	case *SubscribePacket:
+		packet.Reset()
+	case *SubackPacket:
+		packet.Reset()
+	case *UnsubscribePacket:
+		packet.Reset()
+	case *UnsubackPacket:
+		packet.Reset()
+	case *PingreqPacket:
+		packet.Reset()
+	case *PingrespPacket:
+		packet.Reset()
+	case *DisconnectPacket:
+		packet.Reset()
+	case *AuthPacket:
+		packet.Reset()
+	default:
+		return
+	}
+	p.pools[packet.PacketType()].Put(packet)
+}
+
+// WithPacketPool returns a ReaderOption that makes the Reader get new packets
+// from the given pool. The caller should Put packets back into the pool when
+// it no longer uses them.
+func WithPacketPool(pool *PacketPool) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.pool = pool
+	})
+}
@@ -0,0 +1,56 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketPool(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := NewPacketPool()
+
+	for _, packet := range testPackets() {
+		pooled := pool.Get(packet.PacketType())
+		assert.Equal(packet.PacketType(), pooled.PacketType())
+		pool.Put(packet)
+	}
+
+	for _, packet := range testPackets() {
+		pool.Put(packet)
+		assert.True(PacketsEqual(newPacket(packet.PacketType()), packet), "%T was not reset", packet)
+	}
+}
+
+func TestReaderPacketPool(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetProtocol(5)
+
+	packets := testPackets()[2:] // Skip CONNECT and CONNACK.
+	for _, packet := range packets {
+		assert.NoError(w.WritePacket(packet))
+	}
+	for _, packet := range packets {
+		assert.NoError(w.WritePacket(packet))
+	}
+
+	pool := NewPacketPool()
+	r := NewReader(buf, WithPacketPool(pool))
+	r.SetProtocol(5)
+
+	for i := 0; i < 2; i++ {
+		for _, packet := range packets {
+			read, err := r.ReadPacket()
+			if !assert.NoError(err) {
+				t.FailNow()
+			}
+			assert.True(PacketsEqual(packet, read), "%T is not equal", packet)
+			pool.Put(read)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package mqtt
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 )
 
 // Properties is a slice of MQTT Properties.
@@ -113,6 +114,67 @@ const (
 	SharedSubscriptionAvailable                        = 42 // Shared Subscription Available
 )
 
+func (id PropertyIdentifier) String() string {
+	switch id {
+	case PayloadFormatIndicator:
+		return "Payload Format Indicator"
+	case MessageExpiryInterval:
+		return "Message Expiry Interval"
+	case ContentType:
+		return "Content Type"
+	case ResponseTopic:
+		return "Response Topic"
+	case CorrelationData:
+		return "Correlation Data"
+	case SubscriptionIdentifier:
+		return "Subscription Identifier"
+	case SessionExpiryInterval:
+		return "Session Expiry Interval"
+	case AssignedClientIdentifier:
+		return "Assigned Client Identifier"
+	case ServerKeepAlive:
+		return "Server Keep Alive"
+	case AuthenticationMethod:
+		return "Authentication Method"
+	case AuthenticationData:
+		return "Authentication Data"
+	case RequestProblemInformation:
+		return "Request Problem Information"
+	case WillDelayInterval:
+		return "Will Delay Interval"
+	case RequestResponseInformation:
+		return "Request Response Information"
+	case ResponseInformation:
+		return "Response Information"
+	case ServerReference:
+		return "Server Reference"
+	case ReasonString:
+		return "Reason String"
+	case ReceiveMaximum:
+		return "Receive Maximum"
+	case TopicAliasMaximum:
+		return "Topic Alias Maximum"
+	case TopicAlias:
+		return "Topic Alias"
+	case MaximumQoS:
+		return "Maximum QoS"
+	case RetainAvailable:
+		return "Retain Available"
+	case UserProperty:
+		return "User Property"
+	case MaximumPacketSize:
+		return "Maximum Packet Size"
+	case WildcardSubscriptionAvailable:
+		return "Wildcard Subscription Available"
+	case SubscriptionIdentifierAvailable:
+		return "Subscription Identifier Available"
+	case SharedSubscriptionAvailable:
+		return "Shared Subscription Available"
+	default:
+		return fmt.Sprintf("Unknown property: 0x%x", uint64(id))
+	}
+}
+
 const willProperties PacketType = 255
 
 var allowedPropertyIdentifiers = make(map[PropertyIdentifier]map[PacketType]bool)
@@ -185,43 +247,43 @@ func (r *PacketReader) readPacketProperties() {
 	var properties Properties
 	switch pkt := r.packet.(type) {
 	case *ConnectPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *ConnackPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *PublishPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *PubackPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *PubrecPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *PubrelPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *PubcompPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *SubscribePacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *SubackPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *UnsubscribePacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *UnsubackPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *DisconnectPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	case *AuthPacket:
-		properties = r.readProperties()
+		properties = r.appendProperties(pkt.Properties[:0])
 		pkt.Properties = properties
 	default:
 		return
@@ -233,17 +295,24 @@ func (r *PacketReader) readPacketProperties() {
 }
 
 func (r *PacketReader) readProperties() Properties {
-	var properties Properties
+	return r.appendProperties(nil)
+}
+
+func (r *PacketReader) appendProperties(properties Properties) Properties {
 	var propertyLength uint64
 	if propertyLength, r.err = r.readUvarint(); r.err != nil {
 		return nil
 	}
 	nReadBefore := r.nRead
+	var counter propertyCounter
 	for uint64(r.nRead-nReadBefore) < propertyLength {
 		property := r.readProperty()
 		if r.err != nil {
 			return nil
 		}
+		if r.err = r.checkProperty(&counter, property); r.err != nil {
+			return nil
+		}
 		properties = append(properties, property)
 	}
 	return properties
@@ -301,9 +370,116 @@ func (r *PacketReader) readProperty() (p Property) {
 	default:
 		r.err = errUnknownProperty
 	}
+	if r.err == nil {
+		r.err = p.validateValue()
+	}
 	return
 }
 
+const maxSubscriptionIdentifier = 268435455
+
+func invalidPropertyValue(c ReasonCode, p Property, value interface{}) error {
+	return NewReasonCodeError(c, fmt.Sprintf("mqtt: invalid value %v for property %s", value, p.Identifier))
+}
+
+// validateValue checks that the value of the property is in the range that the
+// specification allows for the property.
+func (p Property) validateValue() error {
+	switch p.Identifier {
+	case PayloadFormatIndicator,
+		RequestProblemInformation,
+		RequestResponseInformation,
+		MaximumQoS,
+		RetainAvailable,
+		WildcardSubscriptionAvailable,
+		SubscriptionIdentifierAvailable,
+		SharedSubscriptionAvailable:
+		if p.ByteValue > 1 {
+			return invalidPropertyValue(ProtocolError, p, p.ByteValue)
+		}
+	case MessageExpiryInterval,
+		SessionExpiryInterval,
+		WillDelayInterval:
+		if p.UintValue > math.MaxUint32 {
+			return invalidPropertyValue(MalformedPacket, p, p.UintValue)
+		}
+	case MaximumPacketSize:
+		if p.UintValue == 0 {
+			return invalidPropertyValue(ProtocolError, p, p.UintValue)
+		}
+		if p.UintValue > math.MaxUint32 {
+			return invalidPropertyValue(MalformedPacket, p, p.UintValue)
+		}
+	case ServerKeepAlive,
+		TopicAliasMaximum:
+		if p.UintValue > math.MaxUint16 {
+			return invalidPropertyValue(MalformedPacket, p, p.UintValue)
+		}
+	case ReceiveMaximum,
+		TopicAlias:
+		if p.UintValue == 0 {
+			return invalidPropertyValue(ProtocolError, p, p.UintValue)
+		}
+		if p.UintValue > math.MaxUint16 {
+			return invalidPropertyValue(MalformedPacket, p, p.UintValue)
+		}
+	case SubscriptionIdentifier:
+		if p.UintValue == 0 {
+			return invalidPropertyValue(ProtocolError, p, p.UintValue)
+		}
+		if p.UintValue > maxSubscriptionIdentifier {
+			return invalidPropertyValue(MalformedPacket, p, p.UintValue)
+		}
+	}
+	return nil
+}
+
+func (properties Properties) validateValues() error {
+	for _, property := range properties {
+		if err := property.validateValue(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePropertyValues checks the values of the properties of the packet
+// before it is written, so that the packet is not written partially.
+func validatePropertyValues(packet Packet) error {
+	switch pkt := packet.(type) {
+	case *ConnectPacket:
+		if err := pkt.WillProperties.validateValues(); err != nil {
+			return err
+		}
+		return pkt.Properties.validateValues()
+	case *ConnackPacket:
+		return pkt.Properties.validateValues()
+	case *PublishPacket:
+		return pkt.Properties.validateValues()
+	case *PubackPacket:
+		return pkt.Properties.validateValues()
+	case *PubrecPacket:
+		return pkt.Properties.validateValues()
+	case *PubrelPacket:
+		return pkt.Properties.validateValues()
+	case *PubcompPacket:
+		return pkt.Properties.validateValues()
+	case *SubscribePacket:
+		return pkt.Properties.validateValues()
+	case *SubackPacket:
+		return pkt.Properties.validateValues()
+	case *UnsubscribePacket:
+		return pkt.Properties.validateValues()
+	case *UnsubackPacket:
+		return pkt.Properties.validateValues()
+	case *DisconnectPacket:
+		return pkt.Properties.validateValues()
+	case *AuthPacket:
+		return pkt.Properties.validateValues()
+	}
+	return nil
+}
+
 func (w *PacketWriter) writePacketProperties() {
 	if w.protocol < 5 {
 		return
@@ -353,6 +529,9 @@ func (w *PacketWriter) writeProperties(p Properties) {
 }
 
 func (w *PacketWriter) writeProperty(p Property) {
+	if w.err = p.validateValue(); w.err != nil {
+		return
+	}
 	if w.err = w.writeUvarint(uint32(p.Identifier)); w.err != nil {
 		return
 	}
@@ -8,6 +8,17 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// testProperty returns a property with the zero value, or with the smallest
+// value if zero is not a valid value.
+func testProperty(id PropertyIdentifier) Property {
+	p := Property{Identifier: id}
+	switch id {
+	case MaximumPacketSize, ReceiveMaximum, TopicAlias, SubscriptionIdentifier:
+		p.UintValue = 1
+	}
+	return p
+}
+
 func TestReadWriteProperties(t *testing.T) {
 	for id := range allowedPropertyIdentifiers {
 		t.Run(fmt.Sprint(id), func(t *testing.T) {
@@ -16,7 +27,7 @@ func TestReadWriteProperties(t *testing.T) {
 			buf := &bytes.Buffer{}
 			w := NewWriter(buf)
 
-			subject := Property{Identifier: id}
+			subject := testProperty(id)
 
 			size := subject.size()
 
@@ -38,7 +49,7 @@ func TestReadWriteProperties(t *testing.T) {
 
 	var properties Properties
 	for id := range allowedPropertyIdentifiers {
-		properties = append(properties, Property{Identifier: id})
+		properties = append(properties, testProperty(id))
 	}
 
 	assert := assert.New(t)
@@ -58,3 +69,94 @@ func TestReadWriteProperties(t *testing.T) {
 	assert.NoError(r.err)
 	assert.Equal(properties, p)
 }
+
+func TestPropertyValueRanges(t *testing.T) {
+	tests := []struct {
+		property   Property
+		reasonCode ReasonCode // zero if valid.
+	}{
+		{Property{Identifier: PayloadFormatIndicator, ByteValue: 1}, 0},
+		{Property{Identifier: PayloadFormatIndicator, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: RequestProblemInformation, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: RequestResponseInformation, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: MaximumQoS, ByteValue: 1}, 0},
+		{Property{Identifier: MaximumQoS, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: RetainAvailable, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: WildcardSubscriptionAvailable, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: SubscriptionIdentifierAvailable, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: SharedSubscriptionAvailable, ByteValue: 2}, ProtocolError},
+		{Property{Identifier: MessageExpiryInterval, UintValue: 0xFFFFFFFF}, 0},
+		{Property{Identifier: MessageExpiryInterval, UintValue: 0x100000000}, MalformedPacket},
+		{Property{Identifier: SessionExpiryInterval, UintValue: 0x100000000}, MalformedPacket},
+		{Property{Identifier: WillDelayInterval, UintValue: 0x100000000}, MalformedPacket},
+		{Property{Identifier: MaximumPacketSize, UintValue: 0}, ProtocolError},
+		{Property{Identifier: MaximumPacketSize, UintValue: 0x100000000}, MalformedPacket},
+		{Property{Identifier: ServerKeepAlive, UintValue: 0}, 0},
+		{Property{Identifier: ServerKeepAlive, UintValue: 0x10000}, MalformedPacket},
+		{Property{Identifier: TopicAliasMaximum, UintValue: 0}, 0},
+		{Property{Identifier: TopicAliasMaximum, UintValue: 0x10000}, MalformedPacket},
+		{Property{Identifier: ReceiveMaximum, UintValue: 0}, ProtocolError},
+		{Property{Identifier: ReceiveMaximum, UintValue: 0xFFFF}, 0},
+		{Property{Identifier: ReceiveMaximum, UintValue: 0x10000}, MalformedPacket},
+		{Property{Identifier: TopicAlias, UintValue: 0}, ProtocolError},
+		{Property{Identifier: TopicAlias, UintValue: 0x10000}, MalformedPacket},
+		{Property{Identifier: SubscriptionIdentifier, UintValue: 0}, ProtocolError},
+		{Property{Identifier: SubscriptionIdentifier, UintValue: 268435455}, 0},
+		{Property{Identifier: SubscriptionIdentifier, UintValue: 268435456}, MalformedPacket},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s=%d", tt.property.Identifier, tt.property.UintValue+uint64(tt.property.ByteValue)), func(t *testing.T) {
+			assert := assert.New(t)
+
+			checkErr := func(err error) {
+				if tt.reasonCode == 0 {
+					assert.NoError(err)
+					return
+				}
+				assertReasonCode(t, err, tt.reasonCode)
+			}
+
+			// Write:
+			buf := &bytes.Buffer{}
+			w := NewWriter(buf)
+			w.SetProtocol(5)
+			err := w.WritePacket(&PublishPacket{
+				PublishHeader: PublishHeader{TopicName: []byte("foo")},
+				Properties:    Properties{tt.property},
+			})
+			checkErr(err)
+			if err != nil {
+				assert.Equal(0, buf.Len(), "packet must not be written partially")
+			}
+
+			// Read (values that fit on the wire):
+			if tt.property.UintValue > 0xFFFFFFFF || (tt.property.Identifier == SubscriptionIdentifier && tt.property.UintValue > maxSubscriptionIdentifier) {
+				return
+			}
+			buf.Reset()
+			w = NewWriter(buf)
+			w.writeUvarint(uint32(tt.property.Identifier))
+			switch tt.property.Identifier {
+			case SubscriptionIdentifier:
+				w.writeUvarint(uint32(tt.property.UintValue))
+			case MessageExpiryInterval, SessionExpiryInterval, WillDelayInterval, MaximumPacketSize:
+				w.writeUint32(uint32(tt.property.UintValue))
+			case ServerKeepAlive, TopicAliasMaximum, ReceiveMaximum, TopicAlias:
+				if tt.property.UintValue > 0xFFFF {
+					return
+				}
+				w.writeUint16(uint16(tt.property.UintValue))
+			default:
+				w.writeByte(tt.property.ByteValue)
+			}
+			r := NewReader(buf)
+			r.header.remainingLength = w.nWritten
+			p := r.readProperty()
+			checkErr(r.err)
+			if r.err == nil {
+				assert.Equal(tt.property, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Package proxyproto implements the HAProxy PROXY protocol (versions 1 and 2)
+// for listeners that accept MQTT connections from a load balancer.
+//
+// The PROXY protocol header is sent by the load balancer before any other data,
+// and contains the original source and destination addresses of the connection.
+// The Listener in this package reads and removes this header, so that the
+// connections it returns can be passed to mqtt.NewReader, and so that
+// RemoteAddr returns the address of the original client.
+//
+// See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt.
+package proxyproto // import "htdvisser.dev/mqtt/proxyproto"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Command is the command of a PROXY protocol header.
+type Command byte
+
+// Command values.
+const (
+	Local Command = 0x0 // Connection established by the proxy itself (health checks).
+	Proxy Command = 0x1 // Connection established on behalf of another node.
+)
+
+func (c Command) String() string {
+	switch c {
+	case Local:
+		return "LOCAL"
+	case Proxy:
+		return "PROXY"
+	default:
+		return fmt.Sprintf("Command(0x%x)", byte(c))
+	}
+}
+
+// Header is a PROXY protocol header.
+type Header struct {
+	Version     byte
+	Command     Command
+	Source      net.Addr // nil if unknown.
+	Destination net.Addr // nil if unknown.
+	TLVs        []TLV    // Only in version 2.
+}
+
+// TLV returns the value of the first TLV of the given type.
+func (h *Header) TLV(t TLVType) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	v1Signature = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const v1MaxLength = 107
+
+var (
+	// ErrNoHeader is returned when the connection does not start with a PROXY protocol header.
+	ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+
+	errInvalidV1Header = errors.New("proxyproto: invalid v1 header")
+	errInvalidV2Header = errors.New("proxyproto: invalid v2 header")
+)
+
+// ReadHeader reads a PROXY protocol header from r. If r does not start with a
+// PROXY protocol header, ErrNoHeader is returned and nothing is consumed.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// Peek one byte at a time, so that connections without a header that start
+	// with less data than a signature do not block.
+	for n := 1; n <= len(v2Signature); n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case bytes.Equal(b, v1Signature):
+			return readV1Header(r)
+		case bytes.Equal(b, v2Signature):
+			return readV2Header(r)
+		case !bytes.HasPrefix(v1Signature, b) && !bytes.HasPrefix(v2Signature, b):
+			return nil, ErrNoHeader
+		}
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1Header(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, errInvalidV1Header
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidV1Header
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	header := &Header{Version: 1, Command: Proxy}
+	if len(fields) < 2 {
+		return nil, errInvalidV1Header
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errInvalidV1Header
+	}
+	if len(fields) != 6 {
+		return nil, errInvalidV1Header
+	}
+	srcIP, dstIP := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	if srcIP == nil || dstIP == nil {
+		return nil, errInvalidV1Header
+	}
+	if isV4 := fields[1] == "TCP4"; isV4 != (srcIP.To4() != nil) || isV4 != (dstIP.To4() != nil) {
+		return nil, errInvalidV1Header
+	}
+	srcPort, err := parsePort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dstPort, err := parsePort(fields[5])
+	if err != nil {
+		return nil, err
+	}
+	header.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+	header.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	return header, nil
+}
+
+func parsePort(s string) (int, error) {
+	if len(s) > 1 && s[0] == '0' {
+		return 0, errInvalidV1Header
+	}
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, errInvalidV1Header
+	}
+	return int(port), nil
+}
+
+// Address families and transport protocols of version 2 headers.
+const (
+	familyUnspec = 0x0
+	familyInet   = 0x1
+	familyInet6  = 0x2
+	familyUnix   = 0x3
+
+	transportStream = 0x1
+	transportDgram  = 0x2
+)
+
+func readV2Header(r *bufio.Reader) (*Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, errInvalidV2Header
+	}
+	header := &Header{Version: 2, Command: Command(fixed[12] & 0x0F)}
+	if header.Command != Local && header.Command != Proxy {
+		return nil, errInvalidV2Header
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0x0F
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	var addrLen int
+	switch family {
+	case familyUnspec:
+	case familyInet:
+		addrLen = 12
+	case familyInet6:
+		addrLen = 36
+	case familyUnix:
+		addrLen = 216
+	default:
+		return nil, errInvalidV2Header
+	}
+	if len(body) < addrLen {
+		return nil, errInvalidV2Header
+	}
+	addrs, tlvs := body[:addrLen], body[addrLen:]
+
+	if header.Command == Proxy {
+		switch family {
+		case familyInet, familyInet6:
+			ipLen := (addrLen - 4) / 2
+			srcIP, dstIP := net.IP(addrs[:ipLen]), net.IP(addrs[ipLen:2*ipLen])
+			srcPort := int(binary.BigEndian.Uint16(addrs[2*ipLen:]))
+			dstPort := int(binary.BigEndian.Uint16(addrs[2*ipLen+2:]))
+			switch transport {
+			case transportStream:
+				header.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+				header.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+			case transportDgram:
+				header.Source = &net.UDPAddr{IP: srcIP, Port: srcPort}
+				header.Destination = &net.UDPAddr{IP: dstIP, Port: dstPort}
+			}
+		case familyUnix:
+			network := "unix"
+			if transport == transportDgram {
+				network = "unixgram"
+			}
+			header.Source = &net.UnixAddr{Net: network, Name: unixPath(addrs[:108])}
+			header.Destination = &net.UnixAddr{Net: network, Name: unixPath(addrs[108:])}
+		}
+	}
+
+	var err error
+	if header.TLVs, err = parseTLVs(tlvs); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func unixPath(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
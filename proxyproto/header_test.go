@@ -0,0 +1,154 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func v2Header(command, family byte, addrs []byte, tlvs ...TLV) []byte {
+	var body []byte
+	body = append(body, addrs...)
+	for _, tlv := range tlvs {
+		body = append(body, byte(tlv.Type), 0, 0)
+		binary.BigEndian.PutUint16(body[len(body)-2:], uint16(len(tlv.Value)))
+		body = append(body, tlv.Value...)
+	}
+	b := append([]byte{}, v2Signature...)
+	b = append(b, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(body)))
+	return append(b, body...)
+}
+
+func TestReadHeader(t *testing.T) {
+	inetAddrs := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x30, 0x39, 0x07, 0x5b}
+
+	ssl := []byte{SSLClientSSL | SSLClientCertConn, 0, 0, 0, 0}
+	ssl = append(ssl, byte(TLVSubtypeSSLCN), 0, 6)
+	ssl = append(ssl, "client"...)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		header *Header
+		err    error
+	}{
+		{
+			name: "v1 TCP4",
+			data: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 1883\r\n"),
+			header: &Header{
+				Version:     1,
+				Command:     Proxy,
+				Source:      &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345},
+				Destination: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1883},
+			},
+		},
+		{
+			name: "v1 TCP6",
+			data: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 1883\r\n"),
+			header: &Header{
+				Version:     1,
+				Command:     Proxy,
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1883},
+			},
+		},
+		{
+			name:   "v1 UNKNOWN",
+			data:   []byte("PROXY UNKNOWN\r\n"),
+			header: &Header{Version: 1, Command: Proxy},
+		},
+		{
+			name: "v1 mismatched family",
+			data: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 12345 1883\r\n"),
+			err:  errInvalidV1Header,
+		},
+		{
+			name: "v1 invalid port",
+			data: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 123456 1883\r\n"),
+			err:  errInvalidV1Header,
+		},
+		{
+			name: "v1 too long",
+			data: append([]byte("PROXY UNKNOWN "), bytes.Repeat([]byte{'x'}, 100)...),
+			err:  errInvalidV1Header,
+		},
+		{
+			name: "v2 TCP4 with TLVs",
+			data: v2Header(0x1, 0x11, inetAddrs,
+				TLV{Type: TLVTypeAuthority, Value: []byte("mqtt.example.com")},
+				TLV{Type: TLVTypeSSL, Value: ssl},
+			),
+			header: &Header{
+				Version:     2,
+				Command:     Proxy,
+				Source:      &net.TCPAddr{IP: net.IP{192, 0, 2, 1}, Port: 12345},
+				Destination: &net.TCPAddr{IP: net.IP{198, 51, 100, 1}, Port: 1883},
+				TLVs: []TLV{
+					{Type: TLVTypeAuthority, Value: []byte("mqtt.example.com")},
+					{Type: TLVTypeSSL, Value: ssl},
+				},
+			},
+		},
+		{
+			name:   "v2 LOCAL",
+			data:   v2Header(0x0, 0x00, nil),
+			header: &Header{Version: 2, Command: Local},
+		},
+		{
+			name: "v2 invalid command",
+			data: v2Header(0x2, 0x11, inetAddrs),
+			err:  errInvalidV2Header,
+		},
+		{
+			name: "v2 truncated TLV",
+			data: v2Header(0x1, 0x11, append(inetAddrs, byte(TLVTypeNoop), 0)),
+			err:  errInvalidTLV,
+		},
+		{
+			name: "no header",
+			data: []byte{0x10, 0x10, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x3c, 0x00, 0x00},
+			err:  ErrNoHeader,
+		},
+		{
+			name: "short",
+			data: []byte("PROXY"),
+			err:  io.EOF,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			header, err := ReadHeader(bufio.NewReader(bytes.NewReader(test.data)))
+			assert.Equal(test.err, err)
+			assert.Equal(test.header, header)
+		})
+	}
+}
+
+func TestHeaderSSL(t *testing.T) {
+	assert := assert.New(t)
+
+	value := []byte{SSLClientSSL | SSLClientCertConn, 0, 0, 0, 0}
+	value = append(value, byte(TLVSubtypeSSLCN), 0, 6)
+	value = append(value, "client"...)
+
+	header := &Header{TLVs: []TLV{{Type: TLVTypeSSL, Value: value}}}
+
+	ssl, ok := header.SSL()
+	assert.True(ok)
+	assert.True(ssl.Verified())
+	cn, ok := ssl.TLV(TLVSubtypeSSLCN)
+	assert.True(ok)
+	assert.Equal([]byte("client"), cn)
+
+	_, ok = (&Header{}).SSL()
+	assert.False(ok)
+}
@@ -0,0 +1,173 @@
+package proxyproto
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Policy determines how the PROXY protocol header of a connection is handled.
+type Policy int
+
+// Policy values.
+const (
+	// Require a PROXY protocol header. Connections without header fail to read.
+	Require Policy = iota
+	// Use the PROXY protocol header if present.
+	Use
+	// Ignore the PROXY protocol header, and treat it as application data.
+	// This should be used for connections from untrusted upstreams.
+	Ignore
+)
+
+// Listener wraps a net.Listener and reads PROXY protocol headers from the
+// connections it accepts.
+type Listener struct {
+	net.Listener
+
+	// Policy returns the policy for a connection from the given upstream address.
+	// If Policy is nil, a PROXY protocol header is required on all connections.
+	Policy func(upstream net.Addr) Policy
+
+	// HeaderTimeout is the time after Accept within which the PROXY protocol
+	// header must be read. The read deadline of the connection is cleared after
+	// the header is read. If zero, there is no timeout.
+	HeaderTimeout time.Duration
+}
+
+// NewListener returns a Listener that requires a PROXY protocol header on all
+// connections it accepts.
+func NewListener(lis net.Listener) *Listener {
+	return &Listener{Listener: lis}
+}
+
+// Accept accepts the next connection. The PROXY protocol header is not read by
+// Accept, so that Accept does not block on slow clients. It is read by the
+// Handshake method of the connection, or by the first call to Read,
+// RemoteAddr, LocalAddr or Header.
+func (l *Listener) Accept() (net.Conn, error) {
+	nc, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	policy := Require
+	if l.Policy != nil {
+		policy = l.Policy(nc.RemoteAddr())
+	}
+	conn := NewConn(nc, policy)
+	if l.HeaderTimeout > 0 {
+		conn.deadline = time.Now().Add(l.HeaderTimeout)
+	}
+	return conn, nil
+}
+
+// Conn is a connection that starts with a PROXY protocol header.
+type Conn struct {
+	net.Conn
+	cr       *countingReader
+	br       *bufio.Reader
+	policy   Policy
+	deadline time.Time
+
+	mu     sync.Mutex
+	done   bool
+	header *Header
+	err    error
+}
+
+// countingReader counts the bytes that are read from the connection, so that
+// the Conn knows whether reading the header consumed any data.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += n
+	return n, err
+}
+
+// NewConn returns a new Conn that handles the PROXY protocol header of nc
+// according to the policy.
+func NewConn(nc net.Conn, policy Policy) *Conn {
+	cr := &countingReader{Reader: nc}
+	return &Conn{Conn: nc, cr: cr, br: bufio.NewReader(cr), policy: policy}
+}
+
+// Handshake reads the PROXY protocol header, if it was not read yet. It blocks
+// until the header is read, or until the read deadline of the connection (or
+// the HeaderTimeout of the Listener) expires. If the deadline expires before
+// any data of the header is read, Handshake can be called again after
+// extending the deadline. Other errors are returned by every later call.
+func (c *Conn) Handshake() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return c.err
+	}
+	if c.policy == Ignore {
+		c.done = true
+		return nil
+	}
+	if !c.deadline.IsZero() {
+		c.Conn.SetReadDeadline(c.deadline)
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	header, err := ReadHeader(c.br)
+	if err == ErrNoHeader && c.policy == Use {
+		err = nil
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && c.cr.n == c.br.Buffered() {
+		return err // Nothing was consumed, so the header can still be read.
+	}
+	c.done, c.header, c.err = true, header, err
+	return err
+}
+
+// Header returns the PROXY protocol header of the connection, reading it if
+// necessary (see Handshake). If the connection has no header, and the policy
+// does not require one, the header is nil.
+func (c *Conn) Header() (*Header, error) {
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	return c.header, nil
+}
+
+// Read reads data from the connection after the PROXY protocol header.
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.br.Read(b)
+}
+
+// RemoteAddr returns the source address from the PROXY protocol header, or
+// the address of the upstream if the header does not contain it. RemoteAddr
+// blocks until the header is read (see Handshake). If the header can not be
+// read, it returns the address of the upstream.
+func (c *Conn) RemoteAddr() net.Addr {
+	if header, _ := c.Header(); header != nil && header.Command == Proxy && header.Source != nil {
+		return header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the PROXY protocol header, or
+// the local address of the connection if the header does not contain it.
+// LocalAddr blocks until the header is read (see Handshake). If the header can
+// not be read, it returns the local address of the connection.
+func (c *Conn) LocalAddr() net.Addr {
+	if header, _ := c.Header(); header != nil && header.Command == Proxy && header.Destination != nil {
+		return header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// UpstreamAddr returns the address of the upstream (the proxy).
+func (c *Conn) UpstreamAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
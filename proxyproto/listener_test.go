@@ -0,0 +1,165 @@
+package proxyproto
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestListener(t *testing.T) {
+	assert := assert.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	l := NewListener(lis)
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 1883\r\n"))
+		mqtt.NewWriter(conn).WritePacket(new(mqtt.PingreqPacket))
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := l.Accept()
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	assert.Equal("192.0.2.1:12345", conn.RemoteAddr().String())
+	assert.Equal("198.51.100.1:1883", conn.LocalAddr().String())
+	assert.Equal("127.0.0.1", conn.(*Conn).UpstreamAddr().(*net.TCPAddr).IP.String())
+
+	packet, err := mqtt.NewReader(conn).ReadPacket()
+	assert.NoError(err)
+	assert.Equal(mqtt.PINGREQ, packet.PacketType())
+}
+
+func TestConnPolicy(t *testing.T) {
+	ping := []byte{0xc0, 0x00}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		data   []byte
+		valid  bool
+	}{
+		{"require without header", Require, ping, false},
+		{"require with header", Require, append([]byte("PROXY UNKNOWN\r\n"), ping...), true},
+		{"use without header", Use, ping, true},
+		{"use with header", Use, append([]byte("PROXY UNKNOWN\r\n"), ping...), true},
+		{"ignore without header", Ignore, ping, true},
+		{"ignore with header", Ignore, append([]byte("PROXY UNKNOWN\r\n"), ping...), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			local, remote := net.Pipe()
+			defer local.Close()
+			go func() {
+				remote.Write(test.data)
+				remote.Close()
+			}()
+
+			_, err := mqtt.NewReader(NewConn(local, test.policy)).ReadPacket()
+			if test.valid {
+				assert.NoError(err)
+			} else {
+				assert.Error(err)
+			}
+		})
+	}
+}
+
+func TestConnPolicyShortPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	// The connection starts with less data than the PROXY protocol signatures,
+	// and stays open.
+	go remote.Write([]byte{0xc0, 0x00})
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := mqtt.NewReader(NewConn(local, Use)).ReadPacket()
+		read <- err
+	}()
+
+	select {
+	case err := <-read:
+		assert.NoError(err)
+	case <-time.After(time.Second):
+		t.Fatal("reading the short packet blocked")
+	}
+}
+
+func TestConnHandshake(t *testing.T) {
+	assert := assert.New(t)
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	conn := NewConn(local, Require)
+
+	// A timeout before the header is sent is not permanent.
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	err := conn.Handshake()
+	if netErr, ok := err.(net.Error); assert.True(ok) {
+		assert.True(netErr.Timeout())
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	go remote.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 1883\r\n"))
+	assert.NoError(conn.Handshake())
+	assert.Equal("192.0.2.1:12345", conn.RemoteAddr().String())
+}
+
+func TestListenerHeaderTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	l := &Listener{Listener: lis, HeaderTimeout: 10 * time.Millisecond}
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := l.Accept()
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	handshake := make(chan error, 1)
+	go func() { handshake <- conn.(*Conn).Handshake() }()
+	select {
+	case err := <-handshake:
+		assert.Error(err)
+	case <-time.After(time.Second):
+		t.Fatal("handshake did not time out")
+	}
+	assert.Equal(conn.(*Conn).UpstreamAddr(), conn.RemoteAddr())
+}
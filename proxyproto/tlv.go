@@ -0,0 +1,99 @@
+package proxyproto
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// TLVType is the type of a TLV (Type-Length-Value) extension in a version 2
+// PROXY protocol header.
+type TLVType byte
+
+// TLVType values.
+const (
+	TLVTypeALPN      TLVType = 0x01 // Application-Layer Protocol Negotiation
+	TLVTypeAuthority TLVType = 0x02 // Host name (SNI) of the client
+	TLVTypeCRC32C    TLVType = 0x03 // CRC32c checksum of the header
+	TLVTypeNoop      TLVType = 0x04 // Padding
+	TLVTypeUniqueID  TLVType = 0x05 // Unique ID of the connection
+	TLVTypeSSL       TLVType = 0x20 // TLS information
+	TLVTypeNetNS     TLVType = 0x30 // Network namespace
+)
+
+// Sub-types of the SSL TLV.
+const (
+	TLVSubtypeSSLVersion TLVType = 0x21 // TLS version
+	TLVSubtypeSSLCN      TLVType = 0x22 // Common Name of the client certificate
+	TLVSubtypeSSLCipher  TLVType = 0x23 // Cipher suite
+	TLVSubtypeSSLSigAlg  TLVType = 0x24 // Signature algorithm of the certificate
+	TLVSubtypeSSLKeyAlg  TLVType = 0x25 // Key algorithm of the certificate
+)
+
+// TLV is a TLV (Type-Length-Value) extension of a version 2 PROXY protocol header.
+type TLV struct {
+	Type  TLVType
+	Value []byte
+}
+
+var errInvalidTLV = errors.New("proxyproto: invalid TLV")
+
+func parseTLVs(b []byte) (tlvs []TLV, err error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errInvalidTLV
+		}
+		length := int(binary.BigEndian.Uint16(b[1:]))
+		if len(b) < 3+length {
+			return nil, errInvalidTLV
+		}
+		tlvs = append(tlvs, TLV{Type: TLVType(b[0]), Value: b[3 : 3+length]})
+		b = b[3+length:]
+	}
+	return tlvs, nil
+}
+
+// SSL client flags.
+const (
+	SSLClientSSL      = 0x01 // The client connected over SSL/TLS.
+	SSLClientCertConn = 0x02 // The client provided a certificate over the current connection.
+	SSLClientCertSess = 0x04 // The client provided a certificate at least once over the TLS session.
+)
+
+// SSL is the content of the SSL TLV.
+type SSL struct {
+	Client byte   // Combination of the SSLClient flags.
+	Verify uint32 // Zero if the client certificate was verified successfully.
+	TLVs   []TLV  // Sub-TLVs, such as the TLS version and the client certificate Common Name.
+}
+
+// Verified returns whether the client presented a certificate that was verified successfully.
+func (s SSL) Verified() bool {
+	return s.Client&SSLClientCertConn != 0 && s.Verify == 0
+}
+
+// TLV returns the value of the first sub-TLV of the given type.
+func (s SSL) TLV(t TLVType) ([]byte, bool) {
+	for _, tlv := range s.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SSL returns the parsed SSL TLV, if present.
+func (h *Header) SSL() (SSL, bool) {
+	value, ok := h.TLV(TLVTypeSSL)
+	if !ok || len(value) < 5 {
+		return SSL{}, false
+	}
+	tlvs, err := parseTLVs(value[5:])
+	if err != nil {
+		return SSL{}, false
+	}
+	return SSL{
+		Client: value[0],
+		Verify: binary.BigEndian.Uint32(value[1:]),
+		TLVs:   tlvs,
+	}, true
+}
@@ -1,11 +1,23 @@
 package mqtt
 
+import (
+	"errors"
+	"io"
+)
+
 // PublishPacket is the Publish packet.
 type PublishPacket struct {
 	PublishFlags
 	PublishHeader
 	Properties
 	PublishPayload []byte
+
+	// PublishPayloadReader, if non-nil, is used instead of PublishPayload. It
+	// is set by a Reader with streaming payloads enabled, and can be set when
+	// writing large payloads without buffering them in memory. In that case
+	// PublishPayloadSize must be set to the number of bytes that will be read.
+	PublishPayloadReader io.Reader
+	PublishPayloadSize   uint32
 }
 
 func (*PublishPacket) _isPacket() {}
@@ -28,6 +40,9 @@ func (p PublishPacket) size(protocol byte) uint32 {
 	if protocol >= 5 {
 		size += int(p.Properties.size())
 	}
+	if p.PublishPayloadReader != nil {
+		return uint32(size) + p.PublishPayloadSize
+	}
 	size += len(p.PublishPayload)
 	return uint32(size)
 }
@@ -57,7 +72,10 @@ type PublishFlags byte
 var errInvalidQoS = NewReasonCodeError(MalformedPacket, "mqtt: invalid QoS")
 
 func (r *PacketReader) validatePublishFlags(f PublishFlags) error {
-	return r.validateQoS(f.QoS())
+	if f.QoS() > QoS2 {
+		return errPublishQoS
+	}
+	return nil
 }
 
 // Dup returns the Dup bit from the publish flags.
@@ -107,6 +125,9 @@ func (r *PacketReader) readPublishHeader() {
 	if packet.PublishHeader.TopicName, r.err = r.readBytes(); r.err != nil {
 		return
 	}
+	if r.err = r.checkTopic(packet.PublishHeader.TopicName, errTopicNameTooLong); r.err != nil {
+		return
+	}
 	if packet.PublishFlags.QoS() > 0 {
 		if packet.PublishHeader.PacketIdentifier, r.err = r.readUint16(); r.err != nil {
 			return
@@ -128,10 +149,113 @@ func (w *PacketWriter) writePublishHeader() {
 
 func (r *PacketReader) readPublishPayload() {
 	packet := r.packet.(*PublishPacket)
+	if r.streamPayloads && r.remaining() > r.streamThreshold {
+		r.payload = &payloadReader{r: r, remaining: r.remaining()}
+		packet.PublishPayloadReader = r.payload
+		packet.PublishPayloadSize = r.remaining()
+		r.nRead += r.remaining() // The payload is read by the payloadReader.
+		return
+	}
 	packet.PublishPayload, r.err = r.readRemaining()
 }
 
+var errPayloadShort = errors.New("mqtt: publish payload reader returned fewer bytes than the publish payload size")
+
 func (w *PacketWriter) writePublishPayload() {
 	packet := w.packet.(*PublishPacket)
-	w.err = w.write(packet.PublishPayload)
+	if packet.PublishPayloadReader == nil {
+		w.err = w.write(packet.PublishPayload)
+		return
+	}
+	buf := make([]byte, 32*1024)
+	for remaining := packet.PublishPayloadSize; remaining > 0; {
+		if uint32(len(buf)) > remaining {
+			buf = buf[:remaining]
+		}
+		n, err := packet.PublishPayloadReader.Read(buf)
+		if n > 0 {
+			if w.err = w.write(buf[:n]); w.err != nil {
+				return
+			}
+			remaining -= uint32(n)
+		}
+		if err == io.EOF && remaining > 0 {
+			w.err = errPayloadShort
+			return
+		}
+		if err != nil && err != io.EOF {
+			w.err = err
+			return
+		}
+	}
+}
+
+// WithStreamingPayloads returns a ReaderOption that makes the Reader stream
+// publish payloads larger than threshold bytes instead of reading them into
+// memory. The PublishPayloadReader of such packets must be read before the next
+// call to ReadPacket; any unread part of the payload is discarded by that call,
+// after which the PublishPayloadReader returns an error.
+func WithStreamingPayloads(threshold uint32) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.streamPayloads = true
+		r.streamThreshold = threshold
+	})
+}
+
+var errPayloadReaderExpired = errors.New("mqtt: publish payload reader used after reading the next packet")
+
+// payloadReader reads a streamed publish payload from the PacketReader.
+type payloadReader struct {
+	r         *PacketReader
+	remaining uint32
+	expired   bool
+}
+
+func (p *payloadReader) Read(b []byte) (n int, err error) {
+	p.r.mu.Lock()
+	defer p.r.mu.Unlock()
+	if p.expired {
+		return 0, errPayloadReaderExpired
+	}
+	if p.remaining == 0 {
+		return 0, io.EOF
+	}
+	if uint32(len(b)) > p.remaining {
+		b = b[:p.remaining]
+	}
+	n, err = p.r.r.Read(b)
+	p.remaining -= uint32(n)
+	p.r.nReadTotal += uint64(n)
+	if err == io.EOF && p.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// discardPayload discards the unread part of a streamed payload. It must be
+// called with the PacketReader's lock held.
+func (r *PacketReader) discardPayload() error {
+	p := r.payload
+	if p == nil {
+		return nil
+	}
+	p.expired = true
+	for p.remaining > 0 {
+		var buf [4096]byte
+		b := buf[:]
+		if uint32(len(b)) > p.remaining {
+			b = b[:p.remaining]
+		}
+		n, err := r.r.Read(b)
+		p.remaining -= uint32(n)
+		r.nReadTotal += uint64(n)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	r.payload = nil
+	return nil
 }
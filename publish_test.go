@@ -1,6 +1,8 @@
 package mqtt
 
 import (
+	"bytes"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -67,3 +69,73 @@ func TestPublishFlags(t *testing.T) {
 	assert.Equal(QoS2, f.QoS())
 	assert.False(f.Retain())
 }
+
+func TestStreamingPayloads(t *testing.T) {
+	assert := assert.New(t)
+
+	large := bytes.Repeat([]byte("firmware"), 1024)
+
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetProtocol(5)
+
+	// Write a streamed payload, followed by a small and a large one.
+	assert.NoError(w.WritePacket(&PublishPacket{
+		PublishHeader:        PublishHeader{TopicName: []byte("firmware")},
+		PublishPayloadReader: bytes.NewReader(large),
+		PublishPayloadSize:   uint32(len(large)),
+	}))
+	assert.NoError(w.WritePacket(&PublishPacket{
+		PublishHeader:  PublishHeader{TopicName: []byte("small")},
+		PublishPayload: []byte("small"),
+	}))
+	assert.NoError(w.WritePacket(&PublishPacket{
+		PublishHeader:  PublishHeader{TopicName: []byte("large")},
+		PublishPayload: large,
+	}))
+	assert.NoError(w.WritePacket(&PingreqPacket{}))
+
+	r := NewReader(buf, WithStreamingPayloads(1024))
+	r.SetProtocol(5)
+
+	packet, err := r.ReadPacket()
+	assert.NoError(err)
+	publish := packet.(*PublishPacket)
+	assert.Nil(publish.PublishPayload)
+	assert.Equal(uint32(len(large)), publish.PublishPayloadSize)
+	payload, err := ioutil.ReadAll(publish.PublishPayloadReader)
+	assert.NoError(err)
+	assert.Equal(large, payload)
+
+	packet, err = r.ReadPacket()
+	assert.NoError(err)
+	publish = packet.(*PublishPacket)
+	assert.Nil(publish.PublishPayloadReader)
+	assert.Equal([]byte("small"), publish.PublishPayload)
+
+	// The unread payload is discarded by the next ReadPacket.
+	packet, err = r.ReadPacket()
+	assert.NoError(err)
+	payloadReader := packet.(*PublishPacket).PublishPayloadReader
+	_, err = payloadReader.Read(make([]byte, 10))
+	assert.NoError(err)
+
+	packet, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(PINGREQ, packet.PacketType())
+
+	_, err = payloadReader.Read(make([]byte, 10))
+	assert.Equal(errPayloadReaderExpired, err)
+}
+
+func TestWriteShortPayloadReader(t *testing.T) {
+	assert := assert.New(t)
+
+	w := NewWriter(&bytes.Buffer{})
+	err := w.WritePacket(&PublishPacket{
+		PublishHeader:        PublishHeader{TopicName: []byte("firmware")},
+		PublishPayloadReader: bytes.NewReader([]byte("short")),
+		PublishPayloadSize:   10,
+	})
+	assert.Equal(errPayloadShort, err)
+}
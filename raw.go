@@ -0,0 +1,78 @@
+package mqtt
+
+// WithRawBytes returns a ReaderOption that makes the Reader keep the raw bytes
+// of the packets that it reads (see RawBytes).
+func WithRawBytes() ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.keepRaw = true
+	})
+}
+
+// RawBytes returns the raw bytes of the last packet that was read, including
+// its fixed header, or nil if the Reader does not have the WithRawBytes option.
+// The returned slice is not modified by later reads. Payloads that are streamed
+// (see WithStreamingPayloads) are not included.
+func (r *PacketReader) RawBytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.raw == nil {
+		return nil
+	}
+	return r.raw.last
+}
+
+// maxRawPrealloc is the maximum number of bytes that is allocated for raw bytes
+// before they are read.
+const maxRawPrealloc = 64 * 1024
+
+// rawRecorder records the bytes that are read while it is recording.
+type rawRecorder struct {
+	reader
+	recording bool
+	buf       []byte
+	last      []byte
+}
+
+func (rr *rawRecorder) Read(b []byte) (n int, err error) {
+	n, err = rr.reader.Read(b)
+	if rr.recording {
+		rr.buf = append(rr.buf, b[:n]...)
+	}
+	return n, err
+}
+
+func (rr *rawRecorder) ReadByte() (b byte, err error) {
+	b, err = rr.reader.ReadByte()
+	if err == nil && rr.recording {
+		rr.buf = append(rr.buf, b)
+	}
+	return b, err
+}
+
+// start starts recording a new packet.
+func (rr *rawRecorder) start() {
+	rr.recording = true
+	rr.buf = make([]byte, 0, 8)
+}
+
+// grow grows the buffer for a packet with the given remaining length.
+func (rr *rawRecorder) grow(remainingLength uint32) {
+	n := int(remainingLength)
+	if n > maxRawPrealloc {
+		n = maxRawPrealloc
+	}
+	buf := make([]byte, len(rr.buf), len(rr.buf)+n)
+	copy(buf, rr.buf)
+	rr.buf = buf
+}
+
+// stop stops recording. If the packet was read, its raw bytes are kept.
+func (rr *rawRecorder) stop(read bool) {
+	rr.recording = false
+	if read {
+		rr.last = rr.buf
+	} else {
+		rr.last = nil
+	}
+	rr.buf = nil
+}
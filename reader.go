@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"io"
 	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -34,14 +35,32 @@ type reader interface {
 
 // PacketReader reads MQTT packets.
 type PacketReader struct {
-	maxPacketLength uint32
-	r               reader
-	protocol        byte
-	mu              sync.Mutex
-	nRead           uint32
-	header          FixedHeader
-	packet          Packet
-	err             error
+	maxPacketLength       uint32
+	limits                limits
+	budget                *MemoryBudget
+	reserved              uint64
+	pool                  *PacketPool
+	role                  Role
+	skipValidation        bool
+	validatePayloadFormat bool
+	keepRaw               bool
+	raw                   *rawRecorder
+	observer              Observer
+	readStart             time.Time
+	state                 connState
+	streamPayloads        bool
+	streamThreshold       uint32
+	payload               *payloadReader
+	r                     reader
+	readDeadliner         readDeadliner
+	protocol              byte
+	mu                    sync.Mutex
+	nRead                 uint32
+	nReadTotal            uint64
+	header                FixedHeader
+	packet                Packet
+	err                   error
+	abortErr              error
 }
 
 // SetProtocol sets the MQTT protocol version.
@@ -61,9 +80,16 @@ func NewReader(rd io.Reader, opts ...ReaderOption) *PacketReader {
 	} else {
 		pr.r = bufio.NewReader(rd)
 	}
+	if d, ok := rd.(readDeadliner); ok {
+		pr.readDeadliner = d
+	}
 	for _, opt := range opts {
 		opt.apply(pr)
 	}
+	if pr.keepRaw {
+		pr.raw = &rawRecorder{reader: pr.r}
+		pr.r = pr.raw
+	}
 	return pr
 }
 
@@ -132,44 +158,46 @@ func (r *PacketReader) readPayload() {
 func (r *PacketReader) ReadPacket() (Packet, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.readPacket()
+}
+
+func (r *PacketReader) readPacket() (packet Packet, err error) {
+	if r.observer != nil {
+		nReadBefore := r.nReadTotal
+		r.readStart = time.Time{}
+		defer func() { r.observeRead(nReadBefore, packet, err) }()
+	}
+	if r.abortErr != nil {
+		return nil, r.abortErr
+	}
+	if err := r.discardPayload(); err != nil {
+		return nil, err
+	}
 	r.nRead = 0
+	if r.raw != nil {
+		r.raw.start()
+		defer func() { r.raw.stop(r.err == nil) }()
+	}
 	r.readFixedHeader()
 	if r.err != nil {
 		return nil, r.err
 	}
-	switch r.header.PacketType() {
-	case CONNECT:
-		r.packet = new(ConnectPacket)
-	case CONNACK:
-		r.packet = new(ConnackPacket)
-	case PUBLISH:
-		packet := new(PublishPacket)
+	if r.raw != nil {
+		r.raw.grow(r.header.remainingLength)
+	}
+	if r.budget != nil {
+		defer r.releaseBudget()
+	}
+	if r.err = r.checkRole(r.header.PacketType()); r.err != nil {
+		return nil, r.err
+	}
+	if r.pool != nil {
+		r.packet = r.pool.Get(r.header.PacketType())
+	} else {
+		r.packet = newPacket(r.header.PacketType())
+	}
+	if packet, ok := r.packet.(*PublishPacket); ok {
 		packet.PublishFlags = PublishFlags(r.header.typeAndFlags) & 0xf
-		r.packet = packet
-	case PUBACK:
-		r.packet = new(PubackPacket)
-	case PUBREC:
-		r.packet = new(PubrecPacket)
-	case PUBREL:
-		r.packet = new(PubrelPacket)
-	case PUBCOMP:
-		r.packet = new(PubcompPacket)
-	case SUBSCRIBE:
-		r.packet = new(SubscribePacket)
-	case SUBACK:
-		r.packet = new(SubackPacket)
-	case UNSUBSCRIBE:
-		r.packet = new(UnsubscribePacket)
-	case UNSUBACK:
-		r.packet = new(UnsubackPacket)
-	case PINGREQ:
-		r.packet = new(PingreqPacket)
-	case PINGRESP:
-		r.packet = new(PingrespPacket)
-	case DISCONNECT:
-		r.packet = new(DisconnectPacket)
-	case AUTH:
-		r.packet = new(AuthPacket)
 	}
 	r.nRead = 0
 	r.readVariableHeader()
@@ -184,6 +212,17 @@ func (r *PacketReader) ReadPacket() (Packet, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
+	if !r.skipValidation {
+		if r.err = Validate(r.packet, r.protocol); r.err != nil {
+			return nil, r.err
+		}
+	}
+	if publish, ok := r.packet.(*PublishPacket); ok && r.validatePayloadFormat {
+		if r.err = ValidatePayloadFormat(publish); r.err != nil {
+			return nil, r.err
+		}
+	}
+	r.updateState(r.header.PacketType())
 	return r.packet, nil
 }
 
@@ -194,6 +233,7 @@ func (r *PacketReader) read(b []byte) error {
 		return errInsufficientRemainingBytes
 	}
 	n, err := io.ReadFull(r.r, b)
+	r.nReadTotal += uint64(n)
 	if err != nil {
 		return err
 	}
@@ -210,6 +250,7 @@ func (r *PacketReader) readByte() (b byte, err error) {
 		return 0, err
 	}
 	r.nRead++
+	r.nReadTotal++
 	return
 }
 
@@ -252,6 +293,12 @@ func (r *PacketReader) readBytes() ([]byte, error) {
 	if length == 0 {
 		return nil, nil
 	}
+	if uint32(length) > r.remaining() {
+		return nil, errInsufficientRemainingBytes
+	}
+	if err = r.reserve(int(length)); err != nil {
+		return nil, err
+	}
 	b := make([]byte, length)
 	err = r.read(b)
 	if err != nil {
@@ -289,11 +336,66 @@ func (r *PacketReader) remaining() uint32 {
 	return r.header.remainingLength - r.nRead
 }
 
+// reserve reserves bytes from the MemoryBudget for a buffer of the packet that
+// is being read.
+func (r *PacketReader) reserve(bytes int) error {
+	if r.budget == nil {
+		return nil
+	}
+	if !r.budget.reserve(uint64(bytes)) {
+		return errMemoryBudgetExceeded
+	}
+	r.reserved += uint64(bytes)
+	return nil
+}
+
+// releaseBudget releases the bytes that were reserved for the packet.
+func (r *PacketReader) releaseBudget() {
+	r.budget.release(r.reserved)
+	r.reserved = 0
+}
+
+// readRemainingChunkSize is the size of the first buffer that readRemaining
+// allocates for large remaining lengths.
+const readRemainingChunkSize = 64 * 1024
+
+// readRemaining reads the remaining bytes of the packet. Large remaining
+// lengths are read into a buffer that grows as data arrives, so that a peer can
+// not make the reader allocate much more memory than it actually sends.
 func (r *PacketReader) readRemaining() ([]byte, error) {
-	b := make([]byte, int(r.remaining()))
-	err := r.read(b)
-	if err != nil {
+	remaining := int(r.remaining())
+	if remaining <= readRemainingChunkSize {
+		if err := r.reserve(remaining); err != nil {
+			return nil, err
+		}
+		b := make([]byte, remaining)
+		err := r.read(b)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	if err := r.reserve(readRemainingChunkSize); err != nil {
 		return nil, err
 	}
+	b := make([]byte, 0, readRemainingChunkSize)
+	for len(b) < remaining {
+		if len(b) == cap(b) {
+			if err := r.reserve(cap(b)); err != nil {
+				return nil, err
+			}
+			grown := make([]byte, len(b), 2*cap(b))
+			copy(grown, b)
+			b = grown
+		}
+		chunk := b[len(b):cap(b)]
+		if len(chunk) > remaining-len(b) {
+			chunk = chunk[:remaining-len(b)]
+		}
+		if err := r.read(chunk); err != nil {
+			return nil, err
+		}
+		b = b[:len(b)+len(chunk)]
+	}
 	return b, nil
 }
@@ -33,8 +33,11 @@ func TestReadWrite(t *testing.T) {
 		{&PubrelPacket{}},
 		{&PubcompPacket{}},
 		{&SubscribePacket{
+			SubscribeHeader: SubscribeHeader{
+				PacketIdentifier: 1,
+			},
 			SubscribePayload: []Subscription{
-				{[]byte("foo"), QoS2},
+				{TopicFilter: []byte("foo"), QoS: QoS2},
 			},
 		}},
 		{&SubackPacket{
@@ -43,6 +46,9 @@ func TestReadWrite(t *testing.T) {
 			},
 		}},
 		{&UnsubscribePacket{
+			UnsubscribeHeader: UnsubscribeHeader{
+				PacketIdentifier: 1,
+			},
 			UnsubscribePayload: []TopicFilter{
 				[]byte("foo"),
 			},
@@ -0,0 +1,136 @@
+package mqtt
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+// ServerAddress is the address of another server, as used in the Server
+// Reference property.
+type ServerAddress struct {
+	Host string
+	Port string // empty if the reference does not have a port.
+}
+
+// Address returns the host:port address of the server. If the reference does
+// not have a port, the default port is used.
+func (r ServerAddress) Address(defaultPort string) string {
+	port := r.Port
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(r.Host, port)
+}
+
+func (r ServerAddress) String() string {
+	if r.Port == "" {
+		if strings.IndexByte(r.Host, ':') >= 0 {
+			return "[" + r.Host + "]"
+		}
+		return r.Host
+	}
+	return net.JoinHostPort(r.Host, r.Port)
+}
+
+var errInvalidServerReference = NewReasonCodeError(ProtocolError, "mqtt: invalid server reference")
+
+// ParseServerReference parses the value of a Server Reference property, which
+// is a space separated list of references in the form host or host:port.
+// IPv6 addresses must be enclosed in square brackets.
+func ParseServerReference(value []byte) ([]ServerAddress, error) {
+	var references []ServerAddress
+	for _, field := range strings.Fields(string(value)) {
+		var reference ServerAddress
+		switch {
+		case strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]"):
+			reference.Host = field[1 : len(field)-1]
+		case strings.Count(field, ":") == 0:
+			reference.Host = field
+		default:
+			var err error
+			if reference.Host, reference.Port, err = net.SplitHostPort(field); err != nil || reference.Port == "" {
+				return nil, errInvalidServerReference
+			}
+		}
+		if reference.Host == "" {
+			return nil, errInvalidServerReference
+		}
+		references = append(references, reference)
+	}
+	if len(references) == 0 {
+		return nil, errInvalidServerReference
+	}
+	return references, nil
+}
+
+func formatServerReference(references []ServerAddress) []byte {
+	var buf bytes.Buffer
+	for i, reference := range references {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(reference.String())
+	}
+	return buf.Bytes()
+}
+
+func redirectReasonCode(permanent bool) ReasonCode {
+	if permanent {
+		return ServerMoved
+	}
+	return UseAnotherServer
+}
+
+// Redirect returns a ConnackPacket that redirects the client to other servers.
+// If permanent is true, the reason code is ServerMoved, otherwise it is
+// UseAnotherServer.
+func (p *ConnectPacket) Redirect(permanent bool, references ...ServerAddress) *ConnackPacket {
+	connack := p.Connack()
+	connack.ReasonCode = redirectReasonCode(permanent)
+	connack.Properties = Properties{{Identifier: ServerReference, BytesValue: formatServerReference(references)}}
+	return connack
+}
+
+// RedirectDisconnect returns a DisconnectPacket that redirects the client to
+// other servers. If permanent is true, the reason code is ServerMoved,
+// otherwise it is UseAnotherServer.
+func RedirectDisconnect(permanent bool, references ...ServerAddress) *DisconnectPacket {
+	return &DisconnectPacket{
+		DisconnectHeader: DisconnectHeader{ReasonCode: redirectReasonCode(permanent)},
+		Properties:       Properties{{Identifier: ServerReference, BytesValue: formatServerReference(references)}},
+	}
+}
+
+// Redirection returns the server references of a CONNACK or DISCONNECT packet
+// that redirects the client to other servers, and whether the redirection is
+// permanent. It returns false if the packet is not a redirection, or if it does
+// not contain a valid Server Reference property.
+func Redirection(packet Packet) (references []ServerAddress, permanent bool, ok bool) {
+	var (
+		reasonCode ReasonCode
+		properties Properties
+	)
+	switch packet := packet.(type) {
+	case *ConnackPacket:
+		reasonCode, properties = packet.ReasonCode, packet.Properties
+	case *DisconnectPacket:
+		reasonCode, properties = packet.ReasonCode, packet.Properties
+	default:
+		return nil, false, false
+	}
+	if reasonCode != UseAnotherServer && reasonCode != ServerMoved {
+		return nil, false, false
+	}
+	for _, property := range properties {
+		if property.Identifier != ServerReference {
+			continue
+		}
+		references, err := ParseServerReference(property.BytesValue)
+		if err != nil {
+			return nil, false, false
+		}
+		return references, reasonCode == ServerMoved, true
+	}
+	return nil, false, false
+}
@@ -0,0 +1,72 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServerReference(t *testing.T) {
+	for _, tt := range []struct {
+		value      string
+		references []ServerAddress
+		valid      bool
+	}{
+		{"example.com", []ServerAddress{{Host: "example.com"}}, true},
+		{"example.com:1884", []ServerAddress{{Host: "example.com", Port: "1884"}}, true},
+		{"a.example.com:1884  b.example.com", []ServerAddress{{Host: "a.example.com", Port: "1884"}, {Host: "b.example.com"}}, true},
+		{"[::1]", []ServerAddress{{Host: "::1"}}, true},
+		{"[::1]:1884", []ServerAddress{{Host: "::1", Port: "1884"}}, true},
+		{"", nil, false},
+		{"example.com:", nil, false},
+		{":1884", nil, false},
+		{"::1", nil, false},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			assert := assert.New(t)
+			references, err := ParseServerReference([]byte(tt.value))
+			if !tt.valid {
+				assert.Equal(errInvalidServerReference, err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tt.references, references)
+		})
+	}
+}
+
+func TestServerAddress(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("example.com:1883", ServerAddress{Host: "example.com"}.Address("1883"))
+	assert.Equal("example.com:1884", ServerAddress{Host: "example.com", Port: "1884"}.Address("1883"))
+	assert.Equal("[::1]:1883", ServerAddress{Host: "::1"}.Address("1883"))
+	assert.Equal("[::1]", ServerAddress{Host: "::1"}.String())
+}
+
+func TestRedirection(t *testing.T) {
+	assert := assert.New(t)
+
+	references := []ServerAddress{{Host: "a.example.com", Port: "1884"}, {Host: "::1"}}
+
+	connack := (&ConnectPacket{}).Redirect(false, references...)
+	assert.Equal(UseAnotherServer, connack.ReasonCode)
+	parsed, permanent, ok := Redirection(connack)
+	assert.True(ok)
+	assert.False(permanent)
+	assert.Equal(references, parsed)
+
+	disconnect := RedirectDisconnect(true, references...)
+	assert.Equal(ServerMoved, disconnect.ReasonCode)
+	parsed, permanent, ok = Redirection(disconnect)
+	assert.True(ok)
+	assert.True(permanent)
+	assert.Equal(references, parsed)
+
+	_, _, ok = Redirection(&ConnackPacket{})
+	assert.False(ok)
+	_, _, ok = Redirection(&DisconnectPacket{DisconnectHeader: DisconnectHeader{ReasonCode: ServerMoved}})
+	assert.False(ok)
+	_, _, ok = Redirection(&PingrespPacket{})
+	assert.False(ok)
+}
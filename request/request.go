@@ -0,0 +1,221 @@
+// Package request implements the MQTT 5 request/response pattern.
+//
+// A requester publishes a request with a Response Topic property and a
+// Correlation Data property. The responder publishes its response to the
+// Response Topic, with the same Correlation Data, so that the requester can
+// match the response to the request.
+package request // import "htdvisser.dev/mqtt/request"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+var (
+	// ErrTimeout is returned when no response was received in time.
+	ErrTimeout = errors.New("request: timeout")
+
+	errNoResponseTopic    = errors.New("request: no response topic")
+	errNoPacketIdentifier = errors.New("request: no packet identifier for QoS 1 or QoS 2 response")
+)
+
+func getProperty(properties mqtt.Properties, identifier mqtt.PropertyIdentifier) ([]byte, bool) {
+	for _, property := range properties {
+		if property.Identifier == identifier {
+			return property.BytesValue, true
+		}
+	}
+	return nil, false
+}
+
+func setProperty(properties mqtt.Properties, identifier mqtt.PropertyIdentifier, value []byte) mqtt.Properties {
+	for i, property := range properties {
+		if property.Identifier == identifier {
+			properties[i].BytesValue = value
+			return properties
+		}
+	}
+	return append(properties, mqtt.Property{Identifier: identifier, BytesValue: value})
+}
+
+// ResponseTopic returns the response topic for a client. If the CONNACK
+// packet contains a Response Information property, the response topic is
+// that value followed by a slash and the suffix. Otherwise, the response topic
+// is the fallback followed by a slash and the suffix.
+func ResponseTopic(connack *mqtt.ConnackPacket, fallback, suffix string) []byte {
+	prefix := []byte(fallback)
+	if connack != nil {
+		if responseInformation, ok := getProperty(connack.Properties, mqtt.ResponseInformation); ok && len(responseInformation) > 0 {
+			prefix = responseInformation
+		}
+	}
+	topic := make([]byte, 0, len(prefix)+1+len(suffix))
+	topic = append(topic, prefix...)
+	if len(topic) > 0 && topic[len(topic)-1] != '/' {
+		topic = append(topic, '/')
+	}
+	return append(topic, suffix...)
+}
+
+// WriteFunc writes a packet to the server.
+type WriteFunc func(ctx context.Context, packet mqtt.Packet) error
+
+// Requester publishes requests and matches their responses. A Requester is safe
+// for concurrent use.
+type Requester struct {
+	// Timeout is the maximum time to wait for a response. If zero, the request
+	// waits until the context is done.
+	Timeout time.Duration
+
+	write         WriteFunc
+	responseTopic []byte
+	prefix        [8]byte
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[string]chan *mqtt.PublishPacket
+}
+
+// NewRequester returns a new Requester that writes packets with the given
+// function and that receives responses on the given response topic.
+func NewRequester(write WriteFunc, responseTopic []byte) (*Requester, error) {
+	r := &Requester{
+		write:         write,
+		responseTopic: responseTopic,
+		pending:       make(map[string]chan *mqtt.PublishPacket),
+	}
+	if _, err := rand.Read(r.prefix[:]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ResponseTopic returns the response topic of the requester.
+func (r *Requester) ResponseTopic() []byte { return r.responseTopic }
+
+// Subscribe subscribes to the response topic. The caller is responsible for
+// handling the SUBACK packet.
+func (r *Requester) Subscribe(ctx context.Context, packetIdentifier uint16) error {
+	subscribe := &mqtt.SubscribePacket{
+		SubscribeHeader: mqtt.SubscribeHeader{PacketIdentifier: packetIdentifier},
+		SubscribePayload: []mqtt.Subscription{{
+			TopicFilter: mqtt.TopicFilter(r.responseTopic),
+			QoS:         mqtt.QoS1,
+		}},
+	}
+	return r.write(ctx, subscribe)
+}
+
+func (r *Requester) correlationData() []byte {
+	r.mu.Lock()
+	r.next++
+	next := r.next
+	r.mu.Unlock()
+	correlationData := make([]byte, 16)
+	copy(correlationData, r.prefix[:])
+	binary.BigEndian.PutUint64(correlationData[8:], next)
+	return correlationData
+}
+
+// Request publishes the request with Response Topic and Correlation Data
+// properties, and waits for the response. The request must not be modified
+// while the request is in progress.
+func (r *Requester) Request(ctx context.Context, request *mqtt.PublishPacket) (*mqtt.PublishPacket, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	correlationData := r.correlationData()
+	request.Properties = setProperty(request.Properties, mqtt.ResponseTopic, r.responseTopic)
+	request.Properties = setProperty(request.Properties, mqtt.CorrelationData, correlationData)
+
+	response := make(chan *mqtt.PublishPacket, 1)
+	r.mu.Lock()
+	r.pending[string(correlationData)] = response
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, string(correlationData))
+		r.mu.Unlock()
+	}()
+
+	if err := r.write(ctx, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case publish := <-response:
+		return publish, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// HandleResponse delivers a received PUBLISH packet to the pending request with
+// the same Correlation Data. It returns false if the packet is not a response
+// to a pending request. The topic name is not compared, since it is empty if
+// the response was sent with a Topic Alias.
+func (r *Requester) HandleResponse(publish *mqtt.PublishPacket) bool {
+	correlationData, ok := getProperty(publish.Properties, mqtt.CorrelationData)
+	if !ok {
+		return false
+	}
+	r.mu.Lock()
+	response, ok := r.pending[string(correlationData)]
+	delete(r.pending, string(correlationData))
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+	response <- publish
+	return true
+}
+
+// IsRequest returns whether the PUBLISH packet is a request that expects a
+// response.
+func IsRequest(publish *mqtt.PublishPacket) bool {
+	responseTopic, ok := getProperty(publish.Properties, mqtt.ResponseTopic)
+	return ok && len(responseTopic) > 0
+}
+
+// Response returns the PUBLISH packet with the response to the request. The
+// response is published to the Response Topic of the request, with the same
+// Correlation Data and QoS. If the QoS is 1 or 2, packetIdentifier is the
+// packet identifier of the response, otherwise it is ignored. It returns an
+// error if the request does not have a Response Topic, or if a packet
+// identifier is needed but zero.
+func Response(request *mqtt.PublishPacket, packetIdentifier uint16, payload []byte) (*mqtt.PublishPacket, error) {
+	responseTopic, ok := getProperty(request.Properties, mqtt.ResponseTopic)
+	if !ok || len(responseTopic) == 0 {
+		return nil, errNoResponseTopic
+	}
+	response := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: append([]byte(nil), responseTopic...)},
+		PublishPayload: payload,
+	}
+	response.SetQoS(request.QoS())
+	if response.QoS() > mqtt.QoS0 {
+		if packetIdentifier == 0 {
+			return nil, errNoPacketIdentifier
+		}
+		response.PacketIdentifier = packetIdentifier
+	}
+	if correlationData, ok := getProperty(request.Properties, mqtt.CorrelationData); ok {
+		response.Properties = mqtt.Properties{{
+			Identifier: mqtt.CorrelationData,
+			BytesValue: append([]byte(nil), correlationData...),
+		}}
+	}
+	return response, nil
+}
@@ -0,0 +1,175 @@
+package request
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestResponseTopic(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("responses/client", string(ResponseTopic(nil, "responses", "client")))
+	assert.Equal("responses/client", string(ResponseTopic(&mqtt.ConnackPacket{}, "responses/", "client")))
+	assert.Equal("server/assigned/client", string(ResponseTopic(&mqtt.ConnackPacket{
+		Properties: mqtt.Properties{{Identifier: mqtt.ResponseInformation, BytesValue: []byte("server/assigned")}},
+	}, "responses", "client")))
+}
+
+func TestResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Response(&mqtt.PublishPacket{}, 0, nil)
+	assert.Equal(errNoResponseTopic, err)
+
+	request := &mqtt.PublishPacket{
+		PublishHeader: mqtt.PublishHeader{TopicName: []byte("service/time")},
+		Properties: mqtt.Properties{
+			{Identifier: mqtt.ResponseTopic, BytesValue: []byte("responses/client")},
+			{Identifier: mqtt.CorrelationData, BytesValue: []byte{1, 2, 3}},
+		},
+	}
+	request.SetQoS(mqtt.QoS1)
+	assert.True(IsRequest(request))
+
+	_, err = Response(request, 0, []byte("now"))
+	assert.Equal(errNoPacketIdentifier, err)
+
+	response, err := Response(request, 42, []byte("now"))
+	assert.NoError(err)
+	assert.Equal("responses/client", string(response.TopicName))
+	assert.Equal(mqtt.QoS1, response.QoS())
+	assert.Equal(uint16(42), response.PacketIdentifier)
+	assert.Equal([]byte("now"), response.PublishPayload)
+	correlationData, _ := getProperty(response.Properties, mqtt.CorrelationData)
+	assert.Equal([]byte{1, 2, 3}, correlationData)
+}
+
+func TestRequester(t *testing.T) {
+	assert := assert.New(t)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	clientReader, clientWriter := mqtt.NewReader(clientConn), mqtt.NewWriter(clientConn)
+	serverReader, serverWriter := mqtt.NewReader(serverConn), mqtt.NewWriter(serverConn)
+	for _, rw := range []interface{ SetProtocol(byte) }{clientReader, clientWriter, serverReader, serverWriter} {
+		rw.SetProtocol(5)
+	}
+
+	// The server acknowledges the subscription and answers requests, except
+	// requests to service/ignore.
+	go func() {
+		for {
+			packet, err := serverReader.ReadPacket()
+			if err != nil {
+				return
+			}
+			switch packet := packet.(type) {
+			case *mqtt.SubscribePacket:
+				serverWriter.WritePacket(packet.Suback())
+			case *mqtt.PublishPacket:
+				if string(packet.TopicName) == "service/ignore" {
+					continue
+				}
+				response, err := Response(packet, 0, append([]byte("re: "), packet.PublishPayload...))
+				if err != nil {
+					return
+				}
+				serverWriter.WritePacket(response)
+			}
+		}
+	}()
+
+	requester, err := NewRequester(func(ctx context.Context, packet mqtt.Packet) error {
+		return clientWriter.WritePacketContext(ctx, packet)
+	}, ResponseTopic(nil, "responses", "client"))
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+
+	subacks := make(chan *mqtt.SubackPacket, 1)
+	go func() {
+		for {
+			packet, err := clientReader.ReadPacket()
+			if err != nil {
+				return
+			}
+			switch packet := packet.(type) {
+			case *mqtt.SubackPacket:
+				subacks <- packet
+			case *mqtt.PublishPacket:
+				requester.HandleResponse(packet)
+			}
+		}
+	}()
+
+	assert.NoError(requester.Subscribe(context.Background(), 1))
+	<-subacks
+
+	results := make(chan error, 3)
+	for _, payload := range []string{"one", "two", "three"} {
+		payload := payload
+		go func() {
+			response, err := requester.Request(context.Background(), &mqtt.PublishPacket{
+				PublishHeader:  mqtt.PublishHeader{TopicName: []byte("service/echo")},
+				PublishPayload: []byte(payload),
+			})
+			if err == nil {
+				assert.Equal("re: "+payload, string(response.PublishPayload))
+			}
+			results <- err
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(<-results)
+	}
+
+	requester.Timeout = 10 * time.Millisecond
+	_, err = requester.Request(context.Background(), &mqtt.PublishPacket{
+		PublishHeader: mqtt.PublishHeader{TopicName: []byte("service/ignore")},
+	})
+	assert.Equal(ErrTimeout, err)
+
+	assert.False(requester.HandleResponse(&mqtt.PublishPacket{
+		PublishHeader: mqtt.PublishHeader{TopicName: []byte("responses/client")},
+		Properties:    mqtt.Properties{{Identifier: mqtt.CorrelationData, BytesValue: []byte("unknown")}},
+	}))
+}
+
+func TestHandleResponseTopicAlias(t *testing.T) {
+	assert := assert.New(t)
+
+	requests := make(chan *mqtt.PublishPacket, 1)
+	requester, err := NewRequester(func(ctx context.Context, packet mqtt.Packet) error {
+		requests <- packet.(*mqtt.PublishPacket)
+		return nil
+	}, ResponseTopic(nil, "responses", "client"))
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	requester.Timeout = time.Second
+	responses := make(chan error, 1)
+	go func() {
+		_, err := requester.Request(context.Background(), &mqtt.PublishPacket{
+			PublishHeader: mqtt.PublishHeader{TopicName: []byte("service/echo")},
+		})
+		responses <- err
+	}()
+
+	request := <-requests
+	correlationData, _ := getProperty(request.Properties, mqtt.CorrelationData)
+	// A response with a Topic Alias has an empty topic name.
+	assert.True(requester.HandleResponse(&mqtt.PublishPacket{
+		Properties: mqtt.Properties{
+			{Identifier: mqtt.TopicAlias, UintValue: 1},
+			{Identifier: mqtt.CorrelationData, BytesValue: correlationData},
+		},
+	}))
+	assert.NoError(<-responses)
+}
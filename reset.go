@@ -0,0 +1,75 @@
+package mqtt
+
+// Reset resets the ConnectPacket to its zero value, keeping allocated properties.
+func (p *ConnectPacket) Reset() {
+	*p = ConnectPacket{
+		ConnectPayload: ConnectPayload{WillProperties: p.ConnectPayload.WillProperties[:0]},
+		Properties:     p.Properties[:0],
+	}
+}
+
+// Reset resets the ConnackPacket to its zero value, keeping allocated properties.
+func (p *ConnackPacket) Reset() {
+	*p = ConnackPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the PublishPacket to its zero value, keeping allocated properties.
+func (p *PublishPacket) Reset() {
+	*p = PublishPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the PubackPacket to its zero value, keeping allocated properties.
+func (p *PubackPacket) Reset() {
+	*p = PubackPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the PubrecPacket to its zero value, keeping allocated properties.
+func (p *PubrecPacket) Reset() {
+	*p = PubrecPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the PubrelPacket to its zero value, keeping allocated properties.
+func (p *PubrelPacket) Reset() {
+	*p = PubrelPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the PubcompPacket to its zero value, keeping allocated properties.
+func (p *PubcompPacket) Reset() {
+	*p = PubcompPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the SubscribePacket to its zero value, keeping allocated properties and payload.
+func (p *SubscribePacket) Reset() {
+	*p = SubscribePacket{Properties: p.Properties[:0], SubscribePayload: p.SubscribePayload[:0]}
+}
+
+// Reset resets the SubackPacket to its zero value, keeping allocated properties and payload.
+func (p *SubackPacket) Reset() {
+	*p = SubackPacket{Properties: p.Properties[:0], SubackPayload: p.SubackPayload[:0]}
+}
+
+// Reset resets the UnsubscribePacket to its zero value, keeping allocated properties and payload.
+func (p *UnsubscribePacket) Reset() {
+	*p = UnsubscribePacket{Properties: p.Properties[:0], UnsubscribePayload: p.UnsubscribePayload[:0]}
+}
+
+// Reset resets the UnsubackPacket to its zero value, keeping allocated properties and payload.
+func (p *UnsubackPacket) Reset() {
+	*p = UnsubackPacket{Properties: p.Properties[:0], UnsubackPayload: p.UnsubackPayload[:0]}
+}
+
+// Reset resets the PingreqPacket to its zero value.
+func (p *PingreqPacket) Reset() {}
+
+// Reset resets the PingrespPacket to its zero value.
+func (p *PingrespPacket) Reset() {}
+
+// Reset resets the DisconnectPacket to its zero value, keeping allocated properties.
+func (p *DisconnectPacket) Reset() {
+	*p = DisconnectPacket{Properties: p.Properties[:0]}
+}
+
+// Reset resets the AuthPacket to its zero value, keeping allocated properties.
+func (p *AuthPacket) Reset() {
+	*p = AuthPacket{Properties: p.Properties[:0]}
+}
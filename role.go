@@ -0,0 +1,109 @@
+package mqtt
+
+import "fmt"
+
+// Role is the role of the local end of a connection.
+type Role byte
+
+// Role values.
+const (
+	_          Role = iota
+	RoleClient      // The local end is the client, it reads packets sent by the server.
+	RoleServer      // The local end is the server, it reads packets sent by the client.
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleClient:
+		return "client"
+	case RoleServer:
+		return "server"
+	default:
+		return "unknown role"
+	}
+}
+
+// WithRole returns a ReaderOption that configures the role of the local end of
+// the connection. The Reader then rejects packets that are not allowed in the
+// direction of the connection or in the state of the connection, such as a
+// second CONNECT packet, or any packet that a server sends before CONNACK.
+func WithRole(role Role) ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.role = role
+	})
+}
+
+var (
+	errFirstPacketNotConnect = NewReasonCodeError(ProtocolError, "mqtt: the first packet sent by a client must be CONNECT")
+	errDuplicateConnect      = NewReasonCodeError(ProtocolError, "mqtt: a client must not send CONNECT more than once")
+	errPacketBeforeConnack   = NewReasonCodeError(ProtocolError, "mqtt: a server must not send packets other than AUTH before CONNACK")
+	errDuplicateConnack      = NewReasonCodeError(ProtocolError, "mqtt: a server must not send CONNACK more than once")
+	errPacketAfterDisconnect = NewReasonCodeError(ProtocolError, "mqtt: no packets may be sent after DISCONNECT")
+)
+
+// connState tracks the state of a connection, as seen by a Reader.
+type connState struct {
+	connected    bool // CONNECT (server) or CONNACK (client) received.
+	disconnected bool // DISCONNECT received.
+}
+
+// sentBy returns whether packets of the given type may be sent by the remote
+// end of a connection where the local end has the given role.
+func sentBy(t PacketType, role Role, protocol byte) bool {
+	switch t {
+	case PUBLISH, PUBACK, PUBREC, PUBREL, PUBCOMP, AUTH:
+		return true
+	case DISCONNECT:
+		return role == RoleServer || protocol >= 5
+	case CONNECT, SUBSCRIBE, UNSUBSCRIBE, PINGREQ:
+		return role == RoleServer
+	case CONNACK, SUBACK, UNSUBACK, PINGRESP:
+		return role == RoleClient
+	}
+	return false
+}
+
+// checkRole checks whether a packet of the given type is allowed in the
+// direction and state of the connection.
+func (r *PacketReader) checkRole(t PacketType) error {
+	if r.role == 0 {
+		return nil
+	}
+	if r.state.disconnected {
+		return errPacketAfterDisconnect
+	}
+	if !sentBy(t, r.role, r.protocol) {
+		sender := RoleClient
+		if r.role == RoleClient {
+			sender = RoleServer
+		}
+		return NewReasonCodeError(ProtocolError, fmt.Sprintf("mqtt: a %s must not send %s", sender, t))
+	}
+	switch r.role {
+	case RoleServer:
+		if !r.state.connected && t != CONNECT {
+			return errFirstPacketNotConnect
+		}
+		if r.state.connected && t == CONNECT {
+			return errDuplicateConnect
+		}
+	case RoleClient:
+		if !r.state.connected && t != CONNACK && t != AUTH {
+			return errPacketBeforeConnack
+		}
+		if r.state.connected && t == CONNACK {
+			return errDuplicateConnack
+		}
+	}
+	return nil
+}
+
+// updateState updates the state of the connection after reading a packet.
+func (r *PacketReader) updateState(t PacketType) {
+	switch t {
+	case CONNECT, CONNACK:
+		r.state.connected = true
+	case DISCONNECT:
+		r.state.disconnected = true
+	}
+}
@@ -0,0 +1,86 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertReasonCode asserts that err has the reason code.
+func assertReasonCode(t *testing.T, err error, code ReasonCode) {
+	t.Helper()
+	var rcErr interface{ ReasonCode() ReasonCode }
+	if assert.True(t, errors.As(err, &rcErr), "error %v has no reason code", err) {
+		assert.Equal(t, code, rcErr.ReasonCode())
+	}
+}
+
+func TestRole(t *testing.T) {
+	connect := &ConnectPacket{
+		ConnectHeader:  ConnectHeader{ProtocolName: []byte("MQTT"), ProtocolVersion: 5},
+		ConnectPayload: ConnectPayload{ClientIdentifier: []byte("foo")},
+	}
+	publish := &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte("foo")}}
+	subscribe := &SubscribePacket{SubscribeHeader: SubscribeHeader{PacketIdentifier: 1}, SubscribePayload: []Subscription{{TopicFilter: []byte("foo")}}}
+	suback := &SubackPacket{SubackPayload: []ReasonCode{Success}}
+
+	tests := []struct {
+		name    string
+		role    Role
+		packets []Packet
+		err     error // for the last packet, all other packets must be read.
+	}{
+		{"server", RoleServer, []Packet{connect, &AuthPacket{}, publish, subscribe, &PingreqPacket{}, &DisconnectPacket{}}, nil},
+		{"server before CONNECT", RoleServer, []Packet{publish}, errFirstPacketNotConnect},
+		{"server duplicate CONNECT", RoleServer, []Packet{connect, connect}, errDuplicateConnect},
+		{"server receives SUBACK", RoleServer, []Packet{connect, suback}, NewReasonCodeError(ProtocolError, "mqtt: a client must not send SUBACK")},
+		{"server after DISCONNECT", RoleServer, []Packet{connect, &DisconnectPacket{}, publish}, errPacketAfterDisconnect},
+		{"client", RoleClient, []Packet{&AuthPacket{}, &ConnackPacket{}, publish, suback, &PingrespPacket{}, &DisconnectPacket{}}, nil},
+		{"client before CONNACK", RoleClient, []Packet{publish}, errPacketBeforeConnack},
+		{"client duplicate CONNACK", RoleClient, []Packet{&ConnackPacket{}, &ConnackPacket{}}, errDuplicateConnack},
+		{"client receives SUBSCRIBE", RoleClient, []Packet{&ConnackPacket{}, subscribe}, NewReasonCodeError(ProtocolError, "mqtt: a server must not send SUBSCRIBE")},
+		{"no role", 0, []Packet{publish, &ConnackPacket{}, connect, connect}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.SetProtocol(5)
+			for _, packet := range tt.packets {
+				assert.NoError(w.WritePacket(packet))
+			}
+
+			r := NewReader(&buf, WithRole(tt.role))
+			r.SetProtocol(5)
+			for i := range tt.packets {
+				_, err := r.ReadPacket()
+				if i == len(tt.packets)-1 && tt.err != nil {
+					assert.Equal(tt.err, err)
+					assertReasonCode(t, err, ProtocolError)
+				} else if !assert.NoError(err) {
+					return
+				}
+			}
+		})
+	}
+}
+
+func TestRoleDisconnectBeforeMQTT5(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	assert.NoError(w.WritePacket(&ConnackPacket{}))
+	assert.NoError(w.WritePacket(&DisconnectPacket{}))
+
+	r := NewReader(&buf, WithRole(RoleClient))
+	_, err := r.ReadPacket()
+	assert.NoError(err)
+	_, err = r.ReadPacket()
+	assert.Error(err)
+}
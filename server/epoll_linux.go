@@ -0,0 +1,532 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"htdvisser.dev/mqtt"
+)
+
+// EpollServer serves connections with a small pool of goroutines that each
+// wait for events on a set of non-blocking sockets with epoll, and decode the
+// packets with an mqtt.Decoder. Connections do not have their own goroutines,
+// so that an idle connection only costs its socket and a few hundred bytes of
+// memory. In return, the round trip time of a single connection is higher than
+// with a Server, since every wake-up of an event loop is a blocking system call.
+//
+// Connections that do not expose their file descriptor, such as TLS or
+// WebSocket connections, are served with a goroutine per connection, as by a
+// Server.
+type EpollServer struct {
+	Handler Handler
+	// ReaderOptions are the options for the Decoders of the connections. The
+	// EpollServer adds mqtt.WithRole(mqtt.RoleServer).
+	ReaderOptions []mqtt.ReaderOption
+	// Workers is the number of goroutines that serve connections. The default
+	// is runtime.NumCPU().
+	Workers int
+	// MaxPendingBytes is the maximum number of bytes that are buffered for a
+	// connection that can not be written to as fast as packets are written to
+	// it. If the limit would be exceeded, WritePacket returns an error and the
+	// connection is closed. The default is DefaultMaxPendingBytes.
+	MaxPendingBytes int
+
+	startOnce sync.Once
+	startErr  error
+	fallback  Server
+
+	mu        sync.Mutex
+	loops     []*eventLoop
+	next      int
+	listeners map[net.Listener]struct{}
+	closed    bool
+}
+
+func (s *EpollServer) start() error {
+	s.startOnce.Do(func() {
+		s.fallback.Handler, s.fallback.ReaderOptions = s.Handler, s.ReaderOptions
+		workers := s.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU()
+		}
+		for i := 0; i < workers; i++ {
+			loop, err := newEventLoop(s)
+			if err != nil {
+				s.startErr = err
+				return
+			}
+			s.loops = append(s.loops, loop)
+			go loop.run()
+		}
+	})
+	return s.startErr
+}
+
+// Serve accepts connections from the listener and serves them. It returns
+// ErrServerClosed after Close is called, or an error if accepting fails.
+func (s *EpollServer) Serve(lis net.Listener) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
+	for {
+		netConn, err := lis.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, lis)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if err = s.add(netConn); err != nil {
+			netConn.Close()
+		}
+	}
+}
+
+// DefaultMaxPendingBytes is the default maximum number of bytes that an
+// EpollServer buffers for a connection.
+const DefaultMaxPendingBytes = 4 << 20
+
+// add takes the file descriptor of the connection, and adds it to one of the
+// event loops.
+func (s *EpollServer) add(netConn net.Conn) error {
+	sc, ok := netConn.(syscall.Conn)
+	if !ok {
+		go s.fallback.ServeConn(netConn)
+		return nil
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	var dupErr error
+	if err = rawConn.Control(func(sysfd uintptr) {
+		fd, dupErr = syscall.Dup(int(sysfd))
+	}); err != nil {
+		return err
+	}
+	if dupErr != nil {
+		return dupErr
+	}
+	remoteAddr := netConn.RemoteAddr()
+	netConn.Close() // The duplicated file descriptor keeps the socket open.
+	syscall.CloseOnExec(fd)
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		syscall.Close(fd)
+		return ErrServerClosed
+	}
+	loop := s.loops[s.next%len(s.loops)]
+	s.next++
+	s.mu.Unlock()
+
+	maxPending := s.MaxPendingBytes
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPendingBytes
+	}
+	c := &epollConn{
+		loop:       loop,
+		fd:         fd,
+		remoteAddr: remoteAddr,
+		decoder:    mqtt.NewDecoder(append([]mqtt.ReaderOption{mqtt.WithRole(mqtt.RoleServer)}, s.ReaderOptions...)...),
+		maxPending: maxPending,
+		protocol:   mqtt.DefaultProtocolVersion,
+	}
+	return loop.add(c)
+}
+
+// Close closes the listeners, the connections and the event loops of the
+// server.
+func (s *EpollServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	loops := s.loops
+	s.mu.Unlock()
+	for _, loop := range loops {
+		loop.close()
+	}
+	return s.fallback.Close()
+}
+
+const (
+	readEvents  = syscall.EPOLLIN | syscall.EPOLLRDHUP
+	writeEvents = readEvents | syscall.EPOLLOUT
+)
+
+// eventLoop serves the connections that are registered with its epoll
+// instance. Connections are only read from and closed by the event loop, so
+// that the Handler is never called concurrently for the same connection.
+type eventLoop struct {
+	server       *EpollServer
+	epfd         int
+	wakeR, wakeW int
+	buf          []byte
+
+	mu      sync.Mutex
+	conns   map[int]*epollConn
+	closing []*epollConn // Connections that were closed by other goroutines.
+	closed  bool
+	exited  bool
+}
+
+func newEventLoop(s *EpollServer) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var wake [2]int
+	if err = syscall.Pipe2(wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wake[0], &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(wake[0])}); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		return nil, err
+	}
+	return &eventLoop{
+		server: s,
+		epfd:   epfd,
+		wakeR:  wake[0],
+		wakeW:  wake[1],
+		buf:    make([]byte, 64*1024),
+		conns:  make(map[int]*epollConn),
+	}, nil
+}
+
+func (l *eventLoop) add(c *epollConn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		syscall.Close(c.fd)
+		return ErrServerClosed
+	}
+	if err := syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, c.fd, &syscall.EpollEvent{Events: readEvents, Fd: int32(c.fd)}); err != nil {
+		syscall.Close(c.fd)
+		return err
+	}
+	l.conns[c.fd] = c
+	return nil
+}
+
+func (l *eventLoop) modify(fd int, events uint32) error {
+	return syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{Events: events, Fd: int32(fd)})
+}
+
+func (l *eventLoop) remove(fd int) {
+	l.mu.Lock()
+	delete(l.conns, fd)
+	l.mu.Unlock()
+	syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (l *eventLoop) run() {
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(l.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		for _, event := range events[:n] {
+			fd := int(event.Fd)
+			if fd == l.wakeR {
+				if l.wake() {
+					return
+				}
+				continue
+			}
+			l.mu.Lock()
+			c := l.conns[fd]
+			l.mu.Unlock()
+			if c == nil {
+				continue
+			}
+			if event.Events&syscall.EPOLLOUT != 0 {
+				c.flush()
+			}
+			if event.Events&(readEvents|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				c.read(l.buf)
+			}
+		}
+	}
+}
+
+// wake handles a wake-up of the event loop. It finishes closing the
+// connections that were closed by other goroutines, and returns true if the
+// event loop was closed, in which case it releases the file descriptors of the
+// event loop.
+func (l *eventLoop) wake() (exit bool) {
+	var buf [64]byte
+	for {
+		if n, _ := syscall.Read(l.wakeR, buf[:]); n <= 0 {
+			break
+		}
+	}
+	l.mu.Lock()
+	closing, closed := l.closing, l.closed
+	l.closing = nil
+	l.mu.Unlock()
+	for _, c := range closing {
+		c.finishClose()
+	}
+	if !closed {
+		return false
+	}
+	l.mu.Lock()
+	l.exited = true
+	syscall.Close(l.wakeR)
+	syscall.Close(l.wakeW)
+	syscall.Close(l.epfd)
+	l.mu.Unlock()
+	return true
+}
+
+// closeLater makes the event loop finish closing the connection, which was
+// marked as closed by another goroutine.
+func (l *eventLoop) closeLater(c *epollConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closing = append(l.closing, c)
+	if !l.exited {
+		syscall.Write(l.wakeW, []byte{0})
+	}
+}
+
+// close closes the connections of the event loop, and makes run return.
+func (l *eventLoop) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	for _, c := range l.conns {
+		if c.markClosed(nil) {
+			l.closing = append(l.closing, c)
+		}
+	}
+	if !l.exited {
+		syscall.Write(l.wakeW, []byte{0})
+	}
+}
+
+var (
+	errConnClosed     = errors.New("server: connection closed")
+	errTooMuchPending = errors.New("server: too many bytes pending for connection")
+)
+
+// epollConn is a connection of an EpollServer.
+type epollConn struct {
+	loop       *eventLoop
+	fd         int
+	remoteAddr net.Addr
+	decoder    *mqtt.Decoder // Only used by the event loop.
+	maxPending int
+
+	mu       sync.Mutex
+	protocol byte
+	pending  []byte
+	closed   bool
+	closeErr error
+}
+
+// read reads from the socket, and passes the decoded packets to the Handler.
+func (c *epollConn) read(buf []byte) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	n, err := syscall.Read(c.fd, buf)
+	c.mu.Unlock()
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EINTR:
+		return
+	case err != nil:
+		c.close(err)
+		return
+	case n == 0:
+		c.close(io.EOF)
+		return
+	}
+	packets, err := c.decoder.Feed(buf[:n])
+	for _, packet := range packets {
+		if connect, ok := packet.(*mqtt.ConnectPacket); ok {
+			c.mu.Lock()
+			c.protocol = connect.ProtocolVersion
+			c.mu.Unlock()
+		}
+		if err := c.loop.server.Handler.HandlePacket(c, packet); err != nil {
+			c.close(err)
+			return
+		}
+	}
+	if err != nil {
+		c.close(err)
+	}
+}
+
+// WritePacket encodes the packet and writes it to the socket. If the socket is
+// not ready for writing, the rest of the packet is buffered, and written by the
+// event loop when the socket becomes writable.
+//
+// If more than MaxPendingBytes would be buffered, the connection is closed.
+func (c *epollConn) WritePacket(packet mqtt.Packet) error {
+	c.mu.Lock()
+	err := c.writePacket(packet)
+	c.mu.Unlock()
+	if err == errTooMuchPending {
+		c.loop.closeLater(c)
+	}
+	return err
+}
+
+// writePacket writes the packet. It must be called with the lock held.
+func (c *epollConn) writePacket(packet mqtt.Packet) error {
+	if c.closed {
+		return errConnClosed
+	}
+	var buf bytes.Buffer
+	w := mqtt.NewWriter(&buf)
+	w.SetProtocol(c.protocol)
+	if err := w.WritePacket(packet); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	if len(c.pending) == 0 {
+		var err error
+		if b, err = c.write(b); err != nil {
+			return err
+		}
+		if len(b) == 0 {
+			return nil
+		}
+	}
+	if len(c.pending)+len(b) > c.maxPending {
+		c.closed, c.closeErr, c.pending = true, errTooMuchPending, nil
+		return errTooMuchPending
+	}
+	if len(c.pending) > 0 {
+		c.pending = append(c.pending, b...)
+		return nil
+	}
+	c.pending = append(c.pending, b...)
+	return c.loop.modify(c.fd, writeEvents) // The event loop writes the rest.
+}
+
+// write writes b to the socket until it would block, and returns the part of
+// b that was not written. It must be called with the lock held.
+func (c *epollConn) write(b []byte) ([]byte, error) {
+	for len(b) > 0 {
+		n, err := syscall.Write(c.fd, b)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			return b, nil
+		}
+		if err != nil {
+			return b, err
+		}
+		b = b[n:]
+	}
+	return nil, nil
+}
+
+// flush writes buffered data to the socket.
+func (c *epollConn) flush() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	rest, err := c.write(c.pending)
+	if err == nil && len(rest) == 0 {
+		c.pending = nil
+		err = c.loop.modify(c.fd, readEvents)
+	} else {
+		c.pending = append(c.pending[:0], rest...)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.close(err)
+	}
+}
+
+func (c *epollConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+// Close closes the connection. The event loop of the connection closes the
+// socket and calls HandleClose, so that HandleClose is not called while the
+// event loop is in HandlePacket for the same connection.
+func (c *epollConn) Close() error {
+	if c.markClosed(nil) {
+		c.loop.closeLater(c)
+	}
+	return nil
+}
+
+// markClosed marks the connection as closed, so that it is no longer read
+// from or written to. It returns false if the connection was already closed.
+func (c *epollConn) markClosed(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed, c.closeErr, c.pending = true, err, nil
+	return true
+}
+
+// close closes the connection. It must be called by the event loop.
+func (c *epollConn) close(err error) {
+	if c.markClosed(err) {
+		c.finishClose()
+	}
+}
+
+// finishClose closes the socket of a connection that was marked as closed, and
+// calls HandleClose. It must be called by the event loop.
+func (c *epollConn) finishClose() {
+	c.loop.remove(c.fd)
+	syscall.Close(c.fd)
+	c.decoder.Close()
+	c.mu.Lock()
+	err := c.closeErr
+	c.mu.Unlock()
+	if err == io.EOF {
+		err = nil
+	}
+	c.loop.server.Handler.HandleClose(c, err)
+}
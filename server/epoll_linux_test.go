@@ -0,0 +1,35 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestEpollServerMaxPendingBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := &testHandler{closed: make(chan error, 1)}
+	server := &EpollServer{Handler: handler, Workers: 1, MaxPendingBytes: 1024}
+	addr, _ := startServer(t, server)
+	defer server.Close()
+
+	conn, _, writer := connect(t, addr)
+	defer conn.Close()
+
+	// The echo does not fit in the socket buffers, and the client does not
+	// read it.
+	assert.NoError(writer.WritePacket(&mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte("foo")},
+		PublishPayload: bytes.Repeat([]byte("payload"), 1<<20),
+	}))
+	select {
+	case err := <-handler.closed:
+		assert.Equal(errTooMuchPending, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
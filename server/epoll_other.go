@@ -0,0 +1,46 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"net"
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// EpollServer uses epoll on Linux. On other platforms, it serves each
+// connection with its own goroutine, as a Server does.
+type EpollServer struct {
+	Handler Handler
+	// ReaderOptions are the options for the Readers of the connections. The
+	// EpollServer adds mqtt.WithRole(mqtt.RoleServer).
+	ReaderOptions []mqtt.ReaderOption
+	// Workers is ignored on platforms other than Linux.
+	Workers int
+	// MaxPendingBytes is ignored on platforms other than Linux.
+	MaxPendingBytes int
+
+	startOnce sync.Once
+	server    Server
+}
+
+func (s *EpollServer) start() {
+	s.startOnce.Do(func() {
+		s.server.Handler, s.server.ReaderOptions = s.Handler, s.ReaderOptions
+	})
+}
+
+// Serve accepts connections from the listener and serves them. It returns
+// ErrServerClosed after Close is called, or an error if accepting fails.
+func (s *EpollServer) Serve(lis net.Listener) error {
+	s.start()
+	return s.server.Serve(lis)
+}
+
+// Close closes the listeners and the connections of the server.
+func (s *EpollServer) Close() error {
+	s.start()
+	return s.server.Close()
+}
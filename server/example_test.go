@@ -0,0 +1,37 @@
+package server_test
+
+import (
+	"fmt"
+	"net"
+
+	"htdvisser.dev/mqtt"
+	"htdvisser.dev/mqtt/server"
+)
+
+// pingHandler accepts all clients and answers their pings.
+type pingHandler struct{}
+
+func (pingHandler) HandlePacket(conn server.Conn, packet mqtt.Packet) error {
+	switch packet := packet.(type) {
+	case *mqtt.ConnectPacket:
+		return conn.WritePacket(packet.Connack())
+	case *mqtt.PingreqPacket:
+		return conn.WritePacket(packet.Pingresp())
+	case *mqtt.DisconnectPacket:
+		return conn.Close()
+	}
+	return nil
+}
+
+func (pingHandler) HandleClose(conn server.Conn, err error) {}
+
+func ExampleEpollServer() {
+	s := &server.EpollServer{Handler: pingHandler{}}
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	go s.Serve(lis)
+	defer s.Close()
+}
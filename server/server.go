@@ -0,0 +1,172 @@
+// Package server implements the connection handling of MQTT servers.
+//
+// A Server serves each connection with its own goroutine, and works with any
+// net.Listener. On Linux, an EpollServer multiplexes connections over a small
+// pool of goroutines, which makes it possible to hold very large numbers of
+// mostly idle connections. Both servers pass the packets that they read to the
+// same Handler interface, so that a Handler can be served by either of them.
+package server // import "htdvisser.dev/mqtt/server"
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Conn is a connection to an MQTT client.
+type Conn interface {
+	// WritePacket writes a packet to the client. It is safe for concurrent use.
+	// The protocol version for writing is set when the CONNECT packet is read.
+	WritePacket(packet mqtt.Packet) error
+	// RemoteAddr returns the address of the client.
+	RemoteAddr() net.Addr
+	// Close closes the connection.
+	Close() error
+}
+
+// Handler handles the packets that a server reads from its connections.
+type Handler interface {
+	// HandlePacket handles a packet that was read from the connection. If it
+	// returns an error, the connection is closed.
+	//
+	// HandlePacket is called for one packet of a connection at a time, in the
+	// order in which the packets were read. An EpollServer calls HandlePacket
+	// from the goroutine that also serves other connections, so HandlePacket
+	// should not block.
+	HandlePacket(conn Conn, packet mqtt.Packet) error
+	// HandleClose is called once after the connection is closed, with the
+	// error that caused it to close, if any.
+	HandleClose(conn Conn, err error)
+}
+
+// ErrServerClosed is returned by Serve after the server was closed.
+var ErrServerClosed = errors.New("server: server closed")
+
+// Server serves each connection with its own goroutine.
+type Server struct {
+	Handler Handler
+	// ReaderOptions are the options for the Readers of the connections. The
+	// Server adds mqtt.WithRole(mqtt.RoleServer).
+	ReaderOptions []mqtt.ReaderOption
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	closed    bool
+}
+
+// Serve accepts connections from the listener and serves them. It returns
+// ErrServerClosed after Close is called, or an error if accepting fails.
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
+	for {
+		netConn, err := lis.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, lis)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(netConn)
+	}
+}
+
+// ServeConn serves a single connection. It returns after the connection is
+// closed.
+func (s *Server) ServeConn(netConn net.Conn) {
+	c := &conn{Conn: netConn, writer: mqtt.NewWriter(netConn)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		netConn.Close()
+		return
+	}
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	opts := append([]mqtt.ReaderOption{mqtt.WithRole(mqtt.RoleServer)}, s.ReaderOptions...)
+	reader := mqtt.NewReader(netConn, opts...)
+	var err error
+	for {
+		var packet mqtt.Packet
+		if packet, err = reader.ReadPacket(); err != nil {
+			break
+		}
+		if connect, ok := packet.(*mqtt.ConnectPacket); ok {
+			c.writer.SetProtocol(connect.ProtocolVersion)
+		}
+		if err = s.Handler.HandlePacket(c, packet); err != nil {
+			break
+		}
+	}
+	if err == io.EOF || c.isClosed() {
+		err = nil
+	}
+	c.Close()
+
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.Handler.HandleClose(c, err)
+}
+
+// Close closes the listeners and the connections of the server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return nil
+}
+
+// conn is a connection of a Server.
+type conn struct {
+	net.Conn
+	writer *mqtt.PacketWriter
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Close closes the connection. Errors that the reader of the connection returns
+// after the connection is closed are not passed to HandleClose.
+func (c *conn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func (c *conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *conn) WritePacket(packet mqtt.Packet) error {
+	return c.writer.WritePacket(packet)
+}
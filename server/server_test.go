@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+type testHandler struct {
+	closed chan error
+
+	handling   int32 // 1 while HandlePacket runs.
+	concurrent int32 // HandleClose calls while HandlePacket was running.
+}
+
+func (h *testHandler) HandlePacket(conn Conn, packet mqtt.Packet) error {
+	atomic.StoreInt32(&h.handling, 1)
+	defer atomic.StoreInt32(&h.handling, 0)
+	switch packet := packet.(type) {
+	case *mqtt.ConnectPacket:
+		return conn.WritePacket(packet.Connack())
+	case *mqtt.PingreqPacket:
+		return conn.WritePacket(packet.Pingresp())
+	case *mqtt.PublishPacket:
+		return conn.WritePacket(packet)
+	case *mqtt.DisconnectPacket:
+		return conn.Close()
+	}
+	return nil
+}
+
+func (h *testHandler) HandleClose(conn Conn, err error) {
+	if atomic.LoadInt32(&h.handling) != 0 {
+		atomic.AddInt32(&h.concurrent, 1)
+	}
+	select {
+	case h.closed <- err:
+	default:
+	}
+}
+
+type testServer interface {
+	Serve(lis net.Listener) error
+	Close() error
+}
+
+var engines = []struct {
+	name      string
+	newServer func(handler Handler) testServer
+}{
+	{"goroutine", func(handler Handler) testServer { return &Server{Handler: handler} }},
+	{"epoll", func(handler Handler) testServer { return &EpollServer{Handler: handler, Workers: 2} }},
+}
+
+func startServer(t testing.TB, server testServer) (addr string, served chan error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served = make(chan error, 1)
+	go func() { served <- server.Serve(lis) }()
+	return lis.Addr().String(), served
+}
+
+func connect(t testing.TB, addr string) (net.Conn, *mqtt.PacketReader, *mqtt.PacketWriter) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, writer := mqtt.NewReader(conn), mqtt.NewWriter(conn)
+	reader.SetProtocol(5)
+	writer.SetProtocol(5)
+	err = writer.WritePacket(&mqtt.ConnectPacket{
+		ConnectHeader:  mqtt.ConnectHeader{ProtocolName: []byte("MQTT"), ProtocolVersion: 5},
+		ConnectPayload: mqtt.ConnectPayload{ClientIdentifier: []byte("client")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := reader.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.PacketType() != mqtt.CONNACK {
+		t.Fatalf("expected CONNACK, got %s", packet.PacketType())
+	}
+	return conn, reader, writer
+}
+
+func TestServer(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			handler := &testHandler{closed: make(chan error, 1)}
+			server := engine.newServer(handler)
+			addr, served := startServer(t, server)
+
+			conn, reader, writer := connect(t, addr)
+
+			// A payload that does not fit in the socket buffers.
+			publish := &mqtt.PublishPacket{
+				PublishHeader:  mqtt.PublishHeader{TopicName: []byte("foo")},
+				Properties:     mqtt.Properties{{Identifier: mqtt.ContentType, BytesValue: []byte("text/plain")}},
+				PublishPayload: bytes.Repeat([]byte("payload"), 1<<20),
+			}
+			assert.NoError(writer.WritePacket(publish))
+			packet, err := reader.ReadPacket()
+			if assert.NoError(err) {
+				assert.Equal(publish, packet)
+			}
+
+			assert.NoError(writer.WritePacket(&mqtt.PingreqPacket{}))
+			packet, err = reader.ReadPacket()
+			if assert.NoError(err) {
+				assert.Equal(mqtt.PINGRESP, packet.PacketType())
+			}
+
+			// The connection is closed by the Handler, but HandleClose is only
+			// called after HandlePacket returns.
+			assert.NoError(writer.WritePacket(&mqtt.DisconnectPacket{}))
+			assert.NoError(<-handler.closed)
+			assert.Equal(int32(0), atomic.LoadInt32(&handler.concurrent))
+			_, err = reader.ReadPacket()
+			assert.Equal(io.EOF, err)
+			conn.Close()
+
+			conn, err = net.Dial("tcp", addr)
+			if assert.NoError(err) {
+				conn.Write([]byte{0x10, 0x00}) // CONNECT without variable header.
+				assert.Error(<-handler.closed)
+				conn.Close()
+			}
+
+			conn, _, _ = connect(t, addr)
+			assert.NoError(server.Close())
+			assert.Equal(ErrServerClosed, <-served)
+			<-handler.closed
+			_, err = conn.Read(make([]byte, 1))
+			assert.Equal(io.EOF, err)
+			conn.Close()
+		})
+	}
+}
+
+// benchmarkConnections is the number of connections that BenchmarkServer
+// opens.
+const benchmarkConnections = 1000
+
+// BenchmarkServer opens many loopback connections, and measures the round trip
+// time of PINGREQ packets on those connections, and the memory and goroutines
+// per connection. The memory includes the client side of the connections.
+func BenchmarkServer(b *testing.B) {
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			server := engine.newServer(&testHandler{})
+			addr, _ := startServer(b, server)
+			defer server.Close()
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			goroutinesBefore := runtime.NumGoroutine()
+
+			type client struct {
+				conn   net.Conn
+				reader *mqtt.PacketReader
+				writer *mqtt.PacketWriter
+			}
+			clients := make([]client, benchmarkConnections)
+			for i := range clients {
+				conn, reader, writer := connect(b, addr)
+				defer conn.Close()
+				clients[i] = client{conn, reader, writer}
+			}
+
+			time.Sleep(100 * time.Millisecond) // Let the server settle.
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			heapPerConn := float64(int64(after.HeapInuse)-int64(before.HeapInuse)) / benchmarkConnections
+			goroutinesPerConn := float64(runtime.NumGoroutine()-goroutinesBefore) / benchmarkConnections
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client := clients[i%len(clients)]
+				if err := client.writer.WritePacket(&mqtt.PingreqPacket{}); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := client.reader.ReadPacket(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(heapPerConn, "heap-B/conn")
+			b.ReportMetric(goroutinesPerConn, "goroutines/conn")
+		})
+	}
+}
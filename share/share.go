@@ -0,0 +1,333 @@
+// Package share implements message distribution for MQTT shared subscriptions.
+//
+// Clients subscribe to a shared subscription with a topic filter of the form
+// $share/{ShareName}/{filter}. All clients that subscribe with the same share
+// name and filter form a group, and each message that matches the filter is
+// delivered to only one member of the group.
+package share // import "htdvisser.dev/mqtt/share"
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Strategy is the strategy that a Group uses to select a member for a message.
+type Strategy int
+
+// Strategy values.
+const (
+	// RoundRobin selects the members in turn.
+	RoundRobin Strategy = iota
+	// Random selects a random member.
+	Random
+	// Sticky keeps selecting the same member until it leaves the group or fails
+	// to accept a message.
+	Sticky
+)
+
+var (
+	errNoMembers                = errors.New("share: no members in group")
+	errNotAMember               = errors.New("share: not a member of the group")
+	errUnknownStrategy          = errors.New("share: unknown strategy")
+	errNoPacketIdentifier       = errors.New("share: no packet identifier for QoS 1 or QoS 2 message")
+	errPacketIdentifierNotFound = mqtt.NewReasonCodeError(mqtt.PacketIdentifierNotFound, "share: packet identifier not found")
+)
+
+// DeliverFunc delivers a message to a member of a group. For QoS 1 and QoS 2
+// messages, it returns the non-zero packet identifier with which the message is
+// sent to the member, so that the member can acknowledge it with Ack. It returns
+// an error without sending the message if the member can not accept the message,
+// for example because it has no packet identifier available, in which case the
+// group selects another member. The DeliverFunc is called while the group is locked,
+// so it should not block, and it must not call methods of the group.
+//
+// The message is shared between members, so the DeliverFunc must not modify it.
+type DeliverFunc func(message *mqtt.PublishPacket) (packetIdentifier uint16, err error)
+
+// pendingMessage is a QoS 1 or QoS 2 message that a member did not acknowledge.
+type pendingMessage struct {
+	packetIdentifier uint16
+	message          *mqtt.PublishPacket
+}
+
+type member struct {
+	id      string
+	deliver DeliverFunc
+	pending []pendingMessage
+}
+
+// Group is a shared subscription group. A Group is safe for concurrent use.
+type Group struct {
+	shareName string
+	filter    mqtt.TopicFilter
+	strategy  Strategy
+
+	mu      sync.Mutex
+	members []*member
+	next    int
+	rand    *rand.Rand
+}
+
+// NewGroup returns a new Group for the given share name and topic filter.
+func NewGroup(shareName string, filter mqtt.TopicFilter, strategy Strategy) *Group {
+	return &Group{
+		shareName: shareName,
+		filter:    filter,
+		strategy:  strategy,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// ShareName returns the share name of the group.
+func (g *Group) ShareName() string { return g.shareName }
+
+// Filter returns the topic filter of the group.
+func (g *Group) Filter() mqtt.TopicFilter { return g.filter }
+
+// Len returns the number of members in the group.
+func (g *Group) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.members)
+}
+
+// Join adds a member to the group. If a member with the same id is already in
+// the group, its DeliverFunc is replaced.
+func (g *Group) Join(id string, deliver DeliverFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if i := g.index(id); i >= 0 {
+		g.members[i].deliver = deliver
+		return
+	}
+	g.members = append(g.members, &member{id: id, deliver: deliver})
+}
+
+// Leave removes a member from the group, and redistributes the QoS 1 and QoS 2
+// messages that it did not acknowledge to the remaining members. It returns the
+// messages that could not be redistributed.
+func (g *Group) Leave(id string) []*mqtt.PublishPacket {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := g.index(id)
+	if i < 0 {
+		return nil
+	}
+	pending := g.members[i].pending
+	g.members = append(g.members[:i], g.members[i+1:]...)
+	switch {
+	case g.next > i:
+		g.next--
+	case g.next >= len(g.members):
+		g.next = 0
+	}
+	var undelivered []*mqtt.PublishPacket
+	for _, pending := range pending {
+		if err := g.dispatch(pending.message); err != nil {
+			undelivered = append(undelivered, pending.message)
+		}
+	}
+	return undelivered
+}
+
+// Dispatch delivers the message to one of the members of the group. QoS 1 and
+// QoS 2 messages are kept until the member acknowledges them with Ack.
+func (g *Group) Dispatch(message *mqtt.PublishPacket) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.dispatch(message)
+}
+
+// Ack acknowledges the message that was delivered to the member with the given
+// packet identifier. QoS 1 messages are acknowledged when the member sends
+// PUBACK, and QoS 2 messages when the member sends PUBREC. It returns an error
+// with reason code PacketIdentifierNotFound if the member has no such message.
+func (g *Group) Ack(id string, packetIdentifier uint16) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := g.index(id)
+	if i < 0 {
+		return errNotAMember
+	}
+	if !g.members[i].ack(packetIdentifier) {
+		return errPacketIdentifierNotFound
+	}
+	return nil
+}
+
+func (m *member) ack(packetIdentifier uint16) bool {
+	for i, pending := range m.pending {
+		if pending.packetIdentifier == packetIdentifier {
+			m.pending = append(m.pending[:i], m.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Group) index(id string) int {
+	for i, m := range g.members {
+		if m.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *Group) dispatch(message *mqtt.PublishPacket) error {
+	if len(g.members) == 0 {
+		return errNoMembers
+	}
+	var err error
+	start := g.start()
+	for attempt := 0; attempt < len(g.members); attempt++ {
+		i := (start + attempt) % len(g.members)
+		m := g.members[i]
+		var packetIdentifier uint16
+		if packetIdentifier, err = m.deliver(message); err != nil {
+			continue
+		}
+		if message.QoS() > mqtt.QoS0 {
+			if packetIdentifier == 0 {
+				// The message can not be acknowledged, so it counts as undelivered.
+				err = errNoPacketIdentifier
+				continue
+			}
+			m.pending = append(m.pending, pendingMessage{packetIdentifier, message})
+		}
+		switch g.strategy {
+		case RoundRobin:
+			g.next = (i + 1) % len(g.members)
+		case Sticky:
+			g.next = i
+		}
+		return nil
+	}
+	return err
+}
+
+func (g *Group) start() int {
+	switch g.strategy {
+	case RoundRobin, Sticky:
+		return g.next
+	case Random:
+		return g.rand.Intn(len(g.members))
+	}
+	panic(errUnknownStrategy)
+}
+
+type groupKey struct {
+	shareName string
+	filter    string
+}
+
+// Dispatcher manages the shared subscription groups of a server. A Dispatcher
+// is safe for concurrent use.
+type Dispatcher struct {
+	strategy Strategy
+
+	mu     sync.RWMutex
+	groups map[groupKey]*Group
+}
+
+// NewDispatcher returns a new Dispatcher that creates groups with the given
+// strategy.
+func NewDispatcher(strategy Strategy) *Dispatcher {
+	return &Dispatcher{
+		strategy: strategy,
+		groups:   make(map[groupKey]*Group),
+	}
+}
+
+// Subscribe adds the member to the group of the shared subscription. It
+// returns an error if the topic filter is not a valid shared subscription.
+func (d *Dispatcher) Subscribe(id string, topicFilter mqtt.TopicFilter, deliver DeliverFunc) (*Group, error) {
+	shareName, filter, err := topicFilter.SplitShared()
+	if err != nil {
+		return nil, err
+	}
+	key := groupKey{string(shareName), string(filter)}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	group, ok := d.groups[key]
+	if !ok {
+		group = NewGroup(key.shareName, mqtt.TopicFilter(key.filter), d.strategy)
+		d.groups[key] = group
+	}
+	group.Join(id, deliver)
+	return group, nil
+}
+
+// Unsubscribe removes the member from the group of the shared subscription,
+// and returns the messages that could not be redistributed. It returns an
+// error if the topic filter is not a valid shared subscription.
+func (d *Dispatcher) Unsubscribe(id string, topicFilter mqtt.TopicFilter) ([]*mqtt.PublishPacket, error) {
+	shareName, filter, err := topicFilter.SplitShared()
+	if err != nil {
+		return nil, err
+	}
+	key := groupKey{string(shareName), string(filter)}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	group, ok := d.groups[key]
+	if !ok {
+		return nil, nil
+	}
+	undelivered := group.Leave(id)
+	if group.Len() == 0 {
+		delete(d.groups, key)
+	}
+	return undelivered, nil
+}
+
+// Disconnect removes the member from all groups, and returns the messages that
+// could not be redistributed.
+func (d *Dispatcher) Disconnect(id string) []*mqtt.PublishPacket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var undelivered []*mqtt.PublishPacket
+	for key, group := range d.groups {
+		undelivered = append(undelivered, group.Leave(id)...)
+		if group.Len() == 0 {
+			delete(d.groups, key)
+		}
+	}
+	return undelivered
+}
+
+// Dispatch delivers the message to one member of each group with a filter that
+// matches the topic name of the message. It returns the number of groups that
+// the message was delivered to.
+func (d *Dispatcher) Dispatch(message *mqtt.PublishPacket) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var n int
+	for _, group := range d.groups {
+		if !group.filter.Match(message.TopicName) {
+			continue
+		}
+		if err := group.Dispatch(message); err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Ack acknowledges the message that was delivered to the member with the given
+// packet identifier, through any of its shared subscriptions. It returns an
+// error with reason code PacketIdentifierNotFound if the member has no such
+// message.
+func (d *Dispatcher) Ack(id string, packetIdentifier uint16) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, group := range d.groups {
+		if group.Ack(id, packetIdentifier) == nil {
+			return nil
+		}
+	}
+	return errPacketIdentifierNotFound
+}
@@ -0,0 +1,175 @@
+package share
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+type testMember struct {
+	id       string
+	messages []*mqtt.PublishPacket
+	err      error
+}
+
+// deliver delivers the message with the packet identifier len(m.messages).
+func (m *testMember) deliver(message *mqtt.PublishPacket) (uint16, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.messages = append(m.messages, message)
+	return uint16(len(m.messages)), nil
+}
+
+func assertReasonCode(t *testing.T, err error, code mqtt.ReasonCode) {
+	t.Helper()
+	var rcErr interface{ ReasonCode() mqtt.ReasonCode }
+	if assert.True(t, errors.As(err, &rcErr), "error %v has no reason code", err) {
+		assert.Equal(t, code, rcErr.ReasonCode())
+	}
+}
+
+func testMessage(qos mqtt.QoS) *mqtt.PublishPacket {
+	message := &mqtt.PublishPacket{PublishHeader: mqtt.PublishHeader{TopicName: []byte("foo/bar")}}
+	message.SetQoS(qos)
+	return message
+}
+
+func newTestGroup(strategy Strategy, ids ...string) (*Group, []*testMember) {
+	group := NewGroup("group", mqtt.TopicFilter("foo/+"), strategy)
+	members := make([]*testMember, len(ids))
+	for i, id := range ids {
+		members[i] = &testMember{id: id}
+		group.Join(id, members[i].deliver)
+	}
+	return group, members
+}
+
+func TestRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+
+	group, members := newTestGroup(RoundRobin, "a", "b", "c")
+	for i := 0; i < 6; i++ {
+		assert.NoError(group.Dispatch(testMessage(mqtt.QoS0)))
+	}
+	for _, member := range members {
+		assert.Len(member.messages, 2, member.id)
+	}
+
+	// Members that fail to accept a message are skipped.
+	members[1].err = errors.New("queue full")
+	for i := 0; i < 4; i++ {
+		assert.NoError(group.Dispatch(testMessage(mqtt.QoS0)))
+	}
+	assert.Len(members[0].messages, 4)
+	assert.Len(members[1].messages, 2)
+	assert.Len(members[2].messages, 4)
+}
+
+func TestRandom(t *testing.T) {
+	assert := assert.New(t)
+
+	group, members := newTestGroup(Random, "a", "b")
+	for i := 0; i < 100; i++ {
+		assert.NoError(group.Dispatch(testMessage(mqtt.QoS0)))
+	}
+	assert.Equal(100, len(members[0].messages)+len(members[1].messages))
+	assert.NotEmpty(members[0].messages)
+	assert.NotEmpty(members[1].messages)
+}
+
+func TestSticky(t *testing.T) {
+	assert := assert.New(t)
+
+	group, members := newTestGroup(Sticky, "a", "b", "c")
+	for i := 0; i < 3; i++ {
+		assert.NoError(group.Dispatch(testMessage(mqtt.QoS0)))
+	}
+	assert.Len(members[0].messages, 3)
+
+	group.Leave("a")
+	for i := 0; i < 3; i++ {
+		assert.NoError(group.Dispatch(testMessage(mqtt.QoS0)))
+	}
+	assert.Len(members[1].messages, 3)
+	assert.Empty(members[2].messages)
+}
+
+func TestLeaveRedistributes(t *testing.T) {
+	assert := assert.New(t)
+
+	group, members := newTestGroup(RoundRobin, "a", "b")
+
+	qos0, qos1, qos2 := testMessage(mqtt.QoS0), testMessage(mqtt.QoS1), testMessage(mqtt.QoS2)
+	acked := testMessage(mqtt.QoS1)
+	for _, message := range []*mqtt.PublishPacket{qos0, acked, qos1, testMessage(mqtt.QoS0), qos2} {
+		assert.NoError(group.Dispatch(message))
+	}
+	assert.Equal([]*mqtt.PublishPacket{qos0, qos1, qos2}, members[0].messages)
+	assert.NoError(group.Ack("b", 1))
+	assertReasonCode(t, group.Ack("b", 1), mqtt.PacketIdentifierNotFound)
+
+	assert.Empty(group.Leave("a"))
+	assert.Equal([]*mqtt.PublishPacket{acked, members[1].messages[1], qos1, qos2}, members[1].messages)
+
+	assert.Equal([]*mqtt.PublishPacket{qos1, qos2}, group.Leave("b"))
+	assert.Equal(errNoMembers, group.Dispatch(qos0))
+	assert.Equal(errNotAMember, group.Ack("b", 3))
+}
+
+func TestDispatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	dispatcher := NewDispatcher(RoundRobin)
+
+	a, b, c := &testMember{id: "a"}, &testMember{id: "b"}, &testMember{id: "c"}
+
+	_, err := dispatcher.Subscribe("a", mqtt.TopicFilter("foo/+"), a.deliver)
+	assert.Error(err)
+
+	group, err := dispatcher.Subscribe("a", mqtt.TopicFilter("$share/group/foo/+"), a.deliver)
+	assert.NoError(err)
+	assert.Equal("group", group.ShareName())
+	assert.Equal(mqtt.TopicFilter("foo/+"), group.Filter())
+	_, err = dispatcher.Subscribe("b", mqtt.TopicFilter("$share/group/foo/+"), b.deliver)
+	assert.NoError(err)
+	_, err = dispatcher.Subscribe("c", mqtt.TopicFilter("$share/other/foo/#"), c.deliver)
+	assert.NoError(err)
+
+	message := testMessage(mqtt.QoS1)
+	assert.Equal(2, dispatcher.Dispatch(message))
+	assert.Equal(2, dispatcher.Dispatch(testMessage(mqtt.QoS1)))
+	assert.Len(a.messages, 1)
+	assert.Len(b.messages, 1)
+	assert.Len(c.messages, 2)
+
+	assert.NoError(dispatcher.Ack("a", 1))
+	assertReasonCode(t, dispatcher.Ack("a", 1), mqtt.PacketIdentifierNotFound)
+	assertReasonCode(t, dispatcher.Ack("c", 3), mqtt.PacketIdentifierNotFound)
+
+	undelivered, err := dispatcher.Unsubscribe("b", mqtt.TopicFilter("$share/group/foo/+"))
+	assert.NoError(err)
+	assert.Empty(undelivered)
+	assert.Len(a.messages, 2)
+
+	assert.Len(dispatcher.Disconnect("c"), 2)
+	assert.Equal(1, dispatcher.Dispatch(testMessage(mqtt.QoS0)))
+}
+
+func TestDispatchWithoutPacketIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	group := NewGroup("group", mqtt.TopicFilter("foo/+"), RoundRobin)
+	group.Join("a", func(*mqtt.PublishPacket) (uint16, error) { return 0, nil })
+	assert.NoError(group.Dispatch(testMessage(mqtt.QoS0)))
+	assert.Equal(errNoPacketIdentifier, group.Dispatch(testMessage(mqtt.QoS1)))
+
+	// The next member gets the message.
+	b := &testMember{id: "b"}
+	group.Join(b.id, b.deliver)
+	assert.NoError(group.Dispatch(testMessage(mqtt.QoS1)))
+	assert.Len(b.messages, 1)
+	assert.Len(group.Leave(b.id), 1)
+}
@@ -0,0 +1,121 @@
+// Package store implements storage for MQTT messages that a server keeps for
+// later delivery, such as the messages queued for offline sessions and
+// retained messages.
+//
+// Stored messages honor the Message Expiry Interval property: expired
+// messages are dropped, and messages that are forwarded have their Message
+// Expiry Interval reduced by the time that they were stored.
+package store // import "htdvisser.dev/mqtt/store"
+
+import (
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Clock returns the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the Clock that returns the system time.
+var SystemClock Clock = systemClock{}
+
+// ManualClock is a Clock that is set manually. It is intended for tests.
+// A ManualClock is safe for concurrent use.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a new ManualClock set to the given time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now implements Clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set sets the time of the clock.
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	c.now = now
+	c.mu.Unlock()
+}
+
+// Advance advances the time of the clock.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// Message is a PUBLISH packet together with the time at which it was received.
+type Message struct {
+	*mqtt.PublishPacket
+	ReceivedAt time.Time
+}
+
+// Stamp returns a Message for the PUBLISH packet, received at the current time
+// of the clock.
+func Stamp(clock Clock, publish *mqtt.PublishPacket) *Message {
+	return &Message{PublishPacket: publish, ReceivedAt: clock.Now()}
+}
+
+// ExpiryInterval returns the Message Expiry Interval of the message, or false
+// if the message does not expire.
+func (m *Message) ExpiryInterval() (time.Duration, bool) {
+	for _, property := range m.Properties {
+		if property.Identifier == mqtt.MessageExpiryInterval {
+			return time.Duration(property.UintValue) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// Remaining returns the remaining time until the message expires, or false if
+// the message does not expire. The remaining time is zero or negative if the
+// message expired.
+func (m *Message) Remaining(now time.Time) (time.Duration, bool) {
+	interval, ok := m.ExpiryInterval()
+	if !ok {
+		return 0, false
+	}
+	return interval - now.Sub(m.ReceivedAt), true
+}
+
+// Expired returns whether the message expired.
+func (m *Message) Expired(now time.Time) bool {
+	remaining, ok := m.Remaining(now)
+	return ok && remaining <= 0
+}
+
+// Forward returns a copy of the PUBLISH packet with the Message Expiry Interval
+// set to the remaining interval, rounded up to whole seconds. It returns false
+// if the message expired.
+func (m *Message) Forward(now time.Time) (*mqtt.PublishPacket, bool) {
+	remaining, ok := m.Remaining(now)
+	if !ok {
+		return m.Clone(), true
+	}
+	if remaining <= 0 {
+		return nil, false
+	}
+	publish := m.Clone()
+	seconds := uint64((remaining + time.Second - 1) / time.Second)
+	for i, property := range publish.Properties {
+		if property.Identifier == mqtt.MessageExpiryInterval {
+			publish.Properties[i].UintValue = seconds
+		}
+	}
+	return publish, true
+}
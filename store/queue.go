@@ -0,0 +1,92 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"htdvisser.dev/mqtt"
+)
+
+var errQueueFull = mqtt.NewReasonCodeError(mqtt.QuotaExceeded, "store: queue full")
+
+// Queue is a FIFO queue of messages for an offline session. Expired messages
+// are dropped from the queue. A Queue is safe for concurrent use.
+type Queue struct {
+	clock Clock
+	limit int
+
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewQueue returns a new Queue that holds at most limit messages. If limit is
+// zero, the size of the queue is not limited. If clock is nil, SystemClock is
+// used.
+func NewQueue(clock Clock, limit int) *Queue {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Queue{clock: clock, limit: limit}
+}
+
+// Push adds a copy of the PUBLISH packet to the queue. If the queue is full,
+// expired messages are dropped. If the queue is still full, an error is
+// returned.
+//
+// Like Retained.Set, Push rejects PUBLISH packets with a PublishPayloadReader.
+func (q *Queue) Push(publish *mqtt.PublishPacket) error {
+	if publish.PublishPayloadReader != nil {
+		return errStreamedPayload
+	}
+	message := Stamp(q.clock, publish.Clone())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.limit > 0 && len(q.messages) >= q.limit {
+		q.dropExpired(message.ReceivedAt)
+		if len(q.messages) >= q.limit {
+			return errQueueFull
+		}
+	}
+	q.messages = append(q.messages, message)
+	return nil
+}
+
+// Pop removes the first message that did not expire from the queue, and
+// returns it for forwarding (see Message.Forward). It returns false if the
+// queue is empty.
+func (q *Queue) Pop() (*mqtt.PublishPacket, bool) {
+	now := q.clock.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.messages) > 0 {
+		message := q.messages[0]
+		q.messages[0] = nil
+		q.messages = q.messages[1:]
+		if publish, ok := message.Forward(now); ok {
+			return publish, true
+		}
+	}
+	return nil, false
+}
+
+// Len returns the number of messages in the queue that did not expire.
+func (q *Queue) Len() int {
+	now := q.clock.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dropExpired(now)
+	return len(q.messages)
+}
+
+func (q *Queue) dropExpired(now time.Time) {
+	messages := q.messages[:0]
+	for _, message := range q.messages {
+		if !message.Expired(now) {
+			messages = append(messages, message)
+		}
+	}
+	for i := len(messages); i < len(q.messages); i++ {
+		q.messages[i] = nil
+	}
+	q.messages = messages
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Retained stores the retained messages of a server. Expired messages are
+// dropped. A Retained store is safe for concurrent use.
+type Retained struct {
+	clock Clock
+
+	mu       sync.RWMutex
+	messages map[string]*Message
+}
+
+// NewRetained returns a new Retained store. If clock is nil, SystemClock is
+// used.
+func NewRetained(clock Clock) *Retained {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &Retained{clock: clock, messages: make(map[string]*Message)}
+}
+
+var errStreamedPayload = errors.New("store: can not store a streamed payload")
+
+// Set stores a copy of the PUBLISH packet as the retained message for its topic
+// name. A PUBLISH packet with an empty payload removes the retained message.
+//
+// Streamed payloads are only valid until the next packet is read, so PUBLISH
+// packets with a PublishPayloadReader are rejected. Their payload must be read
+// into PublishPayload first.
+func (s *Retained) Set(publish *mqtt.PublishPacket) error {
+	if publish.PublishPayloadReader != nil {
+		return errStreamedPayload
+	}
+	if len(publish.PublishPayload) == 0 {
+		s.mu.Lock()
+		delete(s.messages, string(publish.TopicName))
+		s.mu.Unlock()
+		return nil
+	}
+	message := Stamp(s.clock, publish.Clone())
+	s.mu.Lock()
+	s.messages[string(publish.TopicName)] = message
+	s.mu.Unlock()
+	return nil
+}
+
+// Get returns the retained message for the topic name, for forwarding (see
+// Message.Forward). It returns false if there is no retained message, or if it
+// expired.
+func (s *Retained) Get(topicName []byte) (*mqtt.PublishPacket, bool) {
+	now := s.clock.Now()
+	s.mu.RLock()
+	message, ok := s.messages[string(topicName)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	publish, ok := message.Forward(now)
+	if !ok {
+		s.drop(message)
+	}
+	return publish, ok
+}
+
+// Match returns the retained messages with topic names that match the topic
+// filter, for forwarding (see Message.Forward), sorted by topic name.
+func (s *Retained) Match(topicFilter mqtt.TopicFilter) []*mqtt.PublishPacket {
+	now := s.clock.Now()
+	var (
+		matches []*mqtt.PublishPacket
+		expired []*Message
+	)
+	s.mu.RLock()
+	for topicName, message := range s.messages {
+		if !topicFilter.Match([]byte(topicName)) {
+			continue
+		}
+		if publish, ok := message.Forward(now); ok {
+			matches = append(matches, publish)
+		} else {
+			expired = append(expired, message)
+		}
+	}
+	s.mu.RUnlock()
+	for _, message := range expired {
+		s.drop(message)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return string(matches[i].TopicName) < string(matches[j].TopicName)
+	})
+	return matches
+}
+
+// Len returns the number of retained messages, including messages that expired
+// but were not yet dropped.
+func (s *Retained) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.messages)
+}
+
+// drop removes the message if it is still the retained message for its topic.
+func (s *Retained) drop(message *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.messages[string(message.TopicName)] == message {
+		delete(s.messages, string(message.TopicName))
+	}
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func testMessage(topicName string, expiryInterval uint64) *mqtt.PublishPacket {
+	publish := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte(topicName)},
+		PublishPayload: []byte("payload"),
+	}
+	if expiryInterval > 0 {
+		publish.Properties = mqtt.Properties{{Identifier: mqtt.MessageExpiryInterval, UintValue: expiryInterval}}
+	}
+	return publish
+}
+
+func expiryInterval(publish *mqtt.PublishPacket) uint64 {
+	for _, property := range publish.Properties {
+		if property.Identifier == mqtt.MessageExpiryInterval {
+			return property.UintValue
+		}
+	}
+	return 0
+}
+
+func TestMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := NewManualClock(time.Unix(1600000000, 0))
+
+	message := Stamp(clock, testMessage("foo", 10))
+	interval, ok := message.ExpiryInterval()
+	assert.True(ok)
+	assert.Equal(10*time.Second, interval)
+
+	clock.Advance(2500 * time.Millisecond)
+	assert.False(message.Expired(clock.Now()))
+	forwarded, ok := message.Forward(clock.Now())
+	assert.True(ok)
+	assert.Equal(uint64(8), expiryInterval(forwarded))
+	assert.Equal(uint64(10), expiryInterval(message.PublishPacket), "the original must not be modified")
+
+	clock.Advance(7500 * time.Millisecond)
+	assert.True(message.Expired(clock.Now()))
+	_, ok = message.Forward(clock.Now())
+	assert.False(ok)
+
+	// Messages without Message Expiry Interval do not expire.
+	message = Stamp(clock, testMessage("foo", 0))
+	clock.Advance(24 * time.Hour)
+	assert.False(message.Expired(clock.Now()))
+	forwarded, ok = message.Forward(clock.Now())
+	assert.True(ok)
+	assert.Empty(forwarded.Properties)
+}
+
+func TestQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	queue := NewQueue(clock, 3)
+
+	assert.NoError(queue.Push(testMessage("a", 5)))
+	assert.NoError(queue.Push(testMessage("b", 0)))
+	assert.NoError(queue.Push(testMessage("c", 20)))
+	assert.Equal(errQueueFull, queue.Push(testMessage("d", 0)))
+
+	// Streamed payloads can not be queued.
+	assert.Equal(errStreamedPayload, NewQueue(clock, 0).Push(&mqtt.PublishPacket{
+		PublishHeader:        mqtt.PublishHeader{TopicName: []byte("e")},
+		PublishPayloadReader: strings.NewReader("e"),
+		PublishPayloadSize:   1,
+	}))
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(2, queue.Len())
+
+	// The expired message made room for a new one.
+	d := testMessage("d", 0)
+	assert.NoError(queue.Push(d))
+
+	// The queue keeps a copy, so changes to the packet do not affect it.
+	d.PublishPayload[0] = 'x'
+
+	publish, ok := queue.Pop()
+	assert.True(ok)
+	assert.Equal("b", string(publish.TopicName))
+
+	clock.Advance(5 * time.Second)
+	publish, ok = queue.Pop()
+	assert.True(ok)
+	assert.Equal("c", string(publish.TopicName))
+	assert.Equal(uint64(5), expiryInterval(publish))
+
+	publish, ok = queue.Pop()
+	assert.True(ok)
+	assert.Equal("d", string(publish.TopicName))
+	assert.Equal("payload", string(publish.PublishPayload))
+
+	_, ok = queue.Pop()
+	assert.False(ok)
+}
+
+func TestRetained(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := NewManualClock(time.Unix(1600000000, 0))
+	retained := NewRetained(clock)
+
+	assert.NoError(retained.Set(testMessage("foo/a", 10)))
+	assert.NoError(retained.Set(testMessage("foo/b", 0)))
+	bar := testMessage("bar", 0)
+	assert.NoError(retained.Set(bar))
+
+	// The store keeps a copy, so changes to the packet do not affect it.
+	bar.PublishPayload[0] = 'x'
+	publish, ok := retained.Get([]byte("bar"))
+	if assert.True(ok) {
+		assert.NotEqual(bar.PublishPayload, publish.PublishPayload)
+	}
+
+	// Streamed payloads can not be retained.
+	assert.Error(retained.Set(&mqtt.PublishPacket{
+		PublishHeader:        mqtt.PublishHeader{TopicName: []byte("baz")},
+		PublishPayloadReader: strings.NewReader("baz"),
+		PublishPayloadSize:   3,
+	}))
+
+	publish, ok = retained.Get([]byte("foo/a"))
+	assert.True(ok)
+	assert.Equal(uint64(10), expiryInterval(publish))
+
+	matches := retained.Match(mqtt.TopicFilter("foo/+"))
+	if assert.Len(matches, 2) {
+		assert.Equal("foo/a", string(matches[0].TopicName))
+		assert.Equal("foo/b", string(matches[1].TopicName))
+	}
+
+	clock.Advance(10 * time.Second)
+	_, ok = retained.Get([]byte("foo/a"))
+	assert.False(ok)
+	assert.Len(retained.Match(mqtt.TopicFilter("#")), 2)
+	assert.Equal(2, retained.Len())
+
+	// An empty payload removes the retained message.
+	assert.NoError(retained.Set(&mqtt.PublishPacket{PublishHeader: mqtt.PublishHeader{TopicName: []byte("bar")}}))
+	_, ok = retained.Get([]byte("bar"))
+	assert.False(ok)
+	assert.Equal(1, retained.Len())
+}
@@ -54,20 +54,69 @@ type TopicFilter []byte
 type Subscription struct {
 	TopicFilter TopicFilter
 	QoS         QoS
+	SubscriptionOptions
+}
+
+// SubscriptionOptions are the subscription options of MQTT 5, other than the
+// maximum QoS. They are not written in earlier protocol versions.
+type SubscriptionOptions byte
+
+// NoLocal returns the No Local bit from the subscription options.
+func (o SubscriptionOptions) NoLocal() bool { return o&0x04 == 0x04 }
+
+// SetNoLocal sets the No Local bit into the subscription options.
+func (o *SubscriptionOptions) SetNoLocal(noLocal bool) {
+	*o &^= 0x04
+	if noLocal {
+		*o |= 0x04
+	}
+}
+
+// RetainAsPublished returns the Retain As Published bit from the subscription
+// options.
+func (o SubscriptionOptions) RetainAsPublished() bool { return o&0x08 == 0x08 }
+
+// SetRetainAsPublished sets the Retain As Published bit into the subscription
+// options.
+func (o *SubscriptionOptions) SetRetainAsPublished(retainAsPublished bool) {
+	*o &^= 0x08
+	if retainAsPublished {
+		*o |= 0x08
+	}
+}
+
+// RetainHandling returns the Retain Handling from the subscription options.
+func (o SubscriptionOptions) RetainHandling() byte { return byte(o >> 4 & 0x03) }
+
+// SetRetainHandling sets the Retain Handling into the subscription options.
+func (o *SubscriptionOptions) SetRetainHandling(retainHandling byte) {
+	*o &^= 0x30
+	*o |= SubscriptionOptions(retainHandling&0x03) << 4
 }
 
 func (r *PacketReader) readSubscribePayload() {
 	packet := r.packet.(*SubscribePacket)
 	for r.remaining() > 0 {
+		if r.err = r.checkSubscriptions(len(packet.SubscribePayload)); r.err != nil {
+			return
+		}
 		var subscription Subscription
 		if subscription.TopicFilter, r.err = r.readBytes(); r.err != nil {
 			return
 		}
+		if r.err = r.checkTopic(subscription.TopicFilter, errTopicFilterTooLong); r.err != nil {
+			return
+		}
 		var b byte
 		if b, r.err = r.readByte(); r.err != nil {
 			return
 		}
-		subscription.QoS = QoS(b)
+		if r.protocol >= 5 {
+			subscription.QoS = QoS(b & 0x03)
+			subscription.SubscriptionOptions = SubscriptionOptions(b &^ 0x03)
+		} else {
+			subscription.QoS = QoS(b)
+		}
 		if r.err = r.validateQoS(subscription.QoS); r.err != nil {
 			return
 		}
@@ -81,7 +130,11 @@ func (w *PacketWriter) writeSubscribePayload() {
 		if w.err = w.writeBytes(subscription.TopicFilter); w.err != nil {
 			return
 		}
-		if w.err = w.writeByte(byte(subscription.QoS) & 0x03); w.err != nil {
+		options := byte(subscription.QoS) & 0x03
+		if w.protocol >= 5 {
+			options |= byte(subscription.SubscriptionOptions) &^ 0x03
+		}
+		if w.err = w.writeByte(options); w.err != nil {
 			return
 		}
 	}
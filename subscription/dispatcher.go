@@ -0,0 +1,76 @@
+package subscription
+
+import (
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Handler handles PUBLISH packets for a subscription.
+type Handler func(publish *mqtt.PublishPacket)
+
+// Dispatcher routes PUBLISH packets that a client receives to the handlers of
+// its subscriptions, based on the Subscription Identifiers in the packets.
+// A Dispatcher is safe for concurrent use.
+type Dispatcher struct {
+	// Fallback handles PUBLISH packets without known Subscription Identifiers.
+	Fallback Handler
+
+	mu       sync.RWMutex
+	next     uint32
+	handlers map[uint32]Handler
+}
+
+// Subscribe assigns a new Subscription Identifier to the SUBSCRIBE packet, and
+// registers the handler for that identifier.
+func (d *Dispatcher) Subscribe(subscribe *mqtt.SubscribePacket, handler Handler) (uint32, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.handlers == nil {
+		d.handlers = make(map[uint32]Handler)
+	}
+	if len(d.handlers) >= MaxIdentifier {
+		return 0, errNoIdentifiers
+	}
+	for {
+		d.next = d.next%MaxIdentifier + 1
+		if _, ok := d.handlers[d.next]; !ok {
+			break
+		}
+	}
+	identifier := d.next
+	d.handlers[identifier] = handler
+	subscribe.Properties = SetIdentifiers(subscribe.Properties, identifier)
+	return identifier, nil
+}
+
+// Unsubscribe removes the handler for the Subscription Identifier.
+func (d *Dispatcher) Unsubscribe(identifier uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.handlers, identifier)
+}
+
+// Dispatch calls the handlers for the Subscription Identifiers of the PUBLISH
+// packet, and returns the number of handlers that were called. If none of the
+// identifiers has a handler, the Fallback handler is called.
+func (d *Dispatcher) Dispatch(publish *mqtt.PublishPacket) int {
+	var handlers []Handler
+	d.mu.RLock()
+	for _, identifier := range Identifiers(publish) {
+		if handler, ok := d.handlers[identifier]; ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	d.mu.RUnlock()
+	if len(handlers) == 0 {
+		if d.Fallback != nil {
+			d.Fallback(publish)
+		}
+		return 0
+	}
+	for _, handler := range handlers {
+		handler(publish)
+	}
+	return len(handlers)
+}
@@ -0,0 +1,141 @@
+package subscription
+
+import (
+	"sync"
+
+	"htdvisser.dev/mqtt"
+)
+
+// Record is a subscription of a client.
+type Record struct {
+	TopicFilter mqtt.TopicFilter
+	QoS         mqtt.QoS
+	Identifier  uint32 // 0 if the subscription has no identifier.
+}
+
+// Set is the set of subscriptions of a single client. A Set is safe for
+// concurrent use.
+type Set struct {
+	mu      sync.RWMutex
+	records []Record
+}
+
+// Subscribe adds the subscriptions of the SUBSCRIBE packet to the set, and
+// returns the records that were added. Subscriptions with the same topic
+// filter as an existing subscription replace that subscription, including its
+// Subscription Identifier.
+func (s *Set) Subscribe(subscribe *mqtt.SubscribePacket) ([]Record, error) {
+	identifier, err := Identifier(subscribe)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, len(subscribe.SubscribePayload))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, subscription := range subscribe.SubscribePayload {
+		record := Record{
+			TopicFilter: append(mqtt.TopicFilter(nil), subscription.TopicFilter...),
+			QoS:         subscription.QoS,
+			Identifier:  identifier,
+		}
+		if j := s.index(record.TopicFilter); j >= 0 {
+			s.records[j] = record
+		} else {
+			s.records = append(s.records, record)
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+// Unsubscribe removes the subscriptions of the UNSUBSCRIBE packet from the set.
+// It returns, for each topic filter, whether a subscription existed.
+func (s *Set) Unsubscribe(unsubscribe *mqtt.UnsubscribePacket) []bool {
+	existed := make([]bool, len(unsubscribe.UnsubscribePayload))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, topicFilter := range unsubscribe.UnsubscribePayload {
+		if j := s.index(topicFilter); j >= 0 {
+			s.records = append(s.records[:j], s.records[j+1:]...)
+			existed[i] = true
+		}
+	}
+	return existed
+}
+
+// Records returns a copy of the records in the set.
+func (s *Set) Records() []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Record(nil), s.records...)
+}
+
+func (s *Set) index(topicFilter mqtt.TopicFilter) int {
+	for i, record := range s.records {
+		if string(record.TopicFilter) == string(topicFilter) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Match returns the maximum QoS and the Subscription Identifiers of the
+// subscriptions that match the topic name. It returns false if no
+// subscription matches. Shared subscriptions ($share/{ShareName}/{filter})
+// match on their filter, so that the QoS and identifiers can be determined for
+// messages that the share package delivers to the client.
+func (s *Set) Match(topicName []byte) (qos mqtt.QoS, identifiers []uint32, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, record := range s.records {
+		filter := record.TopicFilter
+		if filter.IsShared() {
+			var err error
+			if _, filter, err = filter.SplitShared(); err != nil {
+				continue
+			}
+		}
+		if !filter.Match(topicName) {
+			continue
+		}
+		ok = true
+		if record.QoS > qos {
+			qos = record.QoS
+		}
+		if record.Identifier != 0 && !containsIdentifier(identifiers, record.Identifier) {
+			identifiers = append(identifiers, record.Identifier)
+		}
+	}
+	return qos, identifiers, ok
+}
+
+func containsIdentifier(identifiers []uint32, identifier uint32) bool {
+	for _, id := range identifiers {
+		if id == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// Route returns the PUBLISH packet that must be sent to the client for the
+// given message, or false if no subscription matches. The returned packet is a
+// copy of the message, with the QoS reduced to the maximum QoS of the matching
+// subscriptions, and with the Subscription Identifiers of the matching
+// subscriptions.
+func (s *Set) Route(message *mqtt.PublishPacket) (*mqtt.PublishPacket, bool) {
+	qos, identifiers, ok := s.Match(message.TopicName)
+	if !ok {
+		return nil, false
+	}
+	publish := message.Clone()
+	if message.QoS() < qos {
+		qos = message.QoS()
+	}
+	publish.SetQoS(qos)
+	if qos == mqtt.QoS0 {
+		publish.PacketIdentifier = 0
+	}
+	publish.Properties = SetIdentifiers(publish.Properties, identifiers...)
+	return publish, true
+}
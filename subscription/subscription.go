@@ -0,0 +1,70 @@
+// Package subscription keeps track of MQTT 5 Subscription Identifiers.
+//
+// A client can set a Subscription Identifier on a SUBSCRIBE packet. When the
+// server sends a PUBLISH packet to the client, it includes the identifiers of
+// all subscriptions that match the topic of the message, so that the client can
+// route the message without matching topic filters again.
+//
+// The Set in this package is used by servers to remember the subscriptions of
+// a client, and the Dispatcher is used by clients to route incoming messages.
+package subscription // import "htdvisser.dev/mqtt/subscription"
+
+import (
+	"errors"
+
+	"htdvisser.dev/mqtt"
+)
+
+// MaxIdentifier is the maximum value of a Subscription Identifier.
+const MaxIdentifier = 268435455
+
+var (
+	errInvalidIdentifier   = mqtt.NewReasonCodeError(mqtt.ProtocolError, "subscription: invalid subscription identifier")
+	errMultipleIdentifiers = mqtt.NewReasonCodeError(mqtt.ProtocolError, "subscription: multiple subscription identifiers")
+	errNoIdentifiers       = errors.New("subscription: no subscription identifiers available")
+)
+
+// Identifier returns the Subscription Identifier of the SUBSCRIBE packet, or 0
+// if the packet does not have one.
+func Identifier(subscribe *mqtt.SubscribePacket) (uint32, error) {
+	var identifier uint32
+	for _, property := range subscribe.Properties {
+		if property.Identifier != mqtt.SubscriptionIdentifier {
+			continue
+		}
+		if identifier != 0 {
+			return 0, errMultipleIdentifiers
+		}
+		if property.UintValue == 0 || property.UintValue > MaxIdentifier {
+			return 0, errInvalidIdentifier
+		}
+		identifier = uint32(property.UintValue)
+	}
+	return identifier, nil
+}
+
+// Identifiers returns the Subscription Identifiers of the PUBLISH packet.
+func Identifiers(publish *mqtt.PublishPacket) []uint32 {
+	var identifiers []uint32
+	for _, property := range publish.Properties {
+		if property.Identifier == mqtt.SubscriptionIdentifier {
+			identifiers = append(identifiers, uint32(property.UintValue))
+		}
+	}
+	return identifiers
+}
+
+// SetIdentifiers replaces the Subscription Identifiers in the properties. It
+// reuses the backing array of the given properties.
+func SetIdentifiers(properties mqtt.Properties, identifiers ...uint32) mqtt.Properties {
+	filtered := properties[:0]
+	for _, property := range properties {
+		if property.Identifier != mqtt.SubscriptionIdentifier {
+			filtered = append(filtered, property)
+		}
+	}
+	for _, identifier := range identifiers {
+		filtered = append(filtered, mqtt.Property{Identifier: mqtt.SubscriptionIdentifier, UintValue: uint64(identifier)})
+	}
+	return filtered
+}
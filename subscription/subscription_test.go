@@ -0,0 +1,142 @@
+package subscription
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func subscribePacket(identifier uint32, subscriptions ...mqtt.Subscription) *mqtt.SubscribePacket {
+	subscribe := &mqtt.SubscribePacket{SubscribePayload: subscriptions}
+	if identifier != 0 {
+		subscribe.Properties = SetIdentifiers(nil, identifier)
+	}
+	return subscribe
+}
+
+func TestIdentifier(t *testing.T) {
+	assert := assert.New(t)
+
+	identifier, err := Identifier(subscribePacket(0))
+	assert.NoError(err)
+	assert.Equal(uint32(0), identifier)
+
+	identifier, err = Identifier(subscribePacket(42))
+	assert.NoError(err)
+	assert.Equal(uint32(42), identifier)
+
+	_, err = Identifier(&mqtt.SubscribePacket{Properties: SetIdentifiers(nil, 1, 2)})
+	assert.Equal(errMultipleIdentifiers, err)
+
+	_, err = Identifier(&mqtt.SubscribePacket{Properties: mqtt.Properties{{Identifier: mqtt.SubscriptionIdentifier}}})
+	assert.Equal(errInvalidIdentifier, err)
+}
+
+func TestSet(t *testing.T) {
+	assert := assert.New(t)
+
+	var set Set
+
+	_, err := set.Subscribe(subscribePacket(1, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("foo/+"), QoS: mqtt.QoS1}))
+	assert.NoError(err)
+	_, err = set.Subscribe(subscribePacket(2, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("foo/#"), QoS: mqtt.QoS2}))
+	assert.NoError(err)
+	_, err = set.Subscribe(subscribePacket(0, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("foo/bar"), QoS: mqtt.QoS0}))
+	assert.NoError(err)
+	records, err := set.Subscribe(subscribePacket(3, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("bar"), QoS: mqtt.QoS0}))
+	assert.NoError(err)
+	assert.Equal([]Record{{TopicFilter: mqtt.TopicFilter("bar"), Identifier: 3}}, records)
+
+	message := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte("foo/bar"), PacketIdentifier: 1},
+		Properties:     mqtt.Properties{{Identifier: mqtt.ContentType, BytesValue: []byte("text/plain")}},
+		PublishPayload: []byte("payload"),
+	}
+	message.SetQoS(mqtt.QoS1)
+
+	publish, ok := set.Route(message)
+	assert.True(ok)
+	assert.Equal(mqtt.QoS1, publish.QoS())
+	assert.Equal([]uint32{1, 2}, Identifiers(publish))
+	assert.Empty(Identifiers(message), "the message must not be modified")
+
+	// Subscribing again with the same filter replaces the identifier.
+	_, err = set.Subscribe(subscribePacket(4, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("foo/+"), QoS: mqtt.QoS0}))
+	assert.NoError(err)
+	qos, identifiers, ok := set.Match([]byte("foo/bar"))
+	assert.True(ok)
+	assert.Equal(mqtt.QoS2, qos)
+	assert.Equal([]uint32{4, 2}, identifiers)
+
+	assert.Equal([]bool{true, false}, set.Unsubscribe(&mqtt.UnsubscribePacket{
+		UnsubscribePayload: []mqtt.TopicFilter{mqtt.TopicFilter("foo/#"), mqtt.TopicFilter("baz")},
+	}))
+	publish, ok = set.Route(message)
+	assert.True(ok)
+	assert.Equal(mqtt.QoS0, publish.QoS())
+	assert.Equal(uint16(0), publish.PacketIdentifier)
+	assert.Equal([]uint32{4}, Identifiers(publish))
+
+	_, ok = set.Route(&mqtt.PublishPacket{PublishHeader: mqtt.PublishHeader{TopicName: []byte("baz")}})
+	assert.False(ok)
+
+	assert.Len(set.Records(), 3)
+
+	// Shared subscriptions match on the filter after the share name.
+	_, err = set.Subscribe(subscribePacket(5, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("$share/group/baz/#"), QoS: mqtt.QoS1}))
+	assert.NoError(err)
+	qos, identifiers, ok = set.Match([]byte("baz/qux"))
+	assert.True(ok)
+	assert.Equal(mqtt.QoS1, qos)
+	assert.Equal([]uint32{5}, identifiers)
+	_, _, ok = set.Match([]byte("$share/group/baz/qux"))
+	assert.False(ok)
+}
+
+func TestDispatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		dispatcher       Dispatcher
+		foo, bar, others []*mqtt.PublishPacket
+	)
+	dispatcher.Fallback = func(publish *mqtt.PublishPacket) { others = append(others, publish) }
+
+	fooSubscribe := subscribePacket(0, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("foo/+")})
+	fooID, err := dispatcher.Subscribe(fooSubscribe, func(publish *mqtt.PublishPacket) { foo = append(foo, publish) })
+	assert.NoError(err)
+	barSubscribe := subscribePacket(0, mqtt.Subscription{TopicFilter: mqtt.TopicFilter("+/bar")})
+	barID, err := dispatcher.Subscribe(barSubscribe, func(publish *mqtt.PublishPacket) { bar = append(bar, publish) })
+	assert.NoError(err)
+	assert.NotEqual(fooID, barID)
+
+	// The server remembers the identifiers and attaches them to the message.
+	var set Set
+	for _, subscribe := range []*mqtt.SubscribePacket{fooSubscribe, barSubscribe} {
+		_, err = set.Subscribe(subscribe)
+		assert.NoError(err)
+	}
+	publish, ok := set.Route(&mqtt.PublishPacket{PublishHeader: mqtt.PublishHeader{TopicName: []byte("foo/bar")}})
+	assert.True(ok)
+
+	// The identifiers survive encoding and decoding.
+	var buf bytes.Buffer
+	w := mqtt.NewWriter(&buf)
+	w.SetProtocol(5)
+	assert.NoError(w.WritePacket(publish))
+	r := mqtt.NewReader(&buf)
+	r.SetProtocol(5)
+	packet, err := r.ReadPacket()
+	assert.NoError(err)
+
+	assert.Equal(2, dispatcher.Dispatch(packet.(*mqtt.PublishPacket)))
+	assert.Len(foo, 1)
+	assert.Len(bar, 1)
+
+	dispatcher.Unsubscribe(fooID)
+	dispatcher.Unsubscribe(barID)
+	assert.Equal(0, dispatcher.Dispatch(packet.(*mqtt.PublishPacket)))
+	assert.Len(others, 1)
+}
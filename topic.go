@@ -0,0 +1,97 @@
+package mqtt
+
+import "bytes"
+
+const (
+	topicLevelSeparator = '/'
+	singleLevelWildcard = '+'
+	multiLevelWildcard  = '#'
+)
+
+// Match returns whether the topic name matches the topic filter.
+// As required by the specification, a filter that starts with a wildcard does
+// not match topic names that start with a '$' character.
+func (f TopicFilter) Match(topicName []byte) bool {
+	if len(topicName) > 0 && topicName[0] == '$' && len(f) > 0 && (f[0] == singleLevelWildcard || f[0] == multiLevelWildcard) {
+		return false
+	}
+	filter := []byte(f)
+	for {
+		filterLevel, filterRest, filterMore := nextTopicLevel(filter)
+		if len(filterLevel) == 1 && filterLevel[0] == multiLevelWildcard {
+			return true
+		}
+		topicLevel, topicRest, topicMore := nextTopicLevel(topicName)
+		if !(len(filterLevel) == 1 && filterLevel[0] == singleLevelWildcard) && !bytes.Equal(filterLevel, topicLevel) {
+			return false
+		}
+		if !filterMore || !topicMore {
+			// The "#" wildcard also matches the parent level.
+			return filterMore == topicMore || (!topicMore && bytes.Equal(filterRest, []byte{multiLevelWildcard}))
+		}
+		filter, topicName = filterRest, topicRest
+	}
+}
+
+// HasWildcard returns whether the topic filter contains a wildcard.
+func (f TopicFilter) HasWildcard() bool {
+	return bytes.IndexByte(f, singleLevelWildcard) >= 0 || bytes.IndexByte(f, multiLevelWildcard) >= 0
+}
+
+func nextTopicLevel(topic []byte) (level, rest []byte, more bool) {
+	if i := bytes.IndexByte(topic, topicLevelSeparator); i >= 0 {
+		return topic[:i], topic[i+1:], true
+	}
+	return topic, nil, false
+}
+
+var (
+	errInvalidTopicFilter        = NewReasonCodeError(TopicFilterInvalid, "mqtt: invalid topic filter")
+	errInvalidSharedSubscription = NewReasonCodeError(TopicFilterInvalid, "mqtt: invalid shared subscription")
+)
+
+// Validate returns an error if the topic filter is not valid.
+func (f TopicFilter) Validate() error {
+	if len(f) == 0 || bytes.IndexByte(f, 0) >= 0 {
+		return errInvalidTopicFilter
+	}
+	filter := []byte(f)
+	for {
+		level, rest, more := nextTopicLevel(filter)
+		if len(level) > 1 && (bytes.IndexByte(level, singleLevelWildcard) >= 0 || bytes.IndexByte(level, multiLevelWildcard) >= 0) {
+			return errInvalidTopicFilter
+		}
+		if more && len(level) == 1 && level[0] == multiLevelWildcard {
+			return errInvalidTopicFilter
+		}
+		if !more {
+			return nil
+		}
+		filter = rest
+	}
+}
+
+var sharedSubscriptionPrefix = []byte("$share/")
+
+// IsShared returns whether the topic filter is a shared subscription.
+func (f TopicFilter) IsShared() bool {
+	return bytes.HasPrefix(f, sharedSubscriptionPrefix)
+}
+
+// SplitShared splits a shared subscription ($share/{ShareName}/{filter}) into
+// the share name and the topic filter. It returns an error if the topic filter
+// is not a valid shared subscription.
+func (f TopicFilter) SplitShared() (shareName []byte, filter TopicFilter, err error) {
+	if !f.IsShared() {
+		return nil, nil, errInvalidSharedSubscription
+	}
+	shareName, rest, more := nextTopicLevel(f[len(sharedSubscriptionPrefix):])
+	if !more || len(shareName) == 0 || bytes.ContainsAny(shareName, "+#") {
+		return nil, nil, errInvalidSharedSubscription
+	}
+	filter = TopicFilter(rest)
+	if err = filter.Validate(); err != nil {
+		return nil, nil, errInvalidSharedSubscription
+	}
+	return shareName, filter, nil
+}
@@ -0,0 +1,110 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicFilterMatch(t *testing.T) {
+	for _, tt := range []struct {
+		filter    string
+		topicName string
+		match     bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"foo", "foo/bar", false},
+		{"foo/bar", "foo", false},
+		{"foo/+", "foo/bar", true},
+		{"foo/+", "foo/", true},
+		{"foo/+", "foo", false},
+		{"foo/+", "foo/bar/baz", false},
+		{"foo/+/baz", "foo/bar/baz", true},
+		{"+/+", "/foo", true},
+		{"foo/#", "foo", true},
+		{"foo/#", "foo/bar", true},
+		{"foo/#", "foo/bar/baz", true},
+		{"foo/#", "bar/baz", false},
+		{"#", "foo/bar", true},
+		{"#", "$SYS/foo", false},
+		{"+/foo", "$SYS/foo", false},
+		{"$SYS/#", "$SYS/foo", true},
+		{"", "", true},
+	} {
+		t.Run(tt.filter+" "+tt.topicName, func(t *testing.T) {
+			assert.Equal(t, tt.match, TopicFilter(tt.filter).Match([]byte(tt.topicName)))
+		})
+	}
+}
+
+func TestTopicFilterHasWildcard(t *testing.T) {
+	assert := assert.New(t)
+	assert.False(TopicFilter("foo/bar").HasWildcard())
+	assert.True(TopicFilter("foo/+").HasWildcard())
+	assert.True(TopicFilter("foo/#").HasWildcard())
+}
+
+func TestTopicFilterValidate(t *testing.T) {
+	for _, tt := range []struct {
+		filter string
+		valid  bool
+	}{
+		{"foo", true},
+		{"foo/bar", true},
+		{"/", true},
+		{"+", true},
+		{"#", true},
+		{"foo/+/bar", true},
+		{"foo/#", true},
+		{"+/+/#", true},
+		{"", false},
+		{"foo#", false},
+		{"foo/#/bar", false},
+		{"foo+", false},
+		{"foo/+bar", false},
+		{"foo\x00", false},
+	} {
+		t.Run(tt.filter, func(t *testing.T) {
+			err := TopicFilter(tt.filter).Validate()
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, errInvalidTopicFilter, err)
+			}
+		})
+	}
+}
+
+func TestTopicFilterSplitShared(t *testing.T) {
+	for _, tt := range []struct {
+		filter    string
+		shareName string
+		topic     string
+		valid     bool
+	}{
+		{"$share/group/foo/bar", "group", "foo/bar", true},
+		{"$share/group/#", "group", "#", true},
+		{"$share/group//", "group", "/", true},
+		{"foo/bar", "", "", false},
+		{"$share/group", "", "", false},
+		{"$share//foo", "", "", false},
+		{"$share/group/", "", "", false},
+		{"$share/gr+oup/foo", "", "", false},
+		{"$share/gr#oup/foo", "", "", false},
+		{"$share/group/foo/#/bar", "", "", false},
+	} {
+		t.Run(tt.filter, func(t *testing.T) {
+			assert := assert.New(t)
+			shareName, filter, err := TopicFilter(tt.filter).SplitShared()
+			if !tt.valid {
+				assert.Equal(errInvalidSharedSubscription, err)
+				return
+			}
+			assert.NoError(err)
+			assert.True(TopicFilter(tt.filter).IsShared())
+			assert.Equal(tt.shareName, string(shareName))
+			assert.Equal(tt.topic, string(filter))
+		})
+	}
+}
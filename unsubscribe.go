@@ -50,10 +50,16 @@ func (w *PacketWriter) writeUnsubscribeHeader() {
 func (r *PacketReader) readUnsubscribePayload() {
 	packet := r.packet.(*UnsubscribePacket)
 	for r.remaining() > 0 {
+		if r.err = r.checkSubscriptions(len(packet.UnsubscribePayload)); r.err != nil {
+			return
+		}
 		var topicFilter TopicFilter
 		if topicFilter, r.err = r.readBytes(); r.err != nil {
 			return
 		}
+		if r.err = r.checkTopic(topicFilter, errTopicFilterTooLong); r.err != nil {
+			return
+		}
 		packet.UnsubscribePayload = append(packet.UnsubscribePayload, topicFilter)
 	}
 }
@@ -0,0 +1,243 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ConformanceError is the error for a packet that violates a normative
+// statement of the MQTT specification.
+type ConformanceError struct {
+	// Statement is the ID of the conformance statement in the specification of
+	// the protocol version, such as "MQTT-3.8.3-3".
+	Statement string
+
+	reasonCode ReasonCode
+	message    string
+}
+
+func newConformanceError(reasonCode ReasonCode, statement, message string) *ConformanceError {
+	return &ConformanceError{Statement: statement, reasonCode: reasonCode, message: message}
+}
+
+// ReasonCode returns the reason code of the error.
+func (e *ConformanceError) ReasonCode() ReasonCode { return e.reasonCode }
+
+func (e *ConformanceError) Error() string {
+	return fmt.Sprintf("mqtt: %s [%s]", e.message, e.Statement)
+}
+
+// statement returns the ID of a conformance statement in the MQTT 3.1.1 or the
+// MQTT 5.0 specification, depending on the protocol version. MQTT 3.1 does not
+// have conformance statements, so the MQTT 3.1.1 statements are used.
+func statement(protocol byte, v311, v5 string) string {
+	if protocol >= 5 {
+		return v5
+	}
+	return v311
+}
+
+// Validate checks the packet against the normative statements of the
+// specification of the protocol version that can be checked on a single
+// packet. It returns a *ConformanceError for the first violation.
+//
+// Validate is applied by the PacketReader to every packet that it reads,
+// unless the PacketReader has the WithoutValidation option.
+func Validate(p Packet, protocol byte) error {
+	switch p := p.(type) {
+	case *ConnectPacket:
+		return validateConnect(p, protocol)
+	case *ConnackPacket:
+		if p.ReasonCode.IsError() && p.SessionPresent() {
+			return newConformanceError(ProtocolError, statement(protocol, "MQTT-3.2.2-4", "MQTT-3.2.2-6"),
+				"session present must be 0 in a CONNACK with an error reason code")
+		}
+	case *PublishPacket:
+		return validatePublish(p, protocol)
+	case *SubscribePacket:
+		return validateSubscribe(p, protocol)
+	case *UnsubscribePacket:
+		if p.PacketIdentifier == 0 {
+			return errZeroPacketIdentifier(protocol, UNSUBSCRIBE)
+		}
+		if len(p.UnsubscribePayload) == 0 {
+			return newConformanceError(ProtocolError, "MQTT-3.10.3-2", "UNSUBSCRIBE must contain at least one topic filter")
+		}
+		for _, topicFilter := range p.UnsubscribePayload {
+			if err := validateTopicFilter(topicFilter, protocol); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WithoutValidation returns a ReaderOption that disables the validation of the
+// packets that the Reader reads (see Validate).
+func WithoutValidation() ReaderOption {
+	return readerOptionFunc(func(r *PacketReader) {
+		r.skipValidation = true
+	})
+}
+
+func errZeroPacketIdentifier(protocol byte, packetType PacketType) error {
+	return newConformanceError(ProtocolError, statement(protocol, "MQTT-2.3.1-1", "MQTT-2.2.1-3"),
+		fmt.Sprintf("%s must have a non-zero packet identifier", packetType))
+}
+
+var (
+	errConnectReservedFlag = newConformanceError(MalformedPacket, "MQTT-3.1.2-3", "reserved flag in CONNECT must be 0")
+	errPublishQoS          = newConformanceError(MalformedPacket, "MQTT-3.3.1-4", "PUBLISH must not have both QoS bits set")
+)
+
+func errWillQoS(protocol byte) error {
+	return newConformanceError(MalformedPacket, statement(protocol, "MQTT-3.1.2-14", "MQTT-3.1.2-12"), "will QoS must not be 3")
+}
+
+func validateConnectFlags(f ConnectHeaderFlags, protocol byte) error {
+	if f&0x01 == 0x01 {
+		return errConnectReservedFlag
+	}
+	if f.WillQoS() > QoS2 {
+		return errWillQoS(protocol)
+	}
+	if !f.Will() {
+		if f.WillQoS() != QoS0 {
+			return newConformanceError(MalformedPacket, statement(protocol, "MQTT-3.1.2-13", "MQTT-3.1.2-11"),
+				"will QoS must be 0 if the will flag is 0")
+		}
+		if f.WillRetain() {
+			return newConformanceError(MalformedPacket, statement(protocol, "MQTT-3.1.2-15", "MQTT-3.1.2-13"),
+				"will retain must be 0 if the will flag is 0")
+		}
+	}
+	if protocol < 5 && f.Password() && !f.Username() {
+		return newConformanceError(MalformedPacket, "MQTT-3.1.2-22", "password flag must be 0 if the user name flag is 0")
+	}
+	return nil
+}
+
+func validateConnect(p *ConnectPacket, protocol byte) error {
+	if err := validateConnectFlags(p.ConnectHeaderFlags, protocol); err != nil {
+		return err
+	}
+	if protocol < 5 && !p.ConnectHeader.Will() && (len(p.WillTopic) > 0 || len(p.WillMessage) > 0) {
+		return newConformanceError(MalformedPacket, "MQTT-3.1.2-11", "will topic and will message must not be present if the will flag is 0")
+	}
+	if !p.ConnectHeader.Username() && len(p.ConnectPayload.Username) > 0 {
+		return newConformanceError(MalformedPacket, statement(protocol, "MQTT-3.1.2-18", "MQTT-3.1.2-16"),
+			"user name must not be present if the user name flag is 0")
+	}
+	if !p.ConnectHeader.Password() && len(p.ConnectPayload.Password) > 0 {
+		return newConformanceError(MalformedPacket, statement(protocol, "MQTT-3.1.2-20", "MQTT-3.1.2-18"),
+			"password must not be present if the password flag is 0")
+	}
+	if protocol < 5 && len(p.ClientIdentifier) == 0 && !p.CleanSession() {
+		return newConformanceError(ClientIdentifierNotValid, "MQTT-3.1.3-7", "clean session must be 1 if the client identifier is empty")
+	}
+	return nil
+}
+
+func validatePublish(p *PublishPacket, protocol byte) error {
+	qos := p.QoS()
+	if qos > QoS2 {
+		return errPublishQoS
+	}
+	if qos == QoS0 && p.Dup() {
+		return newConformanceError(MalformedPacket, "MQTT-3.3.1-2", "DUP must be 0 for QoS 0 PUBLISH")
+	}
+	if qos > QoS0 && p.PacketIdentifier == 0 {
+		return errZeroPacketIdentifier(protocol, PUBLISH)
+	}
+	if len(p.TopicName) == 0 {
+		if protocol >= 5 && hasProperty(p.Properties, TopicAlias) {
+			return nil
+		}
+		return errEmptyTopic
+	}
+	if bytes.IndexByte(p.TopicName, 0) >= 0 {
+		return errNullInTopic
+	}
+	if bytes.IndexByte(p.TopicName, singleLevelWildcard) >= 0 || bytes.IndexByte(p.TopicName, multiLevelWildcard) >= 0 {
+		return newConformanceError(TopicNameInvalid, "MQTT-3.3.2-2", "topic name must not contain wildcard characters")
+	}
+	return nil
+}
+
+func hasProperty(properties Properties, identifier PropertyIdentifier) bool {
+	for _, property := range properties {
+		if property.Identifier == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionReservedBitsV5 are the reserved bits of the subscription options
+// of MQTT 5.
+const subscriptionReservedBitsV5 = 0xC0
+
+func validateSubscribe(p *SubscribePacket, protocol byte) error {
+	if p.PacketIdentifier == 0 {
+		return errZeroPacketIdentifier(protocol, SUBSCRIBE)
+	}
+	if len(p.SubscribePayload) == 0 {
+		return newConformanceError(ProtocolError, statement(protocol, "MQTT-3.8.3-3", "MQTT-3.8.3-2"),
+			"SUBSCRIBE must contain at least one subscription")
+	}
+	for _, subscription := range p.SubscribePayload {
+		if protocol < 5 && subscription.QoS > QoS2 {
+			return newConformanceError(MalformedPacket, "MQTT-3.8.3-4", "reserved bits of the requested QoS must be 0, and the QoS must not be 3")
+		}
+		if protocol >= 5 && subscription.SubscriptionOptions&subscriptionReservedBitsV5 != 0 {
+			return newConformanceError(MalformedPacket, "MQTT-3.8.3-5", "reserved bits of the subscription options must be 0")
+		}
+		if err := validateTopicFilter(subscription.TopicFilter, protocol); err != nil {
+			return err
+		}
+		if protocol >= 5 && subscription.TopicFilter.IsShared() && subscription.NoLocal() {
+			return newConformanceError(ProtocolError, "MQTT-3.8.3-4", "no local must not be set on a shared subscription")
+		}
+	}
+	return nil
+}
+
+var (
+	errEmptyTopic  = newConformanceError(ProtocolError, "MQTT-4.7.3-1", "topic names and topic filters must be at least one character long")
+	errNullInTopic = newConformanceError(MalformedPacket, "MQTT-4.7.3-2", "topic names and topic filters must not contain the null character")
+)
+
+func validateTopicFilter(f TopicFilter, protocol byte) error {
+	if len(f) == 0 {
+		return errEmptyTopic
+	}
+	if bytes.IndexByte(f, 0) >= 0 {
+		return errNullInTopic
+	}
+	if f.IsShared() && protocol >= 5 {
+		shareName, rest, more := nextTopicLevel(f[len(sharedSubscriptionPrefix):])
+		if len(shareName) == 0 {
+			return newConformanceError(ProtocolError, "MQTT-4.8.2-1", "share name must be at least one character long")
+		}
+		if !more || len(rest) == 0 || bytes.ContainsAny(shareName, "+#") {
+			return newConformanceError(ProtocolError, "MQTT-4.8.2-2", "share name must not contain wildcards and must be followed by a topic filter")
+		}
+		f = TopicFilter(rest)
+	}
+	filter := []byte(f)
+	for {
+		level, rest, more := nextTopicLevel(filter)
+		if bytes.IndexByte(level, multiLevelWildcard) >= 0 && (len(level) > 1 || more) {
+			return newConformanceError(TopicFilterInvalid, statement(protocol, "MQTT-4.7.1-2", "MQTT-4.7.1-1"),
+				"multi-level wildcard must occupy an entire level and be the last character")
+		}
+		if bytes.IndexByte(level, singleLevelWildcard) >= 0 && len(level) > 1 {
+			return newConformanceError(TopicFilterInvalid, statement(protocol, "MQTT-4.7.1-3", "MQTT-4.7.1-2"),
+				"single-level wildcard must occupy an entire level")
+		}
+		if !more {
+			return nil
+		}
+		filter = rest
+	}
+}
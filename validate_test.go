@@ -0,0 +1,154 @@
+package mqtt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	publish := func(topic string, qos QoS, packetIdentifier uint16, dup bool) *PublishPacket {
+		p := &PublishPacket{PublishHeader: PublishHeader{TopicName: []byte(topic), PacketIdentifier: packetIdentifier}}
+		p.SetQoS(qos)
+		p.SetDup(dup)
+		return p
+	}
+	subscribe := func(qos QoS, filters ...string) *SubscribePacket {
+		p := &SubscribePacket{SubscribeHeader: SubscribeHeader{PacketIdentifier: 1}}
+		for _, filter := range filters {
+			p.SubscribePayload = append(p.SubscribePayload, Subscription{TopicFilter: TopicFilter(filter), QoS: qos})
+		}
+		return p
+	}
+	withOptions := func(p *SubscribePacket, options SubscriptionOptions) *SubscribePacket {
+		for i := range p.SubscribePayload {
+			p.SubscribePayload[i].SubscriptionOptions = options
+		}
+		return p
+	}
+	connect := func(flags ConnectHeaderFlags) *ConnectPacket {
+		return &ConnectPacket{
+			ConnectHeader:  ConnectHeader{ConnectHeaderFlags: flags},
+			ConnectPayload: ConnectPayload{ClientIdentifier: []byte("client")},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		packet    Packet
+		protocol  byte
+		statement string // empty if valid.
+	}{
+		{"valid connect", connect(0xC2), 4, ""},
+		{"connect reserved flag", connect(0x01), 4, "MQTT-3.1.2-3"},
+		{"will QoS 3", connect(0x1C), 4, "MQTT-3.1.2-14"},
+		{"will QoS 3 v5", connect(0x1C), 5, "MQTT-3.1.2-12"},
+		{"will QoS without will", connect(0x08), 4, "MQTT-3.1.2-13"},
+		{"will QoS without will v5", connect(0x08), 5, "MQTT-3.1.2-11"},
+		{"will retain without will", connect(0x20), 4, "MQTT-3.1.2-15"},
+		{"password without user name", connect(0x40), 4, "MQTT-3.1.2-22"},
+		{"password without user name v5", connect(0x40), 5, ""},
+		{"user name without flag", &ConnectPacket{ConnectPayload: ConnectPayload{ClientIdentifier: []byte("client"), Username: []byte("user")}}, 5, "MQTT-3.1.2-16"},
+		{"will topic without will", &ConnectPacket{ConnectPayload: ConnectPayload{ClientIdentifier: []byte("client"), WillTopic: []byte("will")}}, 4, "MQTT-3.1.2-11"},
+		{"empty client identifier", &ConnectPacket{}, 4, "MQTT-3.1.3-7"},
+		{"connack session present", &ConnackPacket{ConnackHeader: ConnackHeader{ConnackHeaderFlags: 0x01, ReasonCode: NotAuthorized}}, 5, "MQTT-3.2.2-6"},
+		{"valid publish", publish("foo/bar", QoS1, 1, true), 4, ""},
+		{"publish QoS 3", &PublishPacket{PublishFlags: 0x06, PublishHeader: PublishHeader{TopicName: []byte("foo"), PacketIdentifier: 1}}, 4, "MQTT-3.3.1-4"},
+		{"publish DUP on QoS 0", publish("foo", QoS0, 0, true), 4, "MQTT-3.3.1-2"},
+		{"publish zero packet identifier", publish("foo", QoS1, 0, false), 4, "MQTT-2.3.1-1"},
+		{"publish zero packet identifier v5", publish("foo", QoS2, 0, false), 5, "MQTT-2.2.1-3"},
+		{"publish wildcard", publish("foo/+", QoS0, 0, false), 4, "MQTT-3.3.2-2"},
+		{"publish empty topic", publish("", QoS0, 0, false), 5, "MQTT-4.7.3-1"},
+		{"publish topic alias", &PublishPacket{Properties: Properties{{Identifier: TopicAlias, UintValue: 1}}}, 5, ""},
+		{"publish null", publish("foo\x00", QoS0, 0, false), 4, "MQTT-4.7.3-2"},
+		{"valid subscribe", subscribe(QoS1, "foo/+/bar", "#", "$share/group/foo/#"), 5, ""},
+		{"subscribe zero packet identifier", &SubscribePacket{SubscribePayload: []Subscription{{TopicFilter: TopicFilter("foo")}}}, 4, "MQTT-2.3.1-1"},
+		{"subscribe empty payload", subscribe(QoS0), 4, "MQTT-3.8.3-3"},
+		{"subscribe empty payload v5", subscribe(QoS0), 5, "MQTT-3.8.3-2"},
+		{"subscribe reserved bits", subscribe(0x04, "foo"), 4, "MQTT-3.8.3-4"},
+		{"subscribe options", withOptions(subscribe(QoS1, "foo"), 0x2C), 5, ""},
+		{"subscribe reserved bits v5", withOptions(subscribe(QoS0, "foo"), 0x40), 5, "MQTT-3.8.3-5"},
+		{"subscribe multi-level wildcard", subscribe(QoS0, "foo/#/bar"), 4, "MQTT-4.7.1-2"},
+		{"subscribe multi-level wildcard v5", subscribe(QoS0, "foo#"), 5, "MQTT-4.7.1-1"},
+		{"subscribe single-level wildcard", subscribe(QoS0, "foo+"), 4, "MQTT-4.7.1-3"},
+		{"subscribe empty share name", subscribe(QoS0, "$share//foo"), 5, "MQTT-4.8.2-1"},
+		{"subscribe share without filter", subscribe(QoS0, "$share/group"), 5, "MQTT-4.8.2-2"},
+		{"subscribe shared no local", withOptions(subscribe(QoS0, "$share/group/foo"), 0x04), 5, "MQTT-3.8.3-4"},
+		{"unsubscribe empty payload", &UnsubscribePacket{UnsubscribeHeader: UnsubscribeHeader{PacketIdentifier: 1}}, 4, "MQTT-3.10.3-2"},
+		{"unsubscribe empty filter", &UnsubscribePacket{UnsubscribeHeader: UnsubscribeHeader{PacketIdentifier: 1}, UnsubscribePayload: []TopicFilter{{}}}, 4, "MQTT-4.7.3-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			err := Validate(tt.packet, tt.protocol)
+			if tt.statement == "" {
+				assert.NoError(err)
+				return
+			}
+			if assert.IsType(&ConformanceError{}, err) {
+				assert.Equal(tt.statement, err.(*ConformanceError).Statement)
+				assert.Contains(err.Error(), "["+tt.statement+"]")
+			}
+		})
+	}
+}
+
+func TestReaderValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	invalid := &SubscribePacket{SubscribeHeader: SubscribeHeader{PacketIdentifier: 1}}
+	assert.NoError(w.WritePacket(invalid))
+	assert.NoError(w.WritePacket(invalid))
+
+	r := NewReader(&buf)
+	_, err := r.ReadPacket()
+	if assert.IsType(&ConformanceError{}, err) {
+		assert.Equal("MQTT-3.8.3-3", err.(*ConformanceError).Statement)
+		assert.Equal(ProtocolError, err.(*ConformanceError).ReasonCode())
+	}
+
+	r = NewReader(&buf, WithoutValidation())
+	_, err = r.ReadPacket()
+	assert.NoError(err)
+}
+
+func TestSubscriptionOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	subscription := Subscription{TopicFilter: TopicFilter("foo"), QoS: QoS1}
+	subscription.SetNoLocal(true)
+	subscription.SetRetainAsPublished(true)
+	subscription.SetRetainHandling(2)
+	assert.Equal(SubscriptionOptions(0x2C), subscription.SubscriptionOptions)
+
+	subscribe := &SubscribePacket{
+		SubscribeHeader:  SubscribeHeader{PacketIdentifier: 1},
+		SubscribePayload: []Subscription{subscription},
+	}
+
+	for _, protocol := range []byte{4, 5} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.SetProtocol(protocol)
+		assert.NoError(w.WritePacket(subscribe))
+
+		r := NewReader(&buf)
+		r.SetProtocol(protocol)
+		packet, err := r.ReadPacket()
+		if assert.NoError(err) {
+			read := packet.(*SubscribePacket).SubscribePayload[0]
+			assert.Equal(QoS1, read.QoS)
+			assert.Equal(protocol >= 5, read.NoLocal())
+			assert.Equal(protocol >= 5, read.RetainAsPublished())
+			if protocol >= 5 {
+				assert.Equal(byte(2), read.RetainHandling())
+			} else {
+				assert.Equal(byte(0), read.RetainHandling())
+			}
+		}
+	}
+}
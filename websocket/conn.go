@@ -0,0 +1,365 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Opcodes of WebSocket frames.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Status codes of WebSocket close frames.
+const (
+	closeNormal           = 1000
+	closeProtocolError    = 1002
+	closeUnsupportedData  = 1003
+	closeMessageTooBig    = 1009
+	maxControlPayloadSize = 125
+)
+
+var (
+	errTextFrame       = errors.New("websocket: received text frame")
+	errReservedBits    = errors.New("websocket: reserved bits set")
+	errUnknownOpcode   = errors.New("websocket: unknown opcode")
+	errInvalidControl  = errors.New("websocket: invalid control frame")
+	errInvalidMasking  = errors.New("websocket: invalid frame masking")
+	errFrameTooLarge   = errors.New("websocket: frame too large")
+	errConnectionClose = errors.New("websocket: connection closed")
+)
+
+// maxFrameSize is the maximum size of a frame. This is larger than the maximum
+// MQTT packet size, because a frame may contain multiple packets.
+const maxFrameSize = 1 << 30
+
+// maxWriteBufferSize is the size at which buffered writes are sent as a frame
+// without waiting for Flush. Larger packets are split across frames.
+const maxWriteBufferSize = 64 * 1024
+
+// Conn is a WebSocket connection that reads and writes the payload of binary
+// frames as a byte stream. Conn implements net.Conn.
+//
+// Writes are buffered until the end of an MQTT packet, so that every packet
+// written by a PacketWriter is sent in its own binary frame. Flush sends the
+// buffered data of an incomplete packet.
+type Conn struct {
+	net.Conn
+	br          *bufio.Reader
+	isClient    bool
+	subprotocol string
+
+	rmu       sync.Mutex
+	remaining uint64
+	masked    bool
+	maskKey   [4]byte
+	maskPos   int
+	readErr   error
+
+	wmu        sync.Mutex
+	wbuf       []byte
+	wheader    int    // Number of fixed header bytes seen of the current packet.
+	wlength    uint64 // Remaining length that is being decoded.
+	wremaining uint64 // Number of bytes remaining in the current packet.
+	closeSent  bool
+	closeOnce  sync.Once
+	closeError error
+}
+
+func newConn(nc net.Conn, br *bufio.Reader, isClient bool, subprotocol string) *Conn {
+	if br == nil {
+		br = bufio.NewReader(nc)
+	}
+	return &Conn{Conn: nc, br: br, isClient: isClient, subprotocol: subprotocol}
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *Conn) Subprotocol() string { return c.subprotocol }
+
+// Read reads the payload of binary frames. It returns io.EOF when the peer
+// closes the WebSocket connection.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	for c.remaining == 0 {
+		if err = c.readFrameHeader(); err != nil {
+			return 0, c.failRead(err)
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err = c.br.Read(p)
+	if c.masked {
+		c.mask(p[:n])
+	}
+	c.remaining -= uint64(n)
+	if err != nil {
+		return n, c.failRead(err)
+	}
+	return n, nil
+}
+
+// failRead records the read error. Protocol errors are reported to the peer.
+func (c *Conn) failRead(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return err // The read can be retried.
+	}
+	c.readErr = err
+	switch err {
+	case io.EOF, errConnectionClose:
+		c.readErr = io.EOF
+	case errTextFrame:
+		c.writeClose(closeUnsupportedData)
+	case errFrameTooLarge:
+		c.writeClose(closeMessageTooBig)
+	case errReservedBits, errUnknownOpcode, errInvalidControl, errInvalidMasking:
+		c.writeClose(closeProtocolError)
+	}
+	return c.readErr
+}
+
+func (c *Conn) mask(b []byte) {
+	for i := range b {
+		b[i] ^= c.maskKey[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+// readFrameHeader reads the next frame header. Control frames are handled
+// completely, data frames are left for Read to consume.
+func (c *Conn) readFrameHeader() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+	if header[0]&0x70 != 0 {
+		return errReservedBits
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.isClient {
+		// Clients must mask frames, servers must not.
+		return errInvalidMasking
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > maxFrameSize {
+		return errFrameTooLarge
+	}
+	c.masked, c.maskPos = masked, 0
+	if masked {
+		if _, err := io.ReadFull(c.br, c.maskKey[:]); err != nil {
+			return err
+		}
+	}
+	switch opcode {
+	case opContinuation, opBinary:
+		c.remaining = length
+		return nil
+	case opText:
+		return errTextFrame
+	case opClose, opPing, opPong:
+		if !fin || length > maxControlPayloadSize {
+			return errInvalidControl
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		if masked {
+			c.mask(payload)
+		}
+		return c.handleControl(opcode, payload)
+	default:
+		return errUnknownOpcode
+	}
+}
+
+func (c *Conn) handleControl(opcode byte, payload []byte) error {
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opClose:
+		c.writeClose(closeNormal)
+		return errConnectionClose
+	}
+	return nil
+}
+
+// Write buffers p. The buffered data is sent as a binary frame at the end of
+// every MQTT packet, when the buffer is full, or by Flush.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return 0, errConnectionClose
+	}
+	var written int
+	for written < len(p) {
+		n, end := c.scanPacket(p[written:])
+		c.wbuf = append(c.wbuf, p[written:written+n]...)
+		if end || len(c.wbuf) >= maxWriteBufferSize {
+			if err := c.flushLocked(); err != nil {
+				return written, err
+			}
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// scanPacket returns the number of bytes in p that belong to the current MQTT
+// packet, and whether the packet ends there.
+func (c *Conn) scanPacket(p []byte) (n int, end bool) {
+	for n < len(p) {
+		if c.wremaining > 0 {
+			k := uint64(len(p) - n)
+			if k > c.wremaining {
+				k = c.wremaining
+			}
+			n += int(k)
+			c.wremaining -= k
+			if c.wremaining == 0 {
+				return n, true
+			}
+			continue
+		}
+		b := p[n]
+		n++
+		if c.wheader == 0 {
+			c.wheader, c.wlength = 1, 0
+			continue
+		}
+		c.wlength |= uint64(b&0x7f) << (7 * uint(c.wheader-1))
+		c.wheader++
+		if b&0x80 != 0 && c.wheader <= 4 {
+			continue
+		}
+		c.wheader, c.wremaining = 0, c.wlength
+		if c.wremaining == 0 {
+			return n, true
+		}
+	}
+	return n, false
+}
+
+// Flush sends the buffered data as a single binary frame. Flush is only needed
+// after writing data that does not end with a complete MQTT packet.
+func (c *Conn) Flush() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return errConnectionClose
+	}
+	return c.flushLocked()
+}
+
+func (c *Conn) flushLocked() error {
+	if len(c.wbuf) == 0 {
+		return nil
+	}
+	err := c.writeFrameLocked(opBinary, c.wbuf)
+	if cap(c.wbuf) > maxWriteBufferSize {
+		c.wbuf = nil // Do not keep large buffers around.
+	} else {
+		c.wbuf = c.wbuf[:0]
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return errConnectionClose
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte) error {
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|opcode)
+	var maskBit byte
+	if c.isClient {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length < 126:
+		buf = append(buf, maskBit|byte(length))
+	case length <= 0xFFFF:
+		buf = append(buf, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[2:], uint16(length))
+	default:
+		buf = append(buf, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[2:], uint64(length))
+	}
+	if !c.isClient {
+		buf = append(buf, payload...)
+		_, err := c.Conn.Write(buf)
+		return err
+	}
+	var maskKey [4]byte
+	if _, err := io.ReadFull(rand.Reader, maskKey[:]); err != nil {
+		return err
+	}
+	buf = append(buf, maskKey[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	for i := range buf[start:] {
+		buf[start+i] ^= maskKey[i&3]
+	}
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+// writeClose sends a close frame with the given status code, if no close frame
+// was sent before.
+func (c *Conn) writeClose(status uint16) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return
+	}
+	c.flushLocked()
+	var payload [2]byte
+	binary.BigEndian.PutUint16(payload[:], status)
+	c.writeFrameLocked(opClose, payload[:])
+	c.closeSent = true
+}
+
+// Close sends the buffered data and a close frame, and closes the underlying
+// connection.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writeClose(closeNormal)
+		c.closeError = c.Conn.Close()
+	})
+	return c.closeError
+}
@@ -0,0 +1,166 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Dialer dials WebSocket connections with the MQTT subprotocol.
+type Dialer struct {
+	// NetDialContext dials the underlying connection. If nil, a net.Dialer is used.
+	NetDialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// TLSConfig is used for wss:// URLs.
+	TLSConfig *tls.Config
+	// Header contains extra headers for the handshake request.
+	Header http.Header
+}
+
+// DefaultDialer is the default WebSocket Dialer.
+var DefaultDialer = &Dialer{}
+
+// Dial dials a WebSocket connection to the URL with the DefaultDialer.
+func Dial(rawurl string) (*Conn, error) {
+	return DefaultDialer.DialContext(context.Background(), rawurl)
+}
+
+var (
+	errUnsupportedScheme  = errors.New("websocket: unsupported url scheme")
+	errBadHandshake       = errors.New("websocket: bad handshake")
+	errInvalidSubprotocol = errors.New("websocket: server did not select the mqtt subprotocol")
+)
+
+// DialContext dials a WebSocket connection to the ws:// or wss:// URL. If the
+// context is done before the handshake completes, the connection is closed and
+// the context error is returned.
+func (d *Dialer) DialContext(ctx context.Context, rawurl string) (conn *Conn, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	var defaultPort string
+	switch u.Scheme {
+	case "ws":
+		defaultPort = "80"
+	case "wss":
+		defaultPort = "443"
+	default:
+		return nil, errUnsupportedScheme
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	dial := d.NetDialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	nc, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	if ctx.Done() != nil {
+		stop, canceled := make(chan struct{}), make(chan bool, 1)
+		go func(nc net.Conn) {
+			select {
+			case <-ctx.Done():
+				nc.Close()
+				canceled <- true
+			case <-stop:
+				canceled <- false
+			}
+		}(nc)
+		defer func() {
+			close(stop)
+			if <-canceled {
+				conn, err = nil, ctx.Err()
+			}
+		}()
+	}
+	if u.Scheme == "wss" {
+		config := d.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(nc, config)
+		if err = tlsConn.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tlsConn
+	}
+	conn, err = d.handshake(nc, u)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (d *Dialer) handshake(nc net.Conn, u *url.URL) (*Conn, error) {
+	var nonce [16]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", Subprotocol)
+	if err := req.Write(nc); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(nc)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: status %s", errBadHandshake, res.Status)
+	}
+	if !headerContains(res.Header, "Connection", "upgrade") ||
+		!headerContains(res.Header, "Upgrade", "websocket") ||
+		res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, errBadHandshake
+	}
+	if res.Header.Get("Sec-WebSocket-Protocol") != Subprotocol {
+		return nil, errInvalidSubprotocol
+	}
+	return newConn(nc, br, true, Subprotocol), nil
+}
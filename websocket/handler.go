@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Handler is an http.Handler that upgrades requests to WebSocket connections
+// with the MQTT subprotocol. Handler also implements net.Listener, so that the
+// upgraded connections can be accepted like TCP connections.
+type Handler struct {
+	// CheckOrigin returns whether the request origin is allowed.
+	// If nil, all origins are allowed.
+	CheckOrigin func(r *http.Request) bool
+
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewHandler returns a new WebSocket Handler.
+func NewHandler() *Handler {
+	return &Handler{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func selectSubprotocol(r *http.Request) string {
+	var legacy bool
+	for _, protocol := range headerValues(r.Header, "Sec-WebSocket-Protocol") {
+		switch protocol {
+		case Subprotocol:
+			return Subprotocol
+		case legacySubprotocol:
+			legacy = true
+		}
+	}
+	if legacy {
+		return legacySubprotocol
+	}
+	return ""
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing websocket key", http.StatusBadRequest)
+		return
+	}
+	subprotocol := selectSubprotocol(r)
+	if subprotocol == "" {
+		http.Error(w, "unsupported websocket subprotocol", http.StatusBadRequest)
+		return
+	}
+	if h.CheckOrigin != nil && !h.CheckOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	nc, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n")
+	fmt.Fprintf(brw, "Upgrade: websocket\r\n")
+	fmt.Fprintf(brw, "Connection: Upgrade\r\n")
+	fmt.Fprintf(brw, "Sec-WebSocket-Accept: %s\r\n", acceptKey(key))
+	fmt.Fprintf(brw, "Sec-WebSocket-Protocol: %s\r\n\r\n", subprotocol)
+	if err = brw.Flush(); err != nil {
+		nc.Close()
+		return
+	}
+	conn := newConn(nc, brw.Reader, false, subprotocol)
+	select {
+	case h.conns <- conn:
+	case <-h.closed:
+		conn.Close()
+	}
+}
+
+var errHandlerClosed = errors.New("websocket: handler closed")
+
+// Accept waits for and returns the next upgraded connection.
+func (h *Handler) Accept() (net.Conn, error) {
+	select {
+	case conn := <-h.conns:
+		return conn, nil
+	case <-h.closed:
+		return nil, errHandlerClosed
+	}
+}
+
+// Close stops accepting connections. Connections that are upgraded after
+// closing the Handler are closed immediately.
+func (h *Handler) Close() error {
+	h.closeOnce.Do(func() { close(h.closed) })
+	return nil
+}
+
+type handlerAddr struct{}
+
+func (handlerAddr) Network() string { return "websocket" }
+func (handlerAddr) String() string  { return "websocket" }
+
+// Addr returns a placeholder address, since the address that the Handler is
+// served on is determined by the HTTP server.
+func (h *Handler) Addr() net.Addr { return handlerAddr{} }
@@ -0,0 +1,52 @@
+// Package websocket implements a minimal WebSocket (RFC 6455) transport for MQTT.
+//
+// MQTT over WebSocket sends MQTT packets in binary WebSocket frames, using the
+// "mqtt" subprotocol. Packets may be split across frames, and a single frame
+// may contain multiple packets, so the connections returned by this package
+// present the frames as a continuous byte stream that can be used with
+// mqtt.NewReader and mqtt.NewWriter.
+package websocket // import "htdvisser.dev/mqtt/websocket"
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// Subprotocol is the WebSocket subprotocol for MQTT.
+const Subprotocol = "mqtt"
+
+// legacySubprotocol is the WebSocket subprotocol used by some MQTT 3.1 clients.
+const legacySubprotocol = "mqttv3.1"
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerValues returns the comma-separated values of the header.
+func headerValues(header http.Header, key string) (values []string) {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// headerContains returns whether the header contains the (case-insensitive) value.
+func headerContains(header http.Header, key, value string) bool {
+	for _, v := range headerValues(header, key) {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,279 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"htdvisser.dev/mqtt"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func newTestServer() (h *Handler, url string, close func()) {
+	h = NewHandler()
+	srv := httptest.NewServer(h)
+	return h, "ws" + strings.TrimPrefix(srv.URL, "http"), func() {
+		h.Close()
+		srv.Close()
+	}
+}
+
+func TestDialAccept(t *testing.T) {
+	assert := assert.New(t)
+
+	h, url, close := newTestServer()
+	defer close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := h.Accept()
+		assert.NoError(err)
+		accepted <- conn
+	}()
+
+	client, err := Dial(url)
+	if !assert.NoError(err) {
+		t.FailNow()
+	}
+	defer client.Close()
+	assert.Equal(Subprotocol, client.Subprotocol())
+
+	server := <-accepted
+	defer server.Close()
+
+	publish := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte("foo")},
+		PublishPayload: []byte("bar"),
+	}
+
+	go mqtt.NewWriter(client).WritePacket(publish)
+
+	packet, err := mqtt.NewReader(server).ReadPacket()
+	assert.NoError(err)
+	assert.Equal(publish, packet)
+
+	// Multiple packets can be combined into a single frame.
+	var buf bytes.Buffer
+	w := mqtt.NewWriter(&buf)
+	w.WritePacket(new(mqtt.PingreqPacket))
+	w.WritePacket(publish)
+	go server.(*Conn).writeFrame(opBinary, buf.Bytes())
+
+	r := mqtt.NewReader(client)
+	packet, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(mqtt.PINGREQ, packet.PacketType())
+	packet, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(publish, packet)
+
+	client.Close()
+	_, err = server.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+}
+
+// recordingConn records the writes to the connection.
+type recordingConn struct {
+	net.Conn
+	writes [][]byte
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestWriteFramePerPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	nc := &recordingConn{}
+	conn := newConn(nc, bufio.NewReader(bytes.NewReader(nil)), true, Subprotocol)
+	w := mqtt.NewWriter(conn)
+	w.SetProtocol(5)
+
+	publish := &mqtt.PublishPacket{
+		PublishHeader:  mqtt.PublishHeader{TopicName: []byte("foo"), PacketIdentifier: 1},
+		Properties:     mqtt.Properties{{Identifier: mqtt.ContentType, BytesValue: []byte("text/plain")}},
+		PublishPayload: []byte("bar"),
+	}
+	publish.SetQoS(mqtt.QoS1)
+	assert.NoError(w.WritePacket(publish))
+	assert.NoError(w.WritePacket(&mqtt.PingreqPacket{}))
+
+	if !assert.Len(nc.writes, 2) {
+		return
+	}
+	for _, frame := range nc.writes {
+		server := newConn(nil, bufio.NewReader(bytes.NewReader(frame)), false, Subprotocol)
+		assert.NoError(server.readFrameHeader())
+		assert.Equal(uint64(len(frame)-6), server.remaining) // 2 byte header, 4 byte mask.
+	}
+
+	r := mqtt.NewReader(newConn(nil, bufio.NewReader(bytes.NewReader(bytes.Join(nc.writes, nil))), false, Subprotocol))
+	r.SetProtocol(5)
+	packet, err := r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(publish, packet)
+	packet, err = r.ReadPacket()
+	assert.NoError(err)
+	assert.Equal(mqtt.PINGREQ, packet.PacketType())
+
+	// Packet boundaries do not depend on how the packets are written.
+	var buf bytes.Buffer
+	bw := mqtt.NewWriter(&buf)
+	bw.SetProtocol(5)
+	bw.WritePacket(publish)
+	bw.WritePacket(&mqtt.PingreqPacket{})
+	for _, size := range []int{1, 3, buf.Len()} {
+		nc.writes = nil
+		for b := buf.Bytes(); len(b) > 0; {
+			n := size
+			if n > len(b) {
+				n = len(b)
+			}
+			_, err := conn.Write(b[:n])
+			assert.NoError(err)
+			b = b[n:]
+		}
+		assert.Len(nc.writes, 2)
+	}
+}
+
+func TestDialContextCanceled(t *testing.T) {
+	assert := assert.New(t)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(err) {
+		return
+	}
+	defer lis.Close()
+	go func() {
+		// Accept the connection, but never respond to the handshake.
+		conn, err := lis.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = DefaultDialer.DialContext(ctx, "ws://"+lis.Addr().String())
+	assert.Equal(context.Canceled, err)
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, url, close := newTestServer()
+	defer close()
+	url = "http" + strings.TrimPrefix(url, "ws")
+
+	res, err := http.Get(url)
+	assert.NoError(err)
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Protocol", "chat")
+	res, err = http.DefaultClient.Do(req)
+	assert.NoError(err)
+	assert.Equal(http.StatusBadRequest, res.StatusCode)
+}
+
+func TestReadFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  []byte
+		payload []byte
+		err     error
+		reply   []byte
+	}{
+		{
+			name:    "fragmented",
+			frames:  []byte{0x02, 0x82, 0, 0, 0, 0, 0xc0, 0x00, 0x80, 0x80, 0, 0, 0, 0},
+			payload: []byte{0xc0, 0x00},
+			err:     io.EOF,
+		},
+		{
+			name:    "masked",
+			frames:  []byte{0x82, 0x82, 0x01, 0x02, 0x03, 0x04, 0xc1, 0x02},
+			payload: []byte{0xc0, 0x00},
+			err:     io.EOF,
+		},
+		{
+			name:    "ping",
+			frames:  []byte{0x89, 0x81, 0, 0, 0, 0, 'x', 0x82, 0x81, 0, 0, 0, 0, 0xc0},
+			payload: []byte{0xc0},
+			err:     io.EOF,
+			reply:   []byte{0x8a, 0x01, 'x'},
+		},
+		{
+			name:   "text",
+			frames: []byte{0x81, 0x81, 0, 0, 0, 0, 'x'},
+			err:    errTextFrame,
+			reply:  []byte{0x88, 0x02, 0x03, 0xeb},
+		},
+		{
+			name:   "unmasked",
+			frames: []byte{0x82, 0x01, 0xc0},
+			err:    errInvalidMasking,
+			reply:  []byte{0x88, 0x02, 0x03, 0xea},
+		},
+		{
+			name:   "close",
+			frames: []byte{0x88, 0x80, 0, 0, 0, 0},
+			err:    io.EOF,
+			reply:  []byte{0x88, 0x02, 0x03, 0xe8},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			local, remote := net.Pipe()
+			conn := newConn(local, bufio.NewReader(bytes.NewReader(test.frames)), false, Subprotocol)
+
+			replies := make(chan []byte, 1)
+			go func() {
+				var buf bytes.Buffer
+				if n, _ := io.Copy(&buf, remote); n == 0 {
+					replies <- nil
+					return
+				}
+				replies <- buf.Bytes()
+			}()
+
+			var payload []byte
+			var err error
+			for err == nil {
+				b := make([]byte, 16)
+				var n int
+				n, err = conn.Read(b)
+				payload = append(payload, b[:n]...)
+			}
+			assert.Equal(test.err, err)
+			assert.Equal(test.payload, payload)
+
+			conn.Conn.Close()
+			assert.Equal(test.reply, <-replies)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"io"
 	"sync"
+	"time"
 )
 
 // WriterOption is an option for the PacketWriter.
@@ -19,12 +20,17 @@ func (f writerOptionFunc) apply(w *PacketWriter) {
 
 // PacketWriter writes MQTT packets.
 type PacketWriter struct {
-	w        io.Writer
-	protocol byte
-	mu       sync.Mutex
-	nWritten uint32
-	packet   Packet
-	err      error
+	w              io.Writer
+	writeDeadliner writeDeadliner
+	protocol       byte
+	strict         bool
+	observer       Observer
+	mu             sync.Mutex
+	nWritten       uint32
+	nWrittenTotal  uint64
+	packet         Packet
+	err            error
+	abortErr       error
 }
 
 // SetProtocol sets the MQTT protocol version.
@@ -40,6 +46,9 @@ func NewWriter(wr io.Writer, opts ...WriterOption) *PacketWriter {
 		w:        wr,
 		protocol: DefaultProtocolVersion,
 	}
+	if d, ok := wr.(writeDeadliner); ok {
+		pw.writeDeadliner = d
+	}
 	for _, opt := range opts {
 		opt.apply(pw)
 	}
@@ -98,8 +107,30 @@ func (w *PacketWriter) writePayload() {
 func (w *PacketWriter) WritePacket(packet Packet) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.writePacket(packet)
+}
+
+func (w *PacketWriter) writePacket(packet Packet) (err error) {
+	if w.observer != nil {
+		start, nWrittenBefore := time.Now(), w.nWrittenTotal
+		defer func() { w.observeWrite(start, nWrittenBefore, packet, err) }()
+	}
+	if w.abortErr != nil {
+		return w.abortErr
+	}
+	if packet, ok := packet.(*EncodedPublishPacket); ok {
+		return w.writeEncodedPublish(packet)
+	}
+	if err := w.checkStrict(packet); err != nil {
+		return err
+	}
+	if w.protocol >= 5 {
+		if err := validatePropertyValues(packet); err != nil {
+			return err
+		}
+	}
 	w.packet = packet
-	w.writeFixedHeader()
+	w.err = w.writeFixedHeader()
 	if w.err != nil {
 		return w.err
 	}
@@ -120,6 +151,7 @@ func (w *PacketWriter) WritePacket(packet Packet) error {
 
 func (w *PacketWriter) write(buf []byte) error {
 	n, err := w.w.Write(buf)
+	w.nWrittenTotal += uint64(n)
 	if err != nil {
 		return err
 	}
@@ -156,7 +188,7 @@ func (w *PacketWriter) writeBytes(b []byte) error {
 		return errInvalidBytesLength
 	}
 	err := w.writeUint16(uint16(len(b)))
-	if err != nil {
+	if err != nil || len(b) == 0 {
 		return err
 	}
 	return w.write(b)